@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -13,6 +14,7 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/veverkap/calibre-web-automated-book-downloader/internal/api"
 	"github.com/veverkap/calibre-web-automated-book-downloader/internal/config"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/downloader"
 	"go.uber.org/zap"
 )
 
@@ -40,10 +42,13 @@ func main() {
 	r.Use(middleware.RealIP)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(60 * time.Second))
+	r.Use(timeoutExceptEventStreams(60 * time.Second))
+
+	// Share mirrors, bypasser session, DNS and request limits between all requests
+	network := downloader.NewNetwork(cfg)
 
 	// Initialize API handlers
-	handler := api.NewHandler(cfg, logger)
+	handler := api.NewHandler(cfg, logger, network)
 
 	// Register routes
 	handler.RegisterRoutes(r)
@@ -89,3 +94,19 @@ func main() {
 
 	logger.Info("Server exited")
 }
+
+// timeoutExceptEventStreams applies middleware.Timeout to every request
+// except the long lived Server-Sent Events stream
+func timeoutExceptEventStreams(timeout time.Duration) func(http.Handler) http.Handler {
+	withTimeout := middleware.Timeout(timeout)
+	return func(next http.Handler) http.Handler {
+		timed := withTimeout(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(r.URL.Path, "/api/events") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			timed.ServeHTTP(w, r)
+		})
+	}
+}
@@ -2,13 +2,22 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"mime"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/auth"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/backend"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/bookmanager"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/downloader"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/library"
 	"github.com/veverkap/calibre-web-automated-book-downloader/internal/models"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/throttle"
 	"go.uber.org/zap"
 )
 
@@ -43,16 +52,68 @@ func (h *Handler) handleSearch(w http.ResponseWriter, r *http.Request) {
 		filters.Format = format
 	}
 
-	h.logger.Info("Search request", zap.Any("filters", filters))
+	searchQuery := query.Get("query")
 
-	// TODO: Implement actual search logic
-	// For now, return a placeholder response
-	h.writeJSON(w, http.StatusOK, map[string]interface{}{
-		"status": "success",
-		"message": "Search functionality not yet implemented",
-		"filters": filters,
-		"results": []interface{}{},
-	})
+	h.logger.Info("Search request",
+		zap.String("query", searchQuery),
+		zap.Any("filters", filters))
+
+	// Nothing to search for, mirror the Python API and return no results
+	if searchQuery == "" && isEmptySearchFilters(filters) {
+		h.writeJSON(w, http.StatusOK, []models.BookInfo{})
+		return
+	}
+
+	books, err := bookmanager.SearchBooks(r.Context(), h.config, h.network, searchQuery, *filters)
+	if err != nil {
+		if errors.Is(err, bookmanager.ErrNoBooksFound) {
+			h.writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		h.logger.Error("Search failed",
+			zap.String("query", searchQuery),
+			zap.Error(err))
+		h.writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	if books == nil {
+		books = []models.BookInfo{}
+	}
+
+	for i := range books {
+		books[i].InLibrary = h.libraryMatch(&books[i]) != nil
+	}
+
+	h.writeJSON(w, http.StatusOK, books)
+}
+
+// libraryMatch returns the book of the Calibre library matching book, if any.
+// Lookup failures are logged and treated as no match.
+func (h *Handler) libraryMatch(book *models.BookInfo) *library.Match {
+	if h.config.DuplicatePolicy == library.PolicyOff || !h.library.Enabled() {
+		return nil
+	}
+
+	match, err := h.library.Lookup(book)
+	if err != nil {
+		h.logger.Warn("Failed to look book up in the Calibre library",
+			zap.String("book_id", book.ID),
+			zap.Error(err))
+		return nil
+	}
+	return match
+}
+
+// isEmptySearchFilters reports whether no search filter has been set
+func isEmptySearchFilters(filters *models.SearchFilters) bool {
+	return len(filters.ISBN) == 0 &&
+		len(filters.Author) == 0 &&
+		len(filters.Title) == 0 &&
+		len(filters.Lang) == 0 &&
+		filters.Sort == nil &&
+		len(filters.Content) == 0 &&
+		len(filters.Format) == 0
 }
 
 // handleInfo handles book info requests
@@ -66,12 +127,24 @@ func (h *Handler) handleInfo(w http.ResponseWriter, r *http.Request) {
 
 	h.logger.Info("Info request", zap.String("book_id", bookID))
 
-	// TODO: Implement actual book info retrieval
-	h.writeJSON(w, http.StatusOK, map[string]interface{}{
-		"status": "success",
-		"message": "Book info functionality not yet implemented",
-		"book_id": bookID,
-	})
+	book, err := h.infoCache.GetBookInfo(r.Context(), h.config, h.network, bookID)
+	if err != nil {
+		h.logger.Error("Failed to get book info",
+			zap.String("book_id", bookID),
+			zap.Error(err))
+		h.writeError(w, upstreamStatus(err), err.Error())
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, book)
+}
+
+// upstreamStatus returns the status code for a failed request to Anna's Archive
+func upstreamStatus(err error) int {
+	if errors.Is(err, downloader.ErrNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadGateway
 }
 
 // handleDownload handles download requests
@@ -93,26 +166,136 @@ func (h *Handler) handleDownload(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Queue books already in the library anyway
+	force := false
+	if f := r.URL.Query().Get("force"); f != "" {
+		var err error
+		force, err = strconv.ParseBool(f)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, "Invalid force value")
+			return
+		}
+	}
+
 	h.logger.Info("Download request", 
 		zap.String("book_id", bookID),
 		zap.Int("priority", priority))
 
-	// TODO: Add book to download queue
-	// For now, return a placeholder response
-	h.writeJSON(w, http.StatusOK, map[string]interface{}{
+	// Avoid scraping the book page again for books we already track
+	if h.backend.IsQueued(bookID) {
+		h.writeAlreadyQueued(w, bookID)
+		return
+	}
+
+	book, err := h.infoCache.GetBookInfo(r.Context(), h.config, h.network, bookID)
+	if err != nil {
+		h.logger.Error("Failed to get book info",
+			zap.String("book_id", bookID),
+			zap.Error(err))
+		h.writeError(w, upstreamStatus(err), err.Error())
+		return
+	}
+
+	match := h.libraryMatch(book)
+	if match != nil && h.config.DuplicatePolicy == library.PolicyRefuse && !force {
+		h.logger.Info("Refusing to download a book already in the library",
+			zap.String("book_id", bookID),
+			zap.Int64("calibre_id", match.CalibreID))
+		h.writeJSON(w, http.StatusConflict, map[string]interface{}{
+			"status":        "in_library",
+			"error":         "Book is already in the Calibre library",
+			"book_id":       bookID,
+			"library_match": match,
+		})
+		return
+	}
+
+	if user, ok := auth.UserFromContext(r.Context()); ok {
+		book.RequestedBy = user.Name
+	}
+
+	if err := h.backend.QueueBook(bookID, book, priority); err != nil {
+		if errors.Is(err, backend.ErrAlreadyQueued) {
+			h.writeAlreadyQueued(w, bookID)
+			return
+		}
+		var quotaErr *backend.QuotaError
+		if errors.As(err, &quotaErr) {
+			h.writeQuotaExceeded(w, bookID, quotaErr)
+			return
+		}
+		h.logger.Error("Failed to queue book",
+			zap.String("book_id", bookID),
+			zap.Error(err))
+		h.writeError(w, http.StatusInternalServerError, "Failed to queue book")
+		return
+	}
+
+	response := map[string]interface{}{
 		"status": "success",
 		"message": "Download queued",
 		"book_id": bookID,
 		"priority": priority,
+	}
+	if match != nil {
+		response["warning"] = "Book is already in the Calibre library"
+		response["library_match"] = match
+	}
+	h.writeJSON(w, http.StatusOK, response)
+}
+
+// writeAlreadyQueued writes the response for a book that is already in the queue
+func (h *Handler) writeAlreadyQueued(w http.ResponseWriter, bookID string) {
+	h.writeJSON(w, http.StatusConflict, map[string]interface{}{
+		"status":  "already_queued",
+		"error":   "Book is already queued or downloading",
+		"book_id": bookID,
+	})
+}
+
+// writeQuotaExceeded writes the response for a download refused by a user quota
+func (h *Handler) writeQuotaExceeded(w http.ResponseWriter, bookID string, err *backend.QuotaError) {
+	h.logger.Info("Download quota exceeded",
+		zap.String("book_id", bookID),
+		zap.String("user", err.User),
+		zap.String("quota", err.Quota))
+
+	if !err.RetryAt.IsZero() {
+		seconds := int(math.Ceil(time.Until(err.RetryAt).Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+	}
+	h.writeJSON(w, http.StatusTooManyRequests, map[string]interface{}{
+		"status":  "quota_exceeded",
+		"error":   err.Error(),
+		"book_id": bookID,
+		"quota":   err.Quota,
+		"limit":   err.Limit,
 	})
 }
 
 // handleStatus handles status requests
-// GET /api/status
+// GET /api/status?mine=<bool>
 func (h *Handler) handleStatus(w http.ResponseWriter, r *http.Request) {
-	status := h.backend.GetQueueStatus()
+	// Only list the downloads requested by the current user
+	mine := false
+	if m := r.URL.Query().Get("mine"); m != "" {
+		var err error
+		mine, err = strconv.ParseBool(m)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, "Invalid mine value")
+			return
+		}
+	}
+
+	var status map[models.QueueStatus]map[string]*models.BookInfo
+	if mine {
+		user, _ := auth.UserFromContext(r.Context())
+		status = h.backend.GetUserQueueStatus(user.Name)
+	} else {
+		status = h.backend.GetQueueStatus()
+	}
 	
-	h.logger.Info("Status request")
+	h.logger.Info("Status request", zap.Bool("mine", mine))
 
 	h.writeJSON(w, http.StatusOK, map[string]interface{}{
 		"status": "success",
@@ -120,6 +303,17 @@ func (h *Handler) handleStatus(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleQuota reports the download quotas of the current user
+// GET /api/quota
+func (h *Handler) handleQuota(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+
+	h.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"quota":  h.backend.QuotaUsage(user.Name),
+	})
+}
+
 // handleLocalDownload handles local file download
 // GET /api/localdownload?id=<book_id>
 func (h *Handler) handleLocalDownload(w http.ResponseWriter, r *http.Request) {
@@ -168,6 +362,10 @@ func (h *Handler) handleCancelDownload(w http.ResponseWriter, r *http.Request) {
 
 	h.logger.Info("Cancel download request", zap.String("book_id", bookID))
 
+	if !h.checkOwner(w, r, bookID, "cancel") {
+		return
+	}
+
 	success := h.backend.CancelDownload(bookID)
 	
 	if success {
@@ -181,6 +379,58 @@ func (h *Handler) handleCancelDownload(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// checkOwner lets users act only on their own downloads and admins on any.
+// It writes a 403 and returns false when the user may not.
+func (h *Handler) checkOwner(w http.ResponseWriter, r *http.Request, bookID, action string) bool {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok || user.IsAdmin() {
+		return true
+	}
+	book, exists := h.backend.GetBook(bookID)
+	if !exists || book.RequestedBy == user.Name {
+		return true
+	}
+
+	h.logger.Warn("Permission denied",
+		zap.String("username", user.Name),
+		zap.String("book_id", bookID),
+		zap.String("requested_by", book.RequestedBy))
+	h.writeError(w, http.StatusForbidden, "Permission denied: only admins may "+action+" downloads of other users")
+	return false
+}
+
+// handleRetryDownload queues a failed or cancelled download again
+// POST /api/download/{book_id}/retry
+func (h *Handler) handleRetryDownload(w http.ResponseWriter, r *http.Request) {
+	bookID := chi.URLParam(r, "book_id")
+	if bookID == "" {
+		h.writeError(w, http.StatusBadRequest, "Missing book ID")
+		return
+	}
+
+	h.logger.Info("Retry download request", zap.String("book_id", bookID))
+
+	if !h.checkOwner(w, r, bookID, "retry") {
+		return
+	}
+
+	if err := h.backend.RetryDownload(bookID); err != nil {
+		var quotaErr *backend.QuotaError
+		if errors.As(err, &quotaErr) {
+			h.writeQuotaExceeded(w, bookID, quotaErr)
+			return
+		}
+		h.writeError(w, http.StatusNotFound, "Book not found or cannot be retried")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":  "success",
+		"message": "Download queued for retry",
+		"book_id": bookID,
+	})
+}
+
 // handleSetPriority handles priority update requests
 // PUT /api/queue/{book_id}/priority
 func (h *Handler) handleSetPriority(w http.ResponseWriter, r *http.Request) {
@@ -280,3 +530,200 @@ func (h *Handler) handleClearCompleted(w http.ResponseWriter, r *http.Request) {
 		"count": count,
 	})
 }
+
+// handleWorkers reports the download workers and what they are doing
+// GET /api/workers
+func (h *Handler) handleWorkers(w http.ResponseWriter, r *http.Request) {
+	h.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":  "success",
+		"size":    h.workerPool.Size(),
+		"workers": h.workerPool.Workers(),
+	})
+}
+
+// handleResizeWorkers changes the number of download workers. Removed
+// workers finish their current download before they stop.
+// PUT /api/workers
+func (h *Handler) handleResizeWorkers(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Size *int `json:"size"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Size == nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	h.logger.Info("Resize workers request", zap.Int("size", *req.Size))
+
+	if err := h.workerPool.Resize(*req.Size); err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":  "success",
+		"size":    h.workerPool.Size(),
+		"workers": h.workerPool.Workers(),
+	})
+}
+
+// handleBandwidth reports the download bandwidth limits
+// GET /api/bandwidth
+func (h *Handler) handleBandwidth(w http.ResponseWriter, r *http.Request) {
+	h.writeBandwidth(w, h.network.Bandwidth)
+}
+
+// handleSetBandwidth changes the download bandwidth limits at runtime.
+// Fields left out keep their current value.
+// PUT /api/bandwidth
+func (h *Handler) handleSetBandwidth(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Limit       *string `json:"limit"`
+		PerDownload *string `json:"per_download"`
+		Schedule    *string `json:"schedule"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	limiter := h.network.Bandwidth
+	settings := limiter.Settings()
+	var err error
+	if req.Limit != nil {
+		if settings.Limit, err = throttle.ParseRate(*req.Limit); err != nil {
+			h.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if req.PerDownload != nil {
+		if settings.PerDownload, err = throttle.ParseRate(*req.PerDownload); err != nil {
+			h.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if req.Schedule != nil {
+		if settings.Schedule, err = throttle.ParseSchedule(*req.Schedule); err != nil {
+			h.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	limiter.SetSettings(settings)
+	h.logger.Info("Bandwidth limits updated",
+		zap.Int64("limit", settings.Limit),
+		zap.Int64("per_download", settings.PerDownload),
+		zap.String("schedule", settings.Schedule.String()))
+
+	h.writeBandwidth(w, limiter)
+}
+
+// writeBandwidth writes the settings of limiter
+func (h *Handler) writeBandwidth(w http.ResponseWriter, limiter *throttle.Limiter) {
+	settings := limiter.Settings()
+	h.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":          "success",
+		"limit":           throttle.FormatRate(settings.Limit),
+		"per_download":    throttle.FormatRate(settings.PerDownload),
+		"schedule":        settings.Schedule.String(),
+		"effective_limit": limiter.EffectiveLimit(),
+	})
+}
+
+// handleMirrors reports the Anna's Archive mirrors and the one in use
+// GET /api/mirrors
+func (h *Handler) handleMirrors(w http.ResponseWriter, r *http.Request) {
+	status := h.network.Mirrors.Status()
+
+	h.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":        "success",
+		"active_mirror": status.Active,
+		"mirrors":       status.Mirrors,
+	})
+}
+
+// eventsHeartbeatInterval is how often a comment is sent to keep idle event
+// streams open through proxies
+var eventsHeartbeatInterval = 15 * time.Second
+
+// handleEvents streams queue changes as Server-Sent Events. The stream starts
+// with a snapshot of the queue status followed by one event per change. A
+// client that falls behind is disconnected and gets a new snapshot when it
+// reconnects.
+// GET /api/events
+func (h *Handler) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		h.writeError(w, http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+
+	// Subscribe before taking the snapshot so no change is missed in between
+	events, unsubscribe := h.backend.SubscribeEvents()
+	defer unsubscribe()
+
+	// Event streams stay open far longer than the server write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		h.logger.Warn("Failed to clear write deadline for event stream", zap.Error(err))
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	h.logger.Debug("Event stream opened")
+	defer h.logger.Debug("Event stream closed")
+
+	snapshot := map[string]interface{}{
+		"queue_status": h.backend.GetQueueStatus(),
+	}
+	if err := writeEvent(w, "snapshot", 0, snapshot); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-h.done:
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event, ok := <-events:
+			if !ok {
+				// Dropped for falling behind, the client reconnects for a new snapshot
+				return
+			}
+			if err := writeEvent(w, string(event.Type), event.ID, event); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeEvent writes a single Server-Sent Event with a JSON payload
+func writeEvent(w http.ResponseWriter, eventType string, id uint64, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id > 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, payload)
+	return err
+}
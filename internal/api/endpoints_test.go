@@ -1,15 +1,29 @@
 package api
 
 import (
+	"bufio"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/auth"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/backend"
 	"github.com/veverkap/calibre-web-automated-book-downloader/internal/config"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/downloader"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/library"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/mirror"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/models"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/pbkdf2"
 )
 
 func setupTestHandler() *Handler {
@@ -19,7 +33,13 @@ func setupTestHandler() *Handler {
 		StatusTimeout: 3600,
 	}
 	logger, _ := zap.NewDevelopment()
-	return NewHandler(cfg, logger)
+	return NewHandler(cfg, logger, downloader.NewNetwork(cfg))
+}
+
+// setMirror points the handler at the Anna's Archive mirror baseURL
+func setMirror(handler *Handler, baseURL string) {
+	handler.config.AABaseURL = baseURL
+	handler.network.Mirrors = mirror.NewManager(mirror.Candidates(handler.config))
 }
 
 func TestHandleStatus(t *testing.T) {
@@ -44,25 +64,122 @@ func TestHandleStatus(t *testing.T) {
 	}
 }
 
+const testSearchResultsHTML = `
+<html><body>
+<table>
+<tr>
+	<td><img src="https://example.com/cover.jpg" /></td>
+	<td><a href="/md5/abc123def456"><span></span>Test Book Title</a></td>
+	<td><span></span>John Doe</td>
+	<td><span></span>Test Publisher</td>
+	<td><span></span>2023</td>
+	<td><span></span></td>
+	<td><span></span></td>
+	<td><span></span>English</td>
+	<td><span></span></td>
+	<td><span></span>epub</td>
+	<td><span></span>5.2 MB</td>
+</tr>
+</table>
+</body></html>
+`
+
 func TestHandleSearch(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/search" {
+			t.Errorf("Unexpected upstream path %s", r.URL.Path)
+		}
+		if got := r.URL.Query().Get("q"); got != "dune" {
+			t.Errorf("Expected upstream query 'dune', got '%s'", got)
+		}
+		w.Write([]byte(testSearchResultsHTML))
+	}))
+	defer upstream.Close()
+
 	handler := setupTestHandler()
-	
-	req := httptest.NewRequest("GET", "/api/search?title=test&author=author1&author=author2", nil)
+	setMirror(handler, upstream.URL)
+
+	req := httptest.NewRequest("GET", "/api/search?query=dune&title=test&author=author1&author=author2", nil)
 	w := httptest.NewRecorder()
-	
+
 	handler.handleSearch(w, req)
-	
+
 	if w.Code != http.StatusOK {
-		t.Errorf("Expected status code %d, got %d", http.StatusOK, w.Code)
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
 	}
-	
-	var response map[string]interface{}
-	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+
+	var books []map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&books); err != nil {
 		t.Fatalf("Failed to decode response: %v", err)
 	}
-	
-	if response["status"] != "success" {
-		t.Errorf("Expected status 'success', got '%v'", response["status"])
+
+	if len(books) != 1 {
+		t.Fatalf("Expected 1 book, got %d", len(books))
+	}
+
+	if books[0]["id"] != "abc123def456" {
+		t.Errorf("Expected id 'abc123def456', got '%v'", books[0]["id"])
+	}
+	if books[0]["title"] != "Test Book Title" {
+		t.Errorf("Expected title 'Test Book Title', got '%v'", books[0]["title"])
+	}
+	if books[0]["format"] != "epub" {
+		t.Errorf("Expected format 'epub', got '%v'", books[0]["format"])
+	}
+}
+
+func TestHandleSearchEmptyQuery(t *testing.T) {
+	handler := setupTestHandler()
+
+	req := httptest.NewRequest("GET", "/api/search", nil)
+	w := httptest.NewRecorder()
+
+	handler.handleSearch(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	if body := strings.TrimSpace(w.Body.String()); body != "[]" {
+		t.Errorf("Expected empty JSON array, got %s", body)
+	}
+}
+
+func TestHandleSearchNoResults(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html><body>No files found.</body></html>"))
+	}))
+	defer upstream.Close()
+
+	handler := setupTestHandler()
+	setMirror(handler, upstream.URL)
+
+	req := httptest.NewRequest("GET", "/api/search?query=nothing", nil)
+	w := httptest.NewRecorder()
+
+	handler.handleSearch(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestHandleSearchUpstreamError(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer upstream.Close()
+
+	handler := setupTestHandler()
+	setMirror(handler, upstream.URL)
+
+	req := httptest.NewRequest("GET", "/api/search?query=dune", nil)
+	w := httptest.NewRecorder()
+
+	handler.handleSearch(w, req)
+
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadGateway, w.Code)
 	}
 }
 
@@ -202,8 +319,119 @@ func TestHandleDownloadMissingID(t *testing.T) {
 	}
 }
 
+const testBookPageHTML = `
+<html>
+	<body>
+		<div class="main-inner"></div>
+		<div>
+			<div>🔍Test Book Title</div>
+			<div>Test Author</div>
+			<div>Test Publisher</div>
+			<div>epub · 5.2 MB</div>
+			<div>
+				<div>
+					<div><div>ISBN-13</div><div>978-1234567890</div></div>
+					<div><div>Goodreads</div><div>12345</div></div>
+				</div>
+			</div>
+			<div></div>
+			<div></div>
+			<div></div>
+			<div></div>
+			<div></div>
+		</div>
+		<a href="/slow_download/test-book/0/0">Slow Partner Server #1</a>
+		<a href="https://libgen.li/ads.php?md5=test-book">Libgen.li: click "GET" at the top</a>
+	</body>
+</html>
+`
+
+func newTestBookPageServer(t *testing.T, hits *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/md5/") {
+			t.Errorf("Unexpected upstream path %s", r.URL.Path)
+		}
+		if hits != nil {
+			atomic.AddInt32(hits, 1)
+		}
+		w.Write([]byte(testBookPageHTML))
+	}))
+}
+
+func TestHandleInfo(t *testing.T) {
+	var hits int32
+	upstream := newTestBookPageServer(t, &hits)
+	defer upstream.Close()
+
+	handler := setupTestHandler()
+	setMirror(handler, upstream.URL)
+
+	req := httptest.NewRequest("GET", "/api/info?id=test-book", nil)
+	w := httptest.NewRecorder()
+
+	handler.handleInfo(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var book models.BookInfo
+	if err := json.NewDecoder(w.Body).Decode(&book); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if book.ID != "test-book" || book.Title != "Test Book Title" {
+		t.Errorf("Unexpected book: %+v", book)
+	}
+	if isbn := book.Info["ISBN-13"]; len(isbn) != 1 || isbn[0] != "978-1234567890" {
+		t.Errorf("Expected ISBN-13 metadata, got %v", book.Info)
+	}
+	if goodreads := book.Info["Goodreads"]; len(goodreads) != 1 || goodreads[0] != "12345" {
+		t.Errorf("Expected Goodreads metadata, got %v", book.Info)
+	}
+	if len(book.DownloadURLs) != 1 || !strings.Contains(book.DownloadURLs[0], "libgen.li") {
+		t.Errorf("Expected libgen download URL, got %v", book.DownloadURLs)
+	}
+
+	// A following download request must be served from the cache
+	req = httptest.NewRequest("GET", "/api/download?id=test-book", nil)
+	w = httptest.NewRecorder()
+
+	handler.handleDownload(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	if got := atomic.LoadInt32(&hits); got != 1 {
+		t.Errorf("Expected 1 upstream request, got %d", got)
+	}
+}
+
+func TestHandleInfoUpstreamError(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer upstream.Close()
+
+	handler := setupTestHandler()
+	setMirror(handler, upstream.URL)
+
+	req := httptest.NewRequest("GET", "/api/info?id=missing", nil)
+	w := httptest.NewRecorder()
+
+	handler.handleInfo(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
 func TestHandleDownloadWithPriority(t *testing.T) {
+	upstream := newTestBookPageServer(t, nil)
+	defer upstream.Close()
+
 	handler := setupTestHandler()
+	setMirror(handler, upstream.URL)
 	
 	req := httptest.NewRequest("GET", "/api/download?id=test-book&priority=10", nil)
 	w := httptest.NewRecorder()
@@ -222,4 +450,541 @@ func TestHandleDownloadWithPriority(t *testing.T) {
 	if response["priority"] != float64(10) {
 		t.Errorf("Expected priority 10, got %v", response["priority"])
 	}
+
+	order := handler.bookQueue.GetQueueOrder()
+	if len(order) != 1 {
+		t.Fatalf("Expected 1 queued book, got %d", len(order))
+	}
+	if order[0].ID != "test-book" || order[0].Priority != 10 {
+		t.Errorf("Unexpected queue entry: %+v", order[0])
+	}
+	if order[0].Title != "Test Book Title" {
+		t.Errorf("Expected title 'Test Book Title', got '%s'", order[0].Title)
+	}
+}
+
+func TestHandleDownloadAlreadyQueued(t *testing.T) {
+	upstream := newTestBookPageServer(t, nil)
+	defer upstream.Close()
+
+	handler := setupTestHandler()
+	setMirror(handler, upstream.URL)
+
+	req := httptest.NewRequest("GET", "/api/download?id=test-book", nil)
+	w := httptest.NewRecorder()
+	handler.handleDownload(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/api/download?id=test-book", nil)
+	w = httptest.NewRecorder()
+	handler.handleDownload(w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, got %d", http.StatusConflict, w.Code)
+	}
+
+	var response map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if response["status"] != "already_queued" {
+		t.Errorf("Expected status 'already_queued', got '%v'", response["status"])
+	}
+}
+
+func TestHandleMirrors(t *testing.T) {
+	handler := setupTestHandler()
+	handler.config.AAAdditionalURLs = "https://secondary.example.com"
+	setMirror(handler, "https://primary.example.com")
+
+	req := httptest.NewRequest("GET", "/api/mirrors", nil)
+	w := httptest.NewRecorder()
+
+	handler.handleMirrors(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var response struct {
+		Status       string `json:"status"`
+		ActiveMirror string `json:"active_mirror"`
+		Mirrors      []struct {
+			URL     string `json:"url"`
+			Healthy bool   `json:"healthy"`
+		} `json:"mirrors"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if response.ActiveMirror != "https://primary.example.com" {
+		t.Errorf("Expected active mirror https://primary.example.com, got '%s'", response.ActiveMirror)
+	}
+	if len(response.Mirrors) != 2 || response.Mirrors[1].URL != "https://secondary.example.com" {
+		t.Errorf("Unexpected mirrors %+v", response.Mirrors)
+	}
+}
+
+func TestHandleEvents(t *testing.T) {
+	handler := setupTestHandler()
+	defer handler.Shutdown()
+
+	r := chi.NewRouter()
+	handler.RegisterRoutes(r)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/events")
+	if err != nil {
+		t.Fatalf("Failed to open event stream: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected Content-Type text/event-stream, got %s", ct)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	readEvent := func() (string, string) {
+		var eventType, data string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("Failed to read event stream: %v", err)
+			}
+			line = strings.TrimRight(line, "\n")
+			switch {
+			case line == "":
+				if eventType != "" {
+					return eventType, data
+				}
+			case strings.HasPrefix(line, "event: "):
+				eventType = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}
+
+	if eventType, _ := readEvent(); eventType != "snapshot" {
+		t.Fatalf("Expected snapshot event first, got %s", eventType)
+	}
+
+	handler.bookQueue.Add("test-book", &models.BookInfo{ID: "test-book", Title: "Test Book"}, 0)
+
+	eventType, data := readEvent()
+	if eventType != "added" {
+		t.Fatalf("Expected added event, got %s", eventType)
+	}
+	var event models.Event
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		t.Fatalf("Failed to decode event: %v", err)
+	}
+	if event.BookID != "test-book" || event.Book == nil || event.Book.Title != "Test Book" {
+		t.Errorf("Unexpected event %+v", event)
+	}
+}
+
+func TestHandleRetryDownload(t *testing.T) {
+	handler := setupTestHandler()
+
+	r := chi.NewRouter()
+	r.Post("/api/download/{book_id}/retry", handler.handleRetryDownload)
+
+	req := httptest.NewRequest("POST", "/api/download/test-book/retry", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	// The book doesn't exist, so we expect a 404
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+
+	handler.bookQueue.Add("test-book", &models.BookInfo{ID: "test-book", Title: "Test Book"}, 0)
+	handler.bookQueue.CancelDownload("test-book")
+
+	req = httptest.NewRequest("POST", "/api/download/test-book/retry", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	if !handler.bookQueue.IsPending("test-book") {
+		t.Error("Expected book to be queued again")
+	}
+}
+
+func TestHandleResizeWorkers(t *testing.T) {
+	handler := setupTestHandler()
+	defer handler.Shutdown()
+
+	req := httptest.NewRequest("PUT", "/api/workers", strings.NewReader(`{"size": 3}`))
+	w := httptest.NewRecorder()
+	handler.handleResizeWorkers(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/api/workers", nil)
+	w = httptest.NewRecorder()
+	handler.handleWorkers(w, req)
+
+	var response struct {
+		Size    int `json:"size"`
+		Workers []struct {
+			ID    int    `json:"id"`
+			State string `json:"state"`
+		} `json:"workers"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Size != 3 || len(response.Workers) != 3 {
+		t.Errorf("Expected 3 workers, got %+v", response)
+	}
+
+	for _, body := range []string{`{"size": -1}`, `{}`, `not json`} {
+		req = httptest.NewRequest("PUT", "/api/workers", strings.NewReader(body))
+		w = httptest.NewRecorder()
+		handler.handleResizeWorkers(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d for %s, got %d", http.StatusBadRequest, body, w.Code)
+		}
+	}
+}
+
+func TestHandleSetBandwidth(t *testing.T) {
+	handler := setupTestHandler()
+	limiter := handler.network.Bandwidth
+
+	body := strings.NewReader(`{"limit": "500KB", "schedule": "01:00-07:00=0"}`)
+	req := httptest.NewRequest("PUT", "/api/bandwidth", body)
+	w := httptest.NewRecorder()
+	handler.handleSetBandwidth(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var response struct {
+		Limit       string `json:"limit"`
+		PerDownload string `json:"per_download"`
+		Schedule    string `json:"schedule"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Limit != "500KB" || response.PerDownload != "0" || response.Schedule != "01:00-07:00=0" {
+		t.Errorf("Unexpected bandwidth settings %+v", response)
+	}
+	if limiter.Settings().Limit != 500*1024 {
+		t.Errorf("Expected the shared limiter to be updated, got %+v", limiter.Settings())
+	}
+
+	req = httptest.NewRequest("PUT", "/api/bandwidth", strings.NewReader(`{"schedule": "always"}`))
+	w = httptest.NewRecorder()
+	handler.handleSetBandwidth(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+// newTestCalibreLibrary creates a Calibre metadata.db holding the books served
+// by the test upstream pages
+func newTestCalibreLibrary(t *testing.T) *library.Library {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "metadata.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE TABLE books (id INTEGER PRIMARY KEY, title TEXT NOT NULL);
+		CREATE TABLE authors (id INTEGER PRIMARY KEY, name TEXT NOT NULL);
+		CREATE TABLE books_authors_link (id INTEGER PRIMARY KEY, book INTEGER NOT NULL, author INTEGER NOT NULL);
+		CREATE TABLE identifiers (id INTEGER PRIMARY KEY, book INTEGER NOT NULL, type TEXT NOT NULL, val TEXT NOT NULL);
+		INSERT INTO books VALUES (1, 'Test Book Title'), (2, 'Some Other Title');
+		INSERT INTO authors VALUES (1, 'John Doe'), (2, 'Jane Roe');
+		INSERT INTO books_authors_link (book, author) VALUES (1, 1), (2, 2);
+		INSERT INTO identifiers (book, type, val) VALUES (2, 'isbn', '9781234567890');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return library.New(path)
+}
+
+func TestHandleSearchMarksBooksInLibrary(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testSearchResultsHTML))
+	}))
+	defer upstream.Close()
+
+	handler := setupTestHandler()
+	setMirror(handler, upstream.URL)
+	handler.config.DuplicatePolicy = library.PolicyWarn
+	handler.library = newTestCalibreLibrary(t)
+
+	req := httptest.NewRequest("GET", "/api/search?query=test", nil)
+	w := httptest.NewRecorder()
+
+	handler.handleSearch(w, req)
+
+	var books []models.BookInfo
+	if err := json.NewDecoder(w.Body).Decode(&books); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(books) != 1 || !books[0].InLibrary {
+		t.Errorf("Expected the book to be marked as in the library, got %+v", books)
+	}
+}
+
+func TestHandleDownloadBookInLibrary(t *testing.T) {
+	upstream := newTestBookPageServer(t, nil)
+	defer upstream.Close()
+
+	tests := []struct {
+		name       string
+		policy     string
+		query      string
+		wantStatus int
+		wantQueued bool
+	}{
+		{"warn", library.PolicyWarn, "", http.StatusOK, true},
+		{"refuse", library.PolicyRefuse, "", http.StatusConflict, false},
+		{"refuse with force", library.PolicyRefuse, "&force=true", http.StatusOK, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := setupTestHandler()
+			setMirror(handler, upstream.URL)
+			handler.config.DuplicatePolicy = tt.policy
+			handler.library = newTestCalibreLibrary(t)
+
+			req := httptest.NewRequest("GET", "/api/download?id=test-book"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			handler.handleDownload(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status code %d, got %d", tt.wantStatus, w.Code)
+			}
+
+			var response struct {
+				Warning      string         `json:"warning"`
+				LibraryMatch *library.Match `json:"library_match"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if response.LibraryMatch == nil || response.LibraryMatch.CalibreID != 2 || response.LibraryMatch.MatchedBy != library.MatchISBN {
+				t.Errorf("Expected a match on the ISBN of library book 2, got %+v", response.LibraryMatch)
+			}
+			if queued := handler.backend.IsQueued("test-book"); queued != tt.wantQueued {
+				t.Errorf("Expected queued = %v, got %v", tt.wantQueued, queued)
+			}
+		})
+	}
+}
+
+// withUser returns req as authenticated by user
+func withUser(req *http.Request, user string) *http.Request {
+	return req.WithContext(auth.WithUser(req.Context(), auth.User{Name: user}))
+}
+
+func TestHandleDownloadQuota(t *testing.T) {
+	upstream := newTestBookPageServer(t, nil)
+	defer upstream.Close()
+
+	handler := setupTestHandler()
+	setMirror(handler, upstream.URL)
+	handler.backend.SetQuotas(backend.Quotas{MaxActive: 1})
+
+	tests := []struct {
+		bookID     string
+		user       string
+		wantStatus int
+	}{
+		{"book-1", "alice", http.StatusOK},
+		{"book-2", "alice", http.StatusTooManyRequests},
+		{"book-2", "bob", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req := withUser(httptest.NewRequest("GET", "/api/download?id="+tt.bookID, nil), tt.user)
+		w := httptest.NewRecorder()
+
+		handler.handleDownload(w, req)
+
+		if w.Code != tt.wantStatus {
+			t.Fatalf("%s downloading %s: expected status code %d, got %d", tt.user, tt.bookID, tt.wantStatus, w.Code)
+		}
+		if w.Code == http.StatusTooManyRequests {
+			var response map[string]interface{}
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if response["status"] != "quota_exceeded" || response["quota"] != backend.QuotaActive {
+				t.Errorf("Unexpected response %v", response)
+			}
+		}
+	}
+
+	order := handler.bookQueue.GetQueueOrder()
+	owners := make(map[string]string)
+	for _, item := range order {
+		owners[item.ID] = item.RequestedBy
+	}
+	if len(owners) != 2 || owners["book-1"] != "alice" || owners["book-2"] != "bob" {
+		t.Errorf("Unexpected requesters %v", owners)
+	}
+}
+
+func TestHandleStatusMine(t *testing.T) {
+	handler := setupTestHandler()
+	handler.bookQueue.Add("a", &models.BookInfo{ID: "a", RequestedBy: "alice"}, 0)
+	handler.bookQueue.Add("b", &models.BookInfo{ID: "b", RequestedBy: "bob"}, 0)
+
+	req := withUser(httptest.NewRequest("GET", "/api/status?mine=true", nil), "alice")
+	w := httptest.NewRecorder()
+
+	handler.handleStatus(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var response struct {
+		QueueStatus map[models.QueueStatus]map[string]*models.BookInfo `json:"queue_status"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	queued := response.QueueStatus[models.StatusQueued]
+	if len(queued) != 1 || queued["a"] == nil || queued["a"].RequestedBy != "alice" {
+		t.Errorf("Expected only alice's book, got %v", queued)
+	}
+}
+
+func TestBasicAuthMiddlewareSetsUser(t *testing.T) {
+	handler := setupTestHandler()
+	handler.config.CWADBPath = filepath.Join(t.TempDir(), "app.db")
+	handler.auth = auth.NewAuthenticator("")
+
+	var user auth.User
+	next := handler.basicAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _ = auth.UserFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest("GET", "/api/status", nil)
+	req.SetBasicAuth("alice", "secret")
+	next.ServeHTTP(httptest.NewRecorder(), req)
+
+	if user.Name != "alice" {
+		t.Errorf("Expected user alice in the request context, got %q", user.Name)
+	}
+}
+
+// newTestAppDB creates a Calibre-Web app.db whose users all have the password "secret"
+func newTestAppDB(t *testing.T, users map[string]auth.Role) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "app.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	salt := []byte("testsalt")
+	hash := fmt.Sprintf("pbkdf2:sha256:1000$%s$%s",
+		base64.StdEncoding.EncodeToString(salt),
+		base64.StdEncoding.EncodeToString(pbkdf2.Key([]byte("secret"), salt, 1000, 32, sha256.New)))
+
+	if _, err := db.Exec("CREATE TABLE user (id INTEGER PRIMARY KEY, name TEXT, password TEXT, role SMALLINT)"); err != nil {
+		t.Fatal(err)
+	}
+	for name, role := range users {
+		if _, err := db.Exec("INSERT INTO user (name, password, role) VALUES (?, ?, ?)", name, hash, int(role)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func TestRoleAuthorization(t *testing.T) {
+	upstream := newTestBookPageServer(t, nil)
+	defer upstream.Close()
+
+	handler := setupTestHandler()
+	setMirror(handler, upstream.URL)
+	handler.config.CWADBPath = newTestAppDB(t, map[string]auth.Role{
+		"admin":  auth.RoleAdmin | auth.RoleDownload,
+		"alice":  auth.RoleDownload,
+		"viewer": auth.RoleViewer,
+	})
+	handler.auth = auth.NewAuthenticator(handler.config.CWADBPath)
+	handler.bookQueue.Add("alice-book", &models.BookInfo{ID: "alice-book", RequestedBy: "alice"}, 0)
+	handler.bookQueue.Add("admin-book", &models.BookInfo{ID: "admin-book", RequestedBy: "admin"}, 0)
+	handler.bookQueue.Add("other-book", &models.BookInfo{ID: "other-book", RequestedBy: "alice"}, 0)
+
+	r := chi.NewRouter()
+	handler.RegisterRoutes(r)
+
+	tests := []struct {
+		user       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{"viewer", "GET", "/api/download?id=test-book", "", http.StatusForbidden},
+		{"alice", "GET", "/request/api/download?id=test-book", "", http.StatusOK},
+		{"viewer", "GET", "/api/status", "", http.StatusOK},
+		{"alice", "DELETE", "/api/queue/clear", "", http.StatusForbidden},
+		{"admin", "DELETE", "/api/queue/clear", "", http.StatusOK},
+		{"alice", "POST", "/api/queue/reorder", `{"alice-book": 5}`, http.StatusForbidden},
+		{"alice", "PUT", "/api/queue/alice-book/priority", `{"priority": 5}`, http.StatusForbidden},
+		{"alice", "PUT", "/api/workers", `{"size": 1}`, http.StatusForbidden},
+		{"alice", "PUT", "/api/bandwidth", `{"limit": "1MB"}`, http.StatusForbidden},
+		{"alice", "DELETE", "/api/download/admin-book/cancel", "", http.StatusForbidden},
+		{"alice", "DELETE", "/api/download/alice-book/cancel", "", http.StatusOK},
+		{"admin", "DELETE", "/api/download/other-book/cancel", "", http.StatusOK},
+		{"alice", "POST", "/api/download/admin-book/retry", "", http.StatusForbidden},
+		{"alice", "POST", "/api/download/alice-book/retry", "", http.StatusOK},
+		{"admin", "POST", "/api/download/other-book/retry", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		req.SetBasicAuth(tt.user, "secret")
+		w := httptest.NewRecorder()
+
+		r.ServeHTTP(w, req)
+
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s %s as %s: expected status code %d, got %d: %s", tt.method, tt.path, tt.user, tt.wantStatus, w.Code, w.Body.String())
+		}
+	}
+
+	if !handler.backend.IsQueued("admin-book") {
+		t.Error("Download of another user was cancelled")
+	}
+	handler.backend.CancelDownload("admin-book")
+	req := httptest.NewRequest("POST", "/api/download/admin-book/retry", nil)
+	req.SetBasicAuth("alice", "secret")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden || handler.backend.IsQueued("admin-book") {
+		t.Errorf("Expected alice to be refused retrying the cancelled book of admin, got %d", w.Code)
+	}
 }
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"time"
@@ -8,9 +9,14 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/veverkap/calibre-web-automated-book-downloader/internal/auth"
 	"github.com/veverkap/calibre-web-automated-book-downloader/internal/backend"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/bookmanager"
 	"github.com/veverkap/calibre-web-automated-book-downloader/internal/config"
 	"github.com/veverkap/calibre-web-automated-book-downloader/internal/downloader"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/library"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/mirror"
 	"github.com/veverkap/calibre-web-automated-book-downloader/internal/models"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/storage"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/throttle"
 	"go.uber.org/zap"
 )
 
@@ -21,18 +27,45 @@ type Handler struct {
 	auth       *auth.Authenticator
 	bookQueue  *models.BookQueue
 	workerPool *downloader.WorkerPool
+	network    *downloader.Network
 	backend    *backend.Backend
+	infoCache  *bookmanager.InfoCache
+	library    *library.Library
+	sessions   *auth.SessionManager
+	done       chan struct{}
 }
 
-// NewHandler creates a new API handler
-func NewHandler(cfg *config.Config, logger *zap.Logger) *Handler {
+// NewHandler creates a new API handler making its requests through network
+func NewHandler(cfg *config.Config, logger *zap.Logger, network *downloader.Network) *Handler {
 	authenticator := auth.NewAuthenticator(cfg.CWADBPath)
-	bookQueue := models.NewBookQueue(time.Duration(cfg.StatusTimeout) * time.Second)
-	workerPool := downloader.NewWorkerPool(cfg, logger, bookQueue)
+	sessionTTL := auth.DefaultSessionTTL
+	if cfg.SessionTTL > 0 {
+		sessionTTL = time.Duration(cfg.SessionTTL) * time.Second
+	}
+	bookQueue := newBookQueue(cfg, logger)
+	workerPool := downloader.NewWorkerPool(cfg, logger, bookQueue, network)
 	backendSvc := backend.NewBackend(bookQueue, logger)
+	backendSvc.SetQuotas(backend.QuotasFromConfig(cfg))
 	
 	// Start worker pool
 	workerPool.Start()
+
+	if _, err := throttle.SettingsFromConfig(cfg); err != nil {
+		logger.Warn("Invalid bandwidth settings, downloads are not limited", zap.Error(err))
+	}
+
+	switch cfg.DuplicatePolicy {
+	case library.PolicyOff, library.PolicyWarn, library.PolicyRefuse:
+	default:
+		logger.Warn("Invalid duplicate policy, warning about books already in the library",
+			zap.String("policy", cfg.DuplicatePolicy))
+		cfg.DuplicatePolicy = library.PolicyWarn
+	}
+
+	// Pick a healthy Anna's Archive mirror in the background
+	if len(mirror.Candidates(cfg)) > 1 {
+		go network.ProbeMirrors(context.Background(), logger)
+	}
 	
 	return &Handler{
 		config:     cfg,
@@ -40,15 +73,60 @@ func NewHandler(cfg *config.Config, logger *zap.Logger) *Handler {
 		auth:       authenticator,
 		bookQueue:  bookQueue,
 		workerPool: workerPool,
+		network:    network,
 		backend:    backendSvc,
+		infoCache:  bookmanager.NewInfoCache(bookmanager.DefaultInfoCacheTTL),
+		library:    library.New(library.PathFromConfig(cfg)),
+		sessions:   auth.NewSessionManager(cfg.SessionSecret, sessionTTL),
+		done:       make(chan struct{}),
+	}
+}
+
+// newBookQueue creates the download queue, restoring it from QueueDBPath
+// when persistence is configured
+func newBookQueue(cfg *config.Config, logger *zap.Logger) *models.BookQueue {
+	statusTimeout := time.Duration(cfg.StatusTimeout) * time.Second
+	if cfg.QueueDBPath == "" {
+		return models.NewBookQueue(statusTimeout)
+	}
+
+	store, err := storage.NewSQLiteQueueStore(cfg.QueueDBPath)
+	if err != nil {
+		logger.Error("Failed to open queue database, queue will not be persisted",
+			zap.String("path", cfg.QueueDBPath),
+			zap.Error(err))
+		return models.NewBookQueue(statusTimeout)
+	}
+
+	bookQueue, err := models.NewBookQueueWithStore(statusTimeout, store)
+	if err != nil {
+		logger.Error("Failed to restore queue, queue will not be persisted",
+			zap.String("path", cfg.QueueDBPath),
+			zap.Error(err))
+		store.Close()
+		return models.NewBookQueue(statusTimeout)
 	}
+
+	bookQueue.SetStoreErrorHandler(func(bookID string, err error) {
+		logger.Error("Failed to persist queue change",
+			zap.String("book_id", bookID),
+			zap.Error(err))
+	})
+
+	logger.Info("Queue restored", zap.String("path", cfg.QueueDBPath))
+	return bookQueue
 }
 
 // Shutdown gracefully shuts down the handler and its dependencies
 func (h *Handler) Shutdown() {
+	// Close open event streams so the server can drain its connections
+	close(h.done)
 	if h.workerPool != nil {
 		h.workerPool.Stop()
 	}
+	if err := h.bookQueue.Close(); err != nil {
+		h.logger.Error("Failed to close queue database", zap.Error(err))
+	}
 }
 
 // RegisterRoutes registers all API routes
@@ -67,21 +145,37 @@ func (h *Handler) RegisterRoutes(r chi.Router) {
 	r.Get("/", h.basicAuth(h.handleIndex))
 	r.Get("/request", h.basicAuth(h.handleIndex))
 
+	// Login form and session routes, reachable without authentication
+	r.Get("/login", h.handleLoginPage)
+	r.Get("/request/login", h.handleLoginPage)
+	r.Post("/api/login", h.handleLogin)
+	r.Post("/request/api/login", h.handleLogin)
+	r.Post("/api/logout", h.handleLogout)
+	r.Post("/request/api/logout", h.handleLogout)
+
 	// API routes with authentication
 	r.Route("/api", func(r chi.Router) {
 		r.Use(h.basicAuthMiddleware)
 		
 		r.Get("/search", h.handleSearch)
 		r.Get("/info", h.handleInfo)
-		r.Get("/download", h.handleDownload)
+		r.With(h.requireRole(auth.RoleDownload), h.requireCSRF).Get("/download", h.handleDownload)
 		r.Get("/status", h.handleStatus)
+		r.Get("/quota", h.handleQuota)
 		r.Get("/localdownload", h.handleLocalDownload)
 		r.Delete("/download/{book_id}/cancel", h.handleCancelDownload)
-		r.Put("/queue/{book_id}/priority", h.handleSetPriority)
-		r.Post("/queue/reorder", h.handleReorderQueue)
+		r.With(h.requireRole(auth.RoleDownload)).Post("/download/{book_id}/retry", h.handleRetryDownload)
+		r.With(h.requireRole(auth.RoleAdmin)).Put("/queue/{book_id}/priority", h.handleSetPriority)
+		r.With(h.requireRole(auth.RoleAdmin)).Post("/queue/reorder", h.handleReorderQueue)
 		r.Get("/queue/order", h.handleQueueOrder)
 		r.Get("/downloads/active", h.handleActiveDownloads)
-		r.Delete("/queue/clear", h.handleClearCompleted)
+		r.With(h.requireRole(auth.RoleAdmin)).Delete("/queue/clear", h.handleClearCompleted)
+		r.Get("/mirrors", h.handleMirrors)
+		r.Get("/workers", h.handleWorkers)
+		r.With(h.requireRole(auth.RoleAdmin)).Put("/workers", h.handleResizeWorkers)
+		r.Get("/bandwidth", h.handleBandwidth)
+		r.With(h.requireRole(auth.RoleAdmin)).Put("/bandwidth", h.handleSetBandwidth)
+		r.Get("/events", h.handleEvents)
 	})
 
 	// Register routes with /request prefix
@@ -90,15 +184,23 @@ func (h *Handler) RegisterRoutes(r chi.Router) {
 		
 		r.Get("/search", h.handleSearch)
 		r.Get("/info", h.handleInfo)
-		r.Get("/download", h.handleDownload)
+		r.With(h.requireRole(auth.RoleDownload), h.requireCSRF).Get("/download", h.handleDownload)
 		r.Get("/status", h.handleStatus)
+		r.Get("/quota", h.handleQuota)
 		r.Get("/localdownload", h.handleLocalDownload)
 		r.Delete("/download/{book_id}/cancel", h.handleCancelDownload)
-		r.Put("/queue/{book_id}/priority", h.handleSetPriority)
-		r.Post("/queue/reorder", h.handleReorderQueue)
+		r.With(h.requireRole(auth.RoleDownload)).Post("/download/{book_id}/retry", h.handleRetryDownload)
+		r.With(h.requireRole(auth.RoleAdmin)).Put("/queue/{book_id}/priority", h.handleSetPriority)
+		r.With(h.requireRole(auth.RoleAdmin)).Post("/queue/reorder", h.handleReorderQueue)
 		r.Get("/queue/order", h.handleQueueOrder)
 		r.Get("/downloads/active", h.handleActiveDownloads)
-		r.Delete("/queue/clear", h.handleClearCompleted)
+		r.With(h.requireRole(auth.RoleAdmin)).Delete("/queue/clear", h.handleClearCompleted)
+		r.Get("/mirrors", h.handleMirrors)
+		r.Get("/workers", h.handleWorkers)
+		r.With(h.requireRole(auth.RoleAdmin)).Put("/workers", h.handleResizeWorkers)
+		r.Get("/bandwidth", h.handleBandwidth)
+		r.With(h.requireRole(auth.RoleAdmin)).Put("/bandwidth", h.handleSetBandwidth)
+		r.Get("/events", h.handleEvents)
 	})
 
 	// Error handlers
@@ -106,7 +208,8 @@ func (h *Handler) RegisterRoutes(r chi.Router) {
 	r.MethodNotAllowed(h.handleMethodNotAllowed)
 }
 
-// basicAuthMiddleware is a middleware for Basic Auth
+// basicAuthMiddleware is a middleware authenticating with the session cookie
+// or, for scripts, Basic Auth
 func (h *Handler) basicAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// If no database is configured, skip authentication
@@ -121,15 +224,25 @@ func (h *Handler) basicAuthMiddleware(next http.Handler) http.Handler {
 			// For now, we'll skip this check
 		}
 
+		// Signed-in users are authenticated by their session cookie
+		if session, ok := h.sessionFromRequest(r); ok {
+			if !isSafeMethod(r.Method) && !h.validCSRF(r, session) {
+				h.writeCSRFError(w, r, session)
+				return
+			}
+			next.ServeHTTP(w, withSession(r, session))
+			return
+		}
+
 		// Get Basic Auth credentials
 		username, password, ok := r.BasicAuth()
 		if !ok {
-			h.requestAuth(w)
+			h.requestLogin(w, r)
 			return
 		}
 
 		// Authenticate
-		authenticated, err := h.auth.Authenticate(username, password)
+		user, authenticated, err := h.auth.Authenticate(username, password)
 		if err != nil {
 			h.logger.Error("Authentication error", zap.Error(err))
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -142,14 +255,22 @@ func (h *Handler) basicAuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		h.logger.Info("Authentication successful", zap.String("username", username))
-		next.ServeHTTP(w, r)
+		h.logger.Info("Authentication successful",
+			zap.String("username", username),
+			zap.Stringer("role", user.Role))
+		next.ServeHTTP(w, r.WithContext(auth.WithUser(r.Context(), user)))
 	})
 }
 
-// basicAuth wraps a handler with Basic Auth
+// basicAuth wraps a page with authentication by session cookie or Basic
+// Auth. Visitors without either are sent to the login form.
 func (h *Handler) basicAuth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if session, ok := h.sessionFromRequest(r); ok {
+			next(w, withSession(r, session))
+			return
+		}
+
 		// Get Basic Auth credentials
 		username, password, ok := r.BasicAuth()
 		if !ok {
@@ -158,12 +279,12 @@ func (h *Handler) basicAuth(next http.HandlerFunc) http.HandlerFunc {
 				next(w, r)
 				return
 			}
-			h.requestAuth(w)
+			http.Redirect(w, r, routePrefix(r)+"/login", http.StatusSeeOther)
 			return
 		}
 
 		// Authenticate
-		authenticated, err := h.auth.Authenticate(username, password)
+		user, authenticated, err := h.auth.Authenticate(username, password)
 		if err != nil {
 			h.logger.Error("Authentication error", zap.Error(err))
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -176,8 +297,29 @@ func (h *Handler) basicAuth(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		h.logger.Info("Authentication successful", zap.String("username", username))
-		next(w, r)
+		h.logger.Info("Authentication successful",
+			zap.String("username", username),
+			zap.Stringer("role", user.Role))
+		next(w, r.WithContext(auth.WithUser(r.Context(), user)))
+	}
+}
+
+// requireRole is a middleware that only lets users with the given
+// Calibre-Web role through. Without authentication every request passes.
+func (h *Handler) requireRole(role auth.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := auth.UserFromContext(r.Context())
+			if ok && !user.Role.Has(role) {
+				h.logger.Warn("Permission denied",
+					zap.String("username", user.Name),
+					zap.Stringer("required_role", role),
+					zap.String("path", r.URL.Path))
+				h.writeError(w, http.StatusForbidden, "Permission denied: requires the "+role.String()+" role")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
 	}
 }
 
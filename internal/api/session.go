@@ -0,0 +1,232 @@
+package api
+
+import (
+	"encoding/json"
+	"html"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/auth"
+	"go.uber.org/zap"
+)
+
+// loginRequest is the body of a JSON login request
+type loginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// handleLogin checks the credentials once and starts a session. HTML forms
+// are redirected to the index page, or back to the form when the credentials
+// are wrong; other clients get a JSON response.
+// POST /api/login
+func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	isForm := mediaType != "application/json"
+
+	var credentials loginRequest
+	if isForm {
+		credentials.Username = r.PostFormValue("username")
+		credentials.Password = r.PostFormValue("password")
+	} else if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if credentials.Username == "" {
+		h.writeError(w, http.StatusBadRequest, "Missing username")
+		return
+	}
+
+	user, authenticated, err := h.auth.Authenticate(credentials.Username, credentials.Password)
+	if err != nil {
+		h.logger.Error("Authentication error", zap.Error(err))
+		h.writeError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	if !authenticated {
+		h.logger.Error("Authentication failed", zap.String("username", credentials.Username))
+		if isForm {
+			http.Redirect(w, r, routePrefix(r)+"/login?error=1", http.StatusSeeOther)
+			return
+		}
+		h.writeError(w, http.StatusUnauthorized, "Invalid username or password")
+		return
+	}
+
+	session, token := h.sessions.Issue(user)
+	csrfToken := h.sessions.CSRFToken(session)
+	h.setSessionCookies(w, r, token, csrfToken, int(h.sessions.TTL().Seconds()))
+
+	h.logger.Info("Login successful",
+		zap.String("username", user.Name),
+		zap.Stringer("role", user.Role))
+
+	if isForm {
+		home := routePrefix(r)
+		if home == "" {
+			home = "/"
+		}
+		http.Redirect(w, r, home, http.StatusSeeOther)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":     "success",
+		"user":       user.Name,
+		"csrf_token": csrfToken,
+		"expires_at": session.ExpiresAt(),
+	})
+}
+
+// handleLogout ends the session of the request
+// POST /api/logout
+func (h *Handler) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if session, ok := h.sessionFromRequest(r); ok {
+		if !h.validCSRF(r, session) {
+			h.writeCSRFError(w, r, session)
+			return
+		}
+		h.sessions.Revoke(session)
+		h.logger.Info("Logout", zap.String("username", session.Name))
+	}
+
+	h.setSessionCookies(w, r, "", "", -1)
+	h.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":  "success",
+		"message": "Logged out",
+	})
+}
+
+// handleLoginPage serves the login form
+// GET /login
+func (h *Handler) handleLoginPage(w http.ResponseWriter, r *http.Request) {
+	message := ""
+	if r.URL.Query().Get("error") != "" {
+		message = `<p style="color: #b91c1c;">Invalid username or password.</p>`
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(`<!DOCTYPE html>
+<html>
+<head>
+	<title>Sign in - Calibre-Web Book Downloader</title>
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+</head>
+<body style="font-family: sans-serif; max-width: 20rem; margin: 4rem auto;">
+	<h1>Sign in</h1>
+	<p>Use your Calibre-Web account.</p>
+	` + message + `
+	<form method="post" action="` + html.EscapeString(routePrefix(r)) + `/api/login">
+		<p><label>Username<br><input name="username" autocomplete="username" required autofocus></label></p>
+		<p><label>Password<br><input name="password" type="password" autocomplete="current-password"></label></p>
+		<p><button type="submit">Sign in</button></p>
+	</form>
+</body>
+</html>`))
+}
+
+// requireCSRF is a middleware demanding the CSRF token from session
+// requests, for routes that change state although their method is safe
+func (h *Handler) requireCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if session, ok := auth.SessionFromContext(r.Context()); ok && !h.validCSRF(r, session) {
+			h.writeCSRFError(w, r, session)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sessionFromRequest returns the valid session of the session cookie with
+// the current role of its user. Sessions of deleted users are not valid.
+func (h *Handler) sessionFromRequest(r *http.Request) (*auth.Session, bool) {
+	cookie, err := r.Cookie(auth.SessionCookie)
+	if err != nil || cookie.Value == "" {
+		return nil, false
+	}
+	session, err := h.sessions.Verify(cookie.Value)
+	if err != nil {
+		h.logger.Debug("Ignoring session cookie", zap.Error(err))
+		return nil, false
+	}
+
+	// Roles may have changed since the user signed in
+	user, found, err := h.auth.LookupUser(session.Name)
+	if err != nil {
+		h.logger.Error("Failed to load the role of a session", zap.String("username", session.Name), zap.Error(err))
+		return nil, false
+	}
+	if !found {
+		h.logger.Info("Ignoring session of a deleted user", zap.String("username", session.Name))
+		return nil, false
+	}
+	session.Role = user.Role
+	return session, true
+}
+
+// withSession returns r carrying the session and its user
+func withSession(r *http.Request, session *auth.Session) *http.Request {
+	ctx := auth.WithUser(r.Context(), session.User())
+	return r.WithContext(auth.WithSession(ctx, session))
+}
+
+// validCSRF reports whether the request sends the CSRF token of its session
+func (h *Handler) validCSRF(r *http.Request, session *auth.Session) bool {
+	return h.sessions.CheckCSRF(session, r.Header.Get(auth.CSRFHeader))
+}
+
+// writeCSRFError refuses a session request without a valid CSRF token
+func (h *Handler) writeCSRFError(w http.ResponseWriter, r *http.Request, session *auth.Session) {
+	h.logger.Warn("Missing or invalid CSRF token",
+		zap.String("username", session.Name),
+		zap.String("method", r.Method),
+		zap.String("path", r.URL.Path))
+	h.writeError(w, http.StatusForbidden, "Missing or invalid CSRF token")
+}
+
+// requestLogin answers an unauthenticated API request. Browsers whose session
+// ended get a plain 401 so the UI can show the login form instead of the
+// Basic Auth prompt.
+func (h *Handler) requestLogin(w http.ResponseWriter, r *http.Request) {
+	if _, err := r.Cookie(auth.SessionCookie); err == nil {
+		h.writeError(w, http.StatusUnauthorized, "Session expired, please sign in again")
+		return
+	}
+	h.requestAuth(w)
+}
+
+// setSessionCookies sets the HttpOnly session cookie and the CSRF cookie the
+// UI reads its token from. A negative maxAge deletes both.
+func (h *Handler) setSessionCookies(w http.ResponseWriter, r *http.Request, token, csrfToken string, maxAge int) {
+	secure := r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.SessionCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.CSRFCookie,
+		Value:    csrfToken,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   secure,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// isSafeMethod reports whether a request method does not change state
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// routePrefix returns "/request" for requests to the prefixed routes
+func routePrefix(r *http.Request) string {
+	if r.URL.Path == "/request" || strings.HasPrefix(r.URL.Path, "/request/") {
+		return "/request"
+	}
+	return ""
+}
@@ -0,0 +1,229 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/auth"
+)
+
+// setupSessionTestRouter returns a router of a handler authenticating
+// against an app.db with the admin user alice
+func setupSessionTestRouter(t *testing.T) (*Handler, chi.Router) {
+	t.Helper()
+	handler := setupTestHandler()
+	handler.config.CWADBPath = newTestAppDB(t, map[string]auth.Role{
+		"alice": auth.RoleAdmin | auth.RoleDownload,
+	})
+	handler.auth = auth.NewAuthenticator(handler.config.CWADBPath)
+
+	r := chi.NewRouter()
+	handler.RegisterRoutes(r)
+	return handler, r
+}
+
+// login signs alice in and returns the session cookies and the CSRF token
+func login(t *testing.T, r chi.Router) ([]*http.Cookie, string) {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/request/api/login", strings.NewReader(`{"username": "alice", "password": "secret"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Login failed with status code %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		CSRFToken string `json:"csrf_token"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return w.Result().Cookies(), response.CSRFToken
+}
+
+// serveWithCookies sends a request with the cookies and optional CSRF token
+func serveWithCookies(r chi.Router, method, path string, cookies []*http.Cookie, csrfToken string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	if csrfToken != "" {
+		req.Header.Set(auth.CSRFHeader, csrfToken)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestLoginIssuesSessionCookie(t *testing.T) {
+	_, r := setupSessionTestRouter(t)
+	cookies, csrfToken := login(t, r)
+
+	var session *http.Cookie
+	for _, cookie := range cookies {
+		if cookie.Name == auth.SessionCookie {
+			session = cookie
+		}
+	}
+	if session == nil || !session.HttpOnly || session.MaxAge <= 0 {
+		t.Fatalf("Expected an HttpOnly session cookie with an expiry, got %+v", session)
+	}
+	if csrfToken == "" {
+		t.Error("Expected a CSRF token")
+	}
+
+	// The session is enough, no password is needed
+	if w := serveWithCookies(r, "GET", "/api/status", cookies, ""); w.Code != http.StatusOK {
+		t.Errorf("Expected status code %d with the session cookie, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestLoginRejectsWrongPassword(t *testing.T) {
+	_, r := setupSessionTestRouter(t)
+
+	req := httptest.NewRequest("POST", "/api/login", strings.NewReader(`{"username": "alice", "password": "wrong"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Error("Expected no cookies for a failed login")
+	}
+}
+
+func TestLoginForm(t *testing.T) {
+	_, r := setupSessionTestRouter(t)
+
+	tests := []struct {
+		password     string
+		wantLocation string
+	}{
+		{"secret", "/request"},
+		{"wrong", "/request/login?error=1"},
+	}
+
+	for _, tt := range tests {
+		form := url.Values{"username": {"alice"}, "password": {tt.password}}
+		req := httptest.NewRequest("POST", "/request/api/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusSeeOther || w.Header().Get("Location") != tt.wantLocation {
+			t.Errorf("Login with %q: got %d to %q, want a redirect to %q", tt.password, w.Code, w.Header().Get("Location"), tt.wantLocation)
+		}
+	}
+}
+
+func TestIndexRedirectsToLoginForm(t *testing.T) {
+	_, r := setupSessionTestRouter(t)
+
+	w := serveWithCookies(r, "GET", "/request", nil, "")
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/request/login" {
+		t.Errorf("Expected a redirect to the login form, got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+
+	cookies, _ := login(t, r)
+	if w := serveWithCookies(r, "GET", "/request", cookies, ""); w.Code != http.StatusOK {
+		t.Errorf("Expected status code %d when signed in, got %d", http.StatusOK, w.Code)
+	}
+	if w := serveWithCookies(r, "GET", "/request/login", nil, ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `action="/request/api/login"`) {
+		t.Errorf("Unexpected login form: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSessionRequiresCSRFToken(t *testing.T) {
+	_, r := setupSessionTestRouter(t)
+	cookies, csrfToken := login(t, r)
+
+	tests := []struct {
+		method     string
+		path       string
+		csrfToken  string
+		wantStatus int
+	}{
+		{"DELETE", "/api/queue/clear", "", http.StatusForbidden},
+		{"DELETE", "/api/queue/clear", "invalid", http.StatusForbidden},
+		{"DELETE", "/api/queue/clear", csrfToken, http.StatusOK},
+		{"GET", "/api/download?id=test-book", "", http.StatusForbidden},
+		{"GET", "/api/queue/order", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		if w := serveWithCookies(r, tt.method, tt.path, cookies, tt.csrfToken); w.Code != tt.wantStatus {
+			t.Errorf("%s %s with token %q: expected status code %d, got %d", tt.method, tt.path, tt.csrfToken, tt.wantStatus, w.Code)
+		}
+	}
+
+	// Scripts using Basic Auth need no token
+	req := httptest.NewRequest("DELETE", "/api/queue/clear", nil)
+	req.SetBasicAuth("alice", "secret")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status code %d with Basic Auth, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestLogoutEndsSession(t *testing.T) {
+	_, r := setupSessionTestRouter(t)
+	cookies, csrfToken := login(t, r)
+
+	if w := serveWithCookies(r, "POST", "/api/logout", cookies, ""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected logout without CSRF token to be refused, got %d", w.Code)
+	}
+
+	w := serveWithCookies(r, "POST", "/api/logout", cookies, csrfToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.MaxAge >= 0 {
+			t.Errorf("Expected cookie %s to be deleted, got %+v", cookie.Name, cookie)
+		}
+	}
+
+	// The old cookie no longer works and the UI is not shown a Basic Auth prompt
+	w = serveWithCookies(r, "GET", "/api/status", cookies, "")
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != "" {
+		t.Errorf("Expected a plain 401 after logout, got %d with %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+}
+
+func TestSessionUsesCurrentRole(t *testing.T) {
+	handler, r := setupSessionTestRouter(t)
+	cookies, csrfToken := login(t, r)
+
+	db, err := sql.Open("sqlite3", handler.config.CWADBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Demoted users lose admin rights without signing in again
+	if _, err := db.Exec("UPDATE user SET role = ? WHERE name = 'alice'", int(auth.RoleDownload)); err != nil {
+		t.Fatal(err)
+	}
+	if w := serveWithCookies(r, "DELETE", "/api/queue/clear", cookies, csrfToken); w.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d after the admin role was removed, got %d", http.StatusForbidden, w.Code)
+	}
+
+	// Sessions of deleted users end
+	if _, err := db.Exec("DELETE FROM user WHERE name = 'alice'"); err != nil {
+		t.Fatal(err)
+	}
+	if w := serveWithCookies(r, "GET", "/api/status", cookies, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d after the user was deleted, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
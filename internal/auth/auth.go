@@ -25,33 +25,70 @@ func NewAuthenticator(dbPath string) *Authenticator {
 }
 
 // Authenticate validates Basic Auth credentials against the database
-// Returns true if authentication is successful
-func (a *Authenticator) Authenticate(username, password string) (bool, error) {
+// Returns the user with its Calibre-Web roles and true if authentication is successful
+func (a *Authenticator) Authenticate(username, password string) (User, bool, error) {
 	// If no database path is configured, always authenticate
 	if a.dbPath == "" {
-		return true, nil
+		return User{Name: username, Role: RoleAll}, true, nil
 	}
 
-	// Open database in read-only mode
-	dbURI := fmt.Sprintf("file:%s?mode=ro&immutable=1", a.dbPath)
-	db, err := sql.Open("sqlite3", dbURI)
+	db, err := a.openDB()
 	if err != nil {
-		return false, fmt.Errorf("failed to open database: %w", err)
+		return User{}, false, err
 	}
 	defer db.Close()
 
-	// Query for user's password hash
+	// Query for user's password hash and role bitmask
 	var passwordHash string
-	err = db.QueryRow("SELECT password FROM user WHERE name = ?", username).Scan(&passwordHash)
+	var role sql.NullInt64
+	err = db.QueryRow("SELECT password, role FROM user WHERE name = ?", username).Scan(&passwordHash, &role)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return false, nil
+			return User{}, false, nil
 		}
-		return false, fmt.Errorf("database query failed: %w", err)
+		return User{}, false, fmt.Errorf("database query failed: %w", err)
 	}
 
 	// Verify password hash
-	return a.checkPasswordHash(passwordHash, password)
+	ok, err := a.checkPasswordHash(passwordHash, password)
+	if err != nil || !ok {
+		return User{}, false, err
+	}
+	return User{Name: username, Role: Role(role.Int64)}, true, nil
+}
+
+// LookupUser loads the current roles of a user without checking a password,
+// for requests authenticated earlier. It reports false for unknown users.
+func (a *Authenticator) LookupUser(username string) (User, bool, error) {
+	if a.dbPath == "" {
+		return User{Name: username, Role: RoleAll}, true, nil
+	}
+
+	db, err := a.openDB()
+	if err != nil {
+		return User{}, false, err
+	}
+	defer db.Close()
+
+	var role sql.NullInt64
+	err = db.QueryRow("SELECT role FROM user WHERE name = ?", username).Scan(&role)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return User{}, false, nil
+		}
+		return User{}, false, fmt.Errorf("database query failed: %w", err)
+	}
+	return User{Name: username, Role: Role(role.Int64)}, true, nil
+}
+
+// openDB opens the Calibre-Web database in read-only mode
+func (a *Authenticator) openDB() (*sql.DB, error) {
+	dbURI := fmt.Sprintf("file:%s?mode=ro&immutable=1", a.dbPath)
+	db, err := sql.Open("sqlite3", dbURI)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return db, nil
 }
 
 // checkPasswordHash verifies a password against a Werkzeug-style hash
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// hashPassword creates a pbkdf2:sha256 hash as checked by checkPasswordHash
+func hashPassword(password string) string {
+	salt := []byte("testsalt")
+	key := pbkdf2.Key([]byte(password), salt, 1000, 32, sha256.New)
+	return fmt.Sprintf("pbkdf2:sha256:1000$%s$%s",
+		base64.StdEncoding.EncodeToString(salt), base64.StdEncoding.EncodeToString(key))
+}
+
+// newTestAppDB creates a Calibre-Web app.db with the given users and roles
+func newTestAppDB(t *testing.T, users map[string]Role) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "app.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE TABLE user (id INTEGER PRIMARY KEY, name TEXT, password TEXT, role SMALLINT)"); err != nil {
+		t.Fatal(err)
+	}
+	for name, role := range users {
+		if _, err := db.Exec("INSERT INTO user (name, password, role) VALUES (?, ?, ?)", name, hashPassword("secret"), int(role)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func TestAuthenticateLoadsRole(t *testing.T) {
+	a := NewAuthenticator(newTestAppDB(t, map[string]Role{
+		"admin":  RoleAdmin | RoleDownload,
+		"reader": RoleViewer,
+	}))
+
+	tests := []struct {
+		username string
+		password string
+		wantOK   bool
+		wantRole Role
+	}{
+		{"admin", "secret", true, RoleAdmin | RoleDownload},
+		{"reader", "secret", true, RoleViewer},
+		{"reader", "wrong", false, 0},
+		{"nobody", "secret", false, 0},
+	}
+
+	for _, tt := range tests {
+		user, ok, err := a.Authenticate(tt.username, tt.password)
+		if err != nil {
+			t.Fatalf("Authenticate(%s) failed: %v", tt.username, err)
+		}
+		if ok != tt.wantOK || user.Role != tt.wantRole {
+			t.Errorf("Authenticate(%s, %s) = %+v, %v, want role %v, %v", tt.username, tt.password, user, ok, tt.wantRole, tt.wantOK)
+		}
+		if ok && user.Name != tt.username {
+			t.Errorf("Expected user %s, got %s", tt.username, user.Name)
+		}
+	}
+}
+
+func TestAuthenticateWithoutDatabaseGrantsAllRoles(t *testing.T) {
+	user, ok, err := NewAuthenticator("").Authenticate("anyone", "")
+	if err != nil || !ok || !user.IsAdmin() || !user.Role.Has(RoleDownload) {
+		t.Errorf("Authenticate() = %+v, %v, %v, want a user with all roles", user, ok, err)
+	}
+}
+
+func TestLookupUser(t *testing.T) {
+	a := NewAuthenticator(newTestAppDB(t, map[string]Role{"reader": RoleViewer}))
+
+	user, ok, err := a.LookupUser("reader")
+	if err != nil || !ok || user.Name != "reader" || user.Role != RoleViewer {
+		t.Errorf("LookupUser(reader) = %+v, %v, %v, want the viewer role", user, ok, err)
+	}
+	if _, ok, err := a.LookupUser("nobody"); err != nil || ok {
+		t.Errorf("LookupUser(nobody) = %v, %v, want an unknown user", ok, err)
+	}
+}
+
+func TestRoleString(t *testing.T) {
+	if s := (RoleAdmin | RoleDownload).String(); s != "admin,download" {
+		t.Errorf("String() = %q, want %q", s, "admin,download")
+	}
+	if s := Role(0).String(); s != "none" {
+		t.Errorf("String() = %q, want %q", s, "none")
+	}
+}
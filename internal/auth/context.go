@@ -0,0 +1,29 @@
+package auth
+
+import "context"
+
+// User is an authenticated Calibre-Web user
+type User struct {
+	Name string
+	Role Role
+}
+
+// IsAdmin reports whether the user has the Calibre-Web admin role
+func (u User) IsAdmin() bool {
+	return u.Role.Has(RoleAdmin)
+}
+
+// contextKey is the type of the request context key holding the user
+type contextKey struct{}
+
+// WithUser returns a copy of ctx carrying the authenticated user
+func WithUser(ctx context.Context, user User) context.Context {
+	return context.WithValue(ctx, contextKey{}, user)
+}
+
+// UserFromContext returns the authenticated user of a request. It reports
+// false when authentication is disabled or the request was not authenticated.
+func UserFromContext(ctx context.Context) (User, bool) {
+	user, ok := ctx.Value(contextKey{}).(User)
+	return user, ok
+}
@@ -0,0 +1,58 @@
+package auth
+
+import "strings"
+
+// Role is the Calibre-Web role bitmask of a user
+type Role int
+
+// Calibre-Web roles, as stored in the role column of its user table
+const (
+	RoleAdmin       Role = 1 << 0
+	RoleDownload    Role = 1 << 1
+	RoleUpload      Role = 1 << 2
+	RoleEdit        Role = 1 << 3
+	RolePasswd      Role = 1 << 4
+	RoleAnonymous   Role = 1 << 5
+	RoleEditShelfs  Role = 1 << 6
+	RoleDeleteBooks Role = 1 << 7
+	RoleViewer      Role = 1 << 8
+
+	// RoleAll grants every role; it is given to users when authentication is disabled
+	RoleAll = RoleAdmin | RoleDownload | RoleUpload | RoleEdit | RolePasswd |
+		RoleEditShelfs | RoleDeleteBooks | RoleViewer
+)
+
+// Has reports whether all roles in role are set
+func (r Role) Has(role Role) bool {
+	return r&role == role
+}
+
+// roleNames names the roles in the order String lists them
+var roleNames = []struct {
+	role Role
+	name string
+}{
+	{RoleAdmin, "admin"},
+	{RoleDownload, "download"},
+	{RoleUpload, "upload"},
+	{RoleEdit, "edit"},
+	{RolePasswd, "passwd"},
+	{RoleAnonymous, "anonymous"},
+	{RoleEditShelfs, "edit_shelfs"},
+	{RoleDeleteBooks, "delete_books"},
+	{RoleViewer, "viewer"},
+}
+
+// String returns the names of the roles that are set, e.g. "admin,download"
+func (r Role) String() string {
+	var names []string
+	for _, n := range roleNames {
+		if r.Has(n.role) {
+			names = append(names, n.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
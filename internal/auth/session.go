@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cookies and header used for sessions
+const (
+	SessionCookie = "cwabd_session"
+	CSRFCookie    = "cwabd_csrf"
+	CSRFHeader    = "X-CSRF-Token"
+)
+
+// DefaultSessionTTL is how long sessions last unless configured otherwise
+const DefaultSessionTTL = 7 * 24 * time.Hour
+
+var (
+	// ErrInvalidSession is returned for session tokens that are malformed, not
+	// signed by us or revoked
+	ErrInvalidSession = errors.New("invalid session")
+	// ErrSessionExpired is returned for session tokens past their expiry
+	ErrSessionExpired = errors.New("session expired")
+)
+
+// Session is a signed-in user. Its token is kept in the session cookie, so the
+// role is the one the user had when signing in; callers load the current role
+// with Authenticator.LookupUser before trusting it.
+type Session struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Role    Role   `json:"role"`
+	Expires int64  `json:"exp"`
+}
+
+// User returns the user the session belongs to
+func (s *Session) User() User {
+	return User{Name: s.Name, Role: s.Role}
+}
+
+// ExpiresAt returns when the session expires
+func (s *Session) ExpiresAt() time.Time {
+	return time.Unix(s.Expires, 0)
+}
+
+// SessionManager issues and verifies HMAC signed session tokens, so requests
+// carrying one need no password hash
+type SessionManager struct {
+	key []byte
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	revoked map[string]time.Time // signed out session IDs until they expire
+}
+
+// NewSessionManager creates a session manager signing with secret. Without a
+// secret a random key is used and sessions end when the server restarts.
+func NewSessionManager(secret string, ttl time.Duration) *SessionManager {
+	var key []byte
+	if secret != "" {
+		sum := sha256.Sum256([]byte(secret))
+		key = sum[:]
+	} else {
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+	return &SessionManager{
+		key:     key,
+		ttl:     ttl,
+		now:     time.Now,
+		revoked: make(map[string]time.Time),
+	}
+}
+
+// TTL returns how long sessions last
+func (m *SessionManager) TTL() time.Duration {
+	return m.ttl
+}
+
+// Issue starts a session for user and returns it with its token
+func (m *SessionManager) Issue(user User) (*Session, string) {
+	session := &Session{
+		ID:      rand.Text(),
+		Name:    user.Name,
+		Role:    user.Role,
+		Expires: m.now().Add(m.ttl).Unix(),
+	}
+
+	// json.Marshal cannot fail for a Session
+	payload, _ := json.Marshal(session)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return session, encoded + "." + m.sign("session|"+encoded)
+}
+
+// Verify returns the session of a token
+func (m *SessionManager) Verify(token string) (*Session, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(m.sign("session|"+encoded))) {
+		return nil, ErrInvalidSession
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidSession
+	}
+	var session Session
+	if err := json.Unmarshal(payload, &session); err != nil || session.ID == "" {
+		return nil, ErrInvalidSession
+	}
+	if !m.now().Before(session.ExpiresAt()) {
+		return nil, ErrSessionExpired
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, revoked := m.revoked[session.ID]; revoked {
+		return nil, ErrInvalidSession
+	}
+	return &session, nil
+}
+
+// Revoke ends a session before it expires
+func (m *SessionManager) Revoke(session *Session) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Forget sessions that expired anyway
+	now := m.now()
+	for id, expires := range m.revoked {
+		if !now.Before(expires) {
+			delete(m.revoked, id)
+		}
+	}
+	m.revoked[session.ID] = session.ExpiresAt()
+}
+
+// CSRFToken returns the token requests of a session must send in CSRFHeader
+// to change anything
+func (m *SessionManager) CSRFToken(session *Session) string {
+	return m.sign("csrf|" + session.ID)
+}
+
+// CheckCSRF reports whether token is the CSRF token of the session
+func (m *SessionManager) CheckCSRF(session *Session, token string) bool {
+	return token != "" && hmac.Equal([]byte(token), []byte(m.CSRFToken(session)))
+}
+
+// sign returns the base64 HMAC-SHA256 of data
+func (m *SessionManager) sign(data string) string {
+	mac := hmac.New(sha256.New, m.key)
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// sessionKey is the type of the request context key holding the session
+type sessionKey struct{}
+
+// WithSession returns a copy of ctx carrying the session of the request
+func WithSession(ctx context.Context, session *Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, session)
+}
+
+// SessionFromContext returns the session a request was authenticated with. It
+// reports false for requests authenticated with Basic Auth.
+func SessionFromContext(ctx context.Context) (*Session, bool) {
+	session, ok := ctx.Value(sessionKey{}).(*Session)
+	return session, ok
+}
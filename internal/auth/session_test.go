@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionManagerIssueAndVerify(t *testing.T) {
+	m := NewSessionManager("secret", time.Hour)
+	issued, token := m.Issue(User{Name: "alice", Role: RoleDownload})
+
+	session, err := m.Verify(token)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if session.ID != issued.ID || session.User() != (User{Name: "alice", Role: RoleDownload}) {
+		t.Errorf("Verify() = %+v, want %+v", session, issued)
+	}
+
+	// Tokens stay valid for a manager with the same secret, e.g. after a restart
+	if _, err := NewSessionManager("secret", time.Hour).Verify(token); err != nil {
+		t.Errorf("Verify with the same secret failed: %v", err)
+	}
+	if _, err := NewSessionManager("other", time.Hour).Verify(token); !errors.Is(err, ErrInvalidSession) {
+		t.Errorf("Verify with another secret error = %v, want ErrInvalidSession", err)
+	}
+}
+
+func TestSessionManagerRejectsTamperedTokens(t *testing.T) {
+	m := NewSessionManager("", time.Hour)
+	_, token := m.Issue(User{Name: "alice", Role: RoleDownload})
+	_, adminToken := m.Issue(User{Name: "admin", Role: RoleAdmin})
+
+	payload, _, _ := strings.Cut(token, ".")
+	_, adminSignature, _ := strings.Cut(adminToken, ".")
+
+	for _, tampered := range []string{"", "garbage", payload, payload + ".", payload + "." + adminSignature, token + "x"} {
+		if _, err := m.Verify(tampered); !errors.Is(err, ErrInvalidSession) {
+			t.Errorf("Verify(%q) error = %v, want ErrInvalidSession", tampered, err)
+		}
+	}
+}
+
+func TestSessionManagerExpiry(t *testing.T) {
+	now := time.Now()
+	m := NewSessionManager("secret", time.Hour)
+	m.now = func() time.Time { return now }
+	_, token := m.Issue(User{Name: "alice"})
+
+	now = now.Add(time.Hour)
+	if _, err := m.Verify(token); !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("Verify() error = %v, want ErrSessionExpired", err)
+	}
+}
+
+func TestSessionManagerRevoke(t *testing.T) {
+	m := NewSessionManager("secret", time.Hour)
+	session, token := m.Issue(User{Name: "alice"})
+	_, other := m.Issue(User{Name: "alice"})
+
+	m.Revoke(session)
+	if _, err := m.Verify(token); !errors.Is(err, ErrInvalidSession) {
+		t.Errorf("Verify() of a revoked session error = %v, want ErrInvalidSession", err)
+	}
+	if _, err := m.Verify(other); err != nil {
+		t.Errorf("Verify() of another session failed: %v", err)
+	}
+}
+
+func TestSessionManagerCSRF(t *testing.T) {
+	m := NewSessionManager("secret", time.Hour)
+	session, _ := m.Issue(User{Name: "alice"})
+	other, _ := m.Issue(User{Name: "alice"})
+
+	if !m.CheckCSRF(session, m.CSRFToken(session)) {
+		t.Error("Expected the session's CSRF token to be accepted")
+	}
+	if m.CheckCSRF(session, "") || m.CheckCSRF(session, m.CSRFToken(other)) {
+		t.Error("Expected a missing or foreign CSRF token to be rejected")
+	}
+}
@@ -1,42 +1,82 @@
 package backend
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/veverkap/calibre-web-automated-book-downloader/internal/models"
 	"go.uber.org/zap"
 )
 
+// ErrAlreadyQueued is returned when a book is already queued, downloading or available
+var ErrAlreadyQueued = errors.New("book is already queued")
+
+// ErrCannotRetry is returned when a book is unknown or neither failed nor cancelled
+var ErrCannotRetry = errors.New("book not found or cannot be retried")
+
 // Backend provides high-level business logic for the application
 type Backend struct {
 	queue  *models.BookQueue
 	logger *zap.Logger
+
+	mu       sync.Mutex // serialises quota checks with queueing
+	quotas   Quotas
+	requests map[string][]time.Time // recent download requests per user
+	now      func() time.Time
 }
 
-// NewBackend creates a new Backend instance
+// NewBackend creates a new Backend instance. The daily quota counts start
+// from the books restored into the queue.
 func NewBackend(queue *models.BookQueue, logger *zap.Logger) *Backend {
 	return &Backend{
-		queue:  queue,
-		logger: logger,
+		queue:    queue,
+		logger:   logger,
+		requests: queue.RequestTimes(),
+		now:      time.Now,
 	}
 }
 
-// QueueBook adds a book to the download queue
+// QueueBook adds a book to the download queue. Books requested by a user
+// count against that user's quotas; a *QuotaError is returned when one is
+// used up.
 func (b *Backend) QueueBook(bookID string, bookInfo *models.BookInfo, priority int) error {
 	if bookInfo == nil {
 		return fmt.Errorf("book info is required")
 	}
 
-	b.queue.Add(bookID, bookInfo, priority)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	user := bookInfo.RequestedBy
+	if user != "" {
+		if err := b.checkQuotas(user); err != nil {
+			return err
+		}
+	}
+
+	if !b.queue.Add(bookID, bookInfo, priority) {
+		return ErrAlreadyQueued
+	}
+	if user != "" {
+		b.recordRequest(user)
+	}
 	b.logger.Info("Book queued",
 		zap.String("book_id", bookID),
 		zap.String("title", bookInfo.Title),
-		zap.Int("priority", priority))
+		zap.Int("priority", priority),
+		zap.String("requested_by", user))
 
 	return nil
 }
 
+// IsQueued reports whether a book is already queued, downloading or available
+func (b *Backend) IsQueued(bookID string) bool {
+	return b.queue.IsPending(bookID)
+}
+
 // GetQueueStatus returns the current queue status
 func (b *Backend) GetQueueStatus() map[models.QueueStatus]map[string]*models.BookInfo {
 	status := b.queue.GetStatus()
@@ -55,6 +95,25 @@ func (b *Backend) GetQueueStatus() map[models.QueueStatus]map[string]*models.Boo
 	return status
 }
 
+// GetUserQueueStatus returns the queue status limited to the books
+// requested by user
+func (b *Backend) GetUserQueueStatus(user string) map[models.QueueStatus]map[string]*models.BookInfo {
+	status := b.GetQueueStatus()
+	for _, books := range status {
+		for bookID, book := range books {
+			if book.RequestedBy != user {
+				delete(books, bookID)
+			}
+		}
+	}
+	return status
+}
+
+// GetBook returns a tracked book in any status
+func (b *Backend) GetBook(bookID string) (models.BookInfo, bool) {
+	return b.queue.GetBook(bookID)
+}
+
 // GetBookData retrieves the downloaded book data
 func (b *Backend) GetBookData(bookID string) ([]byte, *models.BookInfo, error) {
 	status := b.queue.GetStatus()
@@ -97,6 +156,35 @@ func (b *Backend) CancelDownload(bookID string) bool {
 	return success
 }
 
+// RetryDownload queues a failed or cancelled download again. Like a new
+// request it counts against the quotas of the user who requested the book.
+func (b *Backend) RetryDownload(bookID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	book, exists := b.queue.GetBook(bookID)
+	if !exists {
+		return ErrCannotRetry
+	}
+	user := book.RequestedBy
+	if user != "" {
+		if err := b.checkQuotas(user); err != nil {
+			return err
+		}
+	}
+
+	if !b.queue.Retry(bookID) {
+		return ErrCannotRetry
+	}
+	if user != "" {
+		b.recordRequest(user)
+	}
+	b.logger.Info("Download queued for retry",
+		zap.String("book_id", bookID),
+		zap.String("requested_by", user))
+	return nil
+}
+
 // SetBookPriority changes the priority of a queued book
 func (b *Backend) SetBookPriority(bookID string, priority int) bool {
 	success := b.queue.SetPriority(bookID, priority)
@@ -129,3 +217,8 @@ func (b *Backend) ClearCompleted() int {
 	b.logger.Info("Cleared completed downloads", zap.Int("count", count))
 	return count
 }
+
+// SubscribeEvents registers a listener for queue events
+func (b *Backend) SubscribeEvents() (<-chan models.Event, func()) {
+	return b.queue.Subscribe(models.DefaultEventBuffer)
+}
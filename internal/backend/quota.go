@@ -0,0 +1,141 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/config"
+)
+
+// ErrQuotaExceeded is returned when a user has used up a download quota
+var ErrQuotaExceeded = errors.New("download quota exceeded")
+
+// QuotaWindow is the period over which the daily quota counts requests
+const QuotaWindow = 24 * time.Hour
+
+// Kinds of quota
+const (
+	QuotaActive = "active"
+	QuotaDaily  = "daily"
+)
+
+// Quotas limits the downloads a single user may request. Zero means unlimited.
+type Quotas struct {
+	// MaxActive caps the books of a user that are queued or downloading
+	MaxActive int `json:"max_active"`
+	// Daily caps the books a user may request within QuotaWindow
+	Daily int `json:"daily"`
+}
+
+// QuotasFromConfig returns the per-user quotas of the configuration
+func QuotasFromConfig(cfg *config.Config) Quotas {
+	return Quotas{
+		MaxActive: max(cfg.UserMaxActiveDownloads, 0),
+		Daily:     max(cfg.UserDailyDownloadLimit, 0),
+	}
+}
+
+// QuotaError describes which quota a download request exceeded
+type QuotaError struct {
+	User  string
+	Quota string
+	Used  int
+	Limit int
+	// RetryAt is when the daily quota allows another request
+	RetryAt time.Time
+}
+
+func (e *QuotaError) Error() string {
+	if e.Quota == QuotaDaily {
+		return fmt.Sprintf("%s has requested %d of %d downloads allowed per day", e.User, e.Used, e.Limit)
+	}
+	return fmt.Sprintf("%s has %d of %d allowed downloads queued or in progress", e.User, e.Used, e.Limit)
+}
+
+// Unwrap lets errors.Is match ErrQuotaExceeded
+func (e *QuotaError) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+// QuotaUsage reports the quotas of a user and how much of them is used
+type QuotaUsage struct {
+	User      string     `json:"user"`
+	Active    int        `json:"active"`
+	MaxActive int        `json:"max_active"`
+	Daily     int        `json:"daily"`
+	DailyMax  int        `json:"daily_max"`
+	ResetAt   *time.Time `json:"reset_at,omitempty"`
+}
+
+// SetQuotas changes the per-user quotas
+func (b *Backend) SetQuotas(quotas Quotas) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.quotas = quotas
+}
+
+// QuotaUsage returns the quotas of user and how much of them is used
+func (b *Backend) QuotaUsage(user string) QuotaUsage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	recent := b.recentRequests(user)
+	usage := QuotaUsage{
+		User:      user,
+		Active:    b.queue.ActiveRequests(user),
+		MaxActive: b.quotas.MaxActive,
+		Daily:     len(recent),
+		DailyMax:  b.quotas.Daily,
+	}
+	if len(recent) > 0 {
+		resetAt := recent[0].Add(QuotaWindow)
+		usage.ResetAt = &resetAt
+	}
+	return usage
+}
+
+// checkQuotas returns a *QuotaError when user may not request another
+// download. Callers must hold b.mu.
+func (b *Backend) checkQuotas(user string) error {
+	if b.quotas.MaxActive > 0 {
+		if active := b.queue.ActiveRequests(user); active >= b.quotas.MaxActive {
+			return &QuotaError{User: user, Quota: QuotaActive, Used: active, Limit: b.quotas.MaxActive}
+		}
+	}
+	if b.quotas.Daily > 0 {
+		if recent := b.recentRequests(user); len(recent) >= b.quotas.Daily {
+			return &QuotaError{
+				User:    user,
+				Quota:   QuotaDaily,
+				Used:    len(recent),
+				Limit:   b.quotas.Daily,
+				RetryAt: recent[len(recent)-b.quotas.Daily].Add(QuotaWindow),
+			}
+		}
+	}
+	return nil
+}
+
+// recentRequests drops the requests of user older than QuotaWindow and
+// returns the rest, oldest first. Callers must hold b.mu.
+func (b *Backend) recentRequests(user string) []time.Time {
+	cutoff := b.now().Add(-QuotaWindow)
+	requests := b.requests[user]
+	i := 0
+	for i < len(requests) && !requests[i].After(cutoff) {
+		i++
+	}
+	if i == len(requests) {
+		delete(b.requests, user)
+		return nil
+	}
+	b.requests[user] = requests[i:]
+	return requests[i:]
+}
+
+// recordRequest counts a download request of user against the daily quota.
+// Callers must hold b.mu.
+func (b *Backend) recordRequest(user string) {
+	b.requests[user] = append(b.requests[user], b.now())
+}
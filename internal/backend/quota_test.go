@@ -0,0 +1,152 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/models"
+	"go.uber.org/zap"
+)
+
+// queueFor queues a book requested by user
+func queueFor(b *Backend, bookID, user string) error {
+	return b.QueueBook(bookID, &models.BookInfo{ID: bookID, Title: bookID, RequestedBy: user}, 0)
+}
+
+func TestQueueBookMaxActiveQuota(t *testing.T) {
+	queue := models.NewBookQueue(time.Hour)
+	b := NewBackend(queue, zap.NewNop())
+	b.SetQuotas(Quotas{MaxActive: 2})
+
+	for _, bookID := range []string{"a", "b"} {
+		if err := queueFor(b, bookID, "alice"); err != nil {
+			t.Fatalf("QueueBook(%s) failed: %v", bookID, err)
+		}
+	}
+
+	err := queueFor(b, "c", "alice")
+	var quotaErr *QuotaError
+	if !errors.Is(err, ErrQuotaExceeded) || !errors.As(err, &quotaErr) || quotaErr.Quota != QuotaActive {
+		t.Fatalf("QueueBook() error = %v, want the active quota to be exceeded", err)
+	}
+	if b.IsQueued("c") {
+		t.Error("Book over the quota was queued")
+	}
+
+	if err := queueFor(b, "c", "bob"); err != nil {
+		t.Errorf("Quota of another user was applied: %v", err)
+	}
+	if err := queueFor(b, "d", ""); err != nil {
+		t.Errorf("Quota was applied to an anonymous request: %v", err)
+	}
+
+	// Finished downloads no longer count
+	queue.UpdateStatus("a", models.StatusAvailable)
+	if err := queueFor(b, "e", "alice"); err != nil {
+		t.Errorf("QueueBook() after a finished download failed: %v", err)
+	}
+}
+
+func TestQueueBookDailyQuota(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	b := NewBackend(models.NewBookQueue(time.Hour), zap.NewNop())
+	b.now = func() time.Time { return now }
+	b.SetQuotas(Quotas{Daily: 2})
+
+	for i := 0; i < 2; i++ {
+		if err := queueFor(b, fmt.Sprintf("book-%d", i), "alice"); err != nil {
+			t.Fatalf("QueueBook failed: %v", err)
+		}
+		now = now.Add(time.Hour)
+	}
+
+	err := queueFor(b, "book-2", "alice")
+	var quotaErr *QuotaError
+	if !errors.As(err, &quotaErr) || quotaErr.Quota != QuotaDaily {
+		t.Fatalf("QueueBook() error = %v, want the daily quota to be exceeded", err)
+	}
+	if want := time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC); !quotaErr.RetryAt.Equal(want) {
+		t.Errorf("RetryAt = %v, want %v", quotaErr.RetryAt, want)
+	}
+
+	// A cancelled request still counts against the daily quota
+	b.CancelDownload("book-0")
+	if err := queueFor(b, "book-2", "alice"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("QueueBook() error = %v, want the daily quota to be exceeded", err)
+	}
+
+	now = time.Date(2024, 5, 2, 12, 0, 1, 0, time.UTC)
+	if err := queueFor(b, "book-2", "alice"); err != nil {
+		t.Errorf("QueueBook() after the oldest request expired failed: %v", err)
+	}
+
+	usage := b.QuotaUsage("alice")
+	if usage.Daily != 2 || usage.DailyMax != 2 || usage.Active != 2 {
+		t.Errorf("Unexpected usage %+v", usage)
+	}
+}
+
+func TestQueueBookAlreadyQueuedDoesNotCount(t *testing.T) {
+	b := NewBackend(models.NewBookQueue(time.Hour), zap.NewNop())
+	b.SetQuotas(Quotas{Daily: 1})
+
+	if err := queueFor(b, "a", "alice"); err != nil {
+		t.Fatalf("QueueBook failed: %v", err)
+	}
+	if err := queueFor(b, "a", "bob"); !errors.Is(err, ErrAlreadyQueued) {
+		t.Fatalf("QueueBook() error = %v, want ErrAlreadyQueued", err)
+	}
+	if usage := b.QuotaUsage("bob"); usage.Daily != 0 {
+		t.Errorf("Refused request was counted: %+v", usage)
+	}
+}
+
+func TestRetryDownloadCountsAgainstQuotas(t *testing.T) {
+	queue := models.NewBookQueue(time.Hour)
+	b := NewBackend(queue, zap.NewNop())
+	b.SetQuotas(Quotas{MaxActive: 1, Daily: 3})
+
+	if err := queueFor(b, "a", "alice"); err != nil {
+		t.Fatalf("QueueBook failed: %v", err)
+	}
+	b.CancelDownload("a")
+	if err := queueFor(b, "b", "alice"); err != nil {
+		t.Fatalf("QueueBook failed: %v", err)
+	}
+
+	// Retrying the cancelled book would exceed the active quota
+	if err := b.RetryDownload("a"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("RetryDownload() error = %v, want the active quota to be exceeded", err)
+	}
+	if b.IsQueued("a") {
+		t.Error("Book over the quota was queued again")
+	}
+
+	b.CancelDownload("b")
+	if err := b.RetryDownload("a"); err != nil {
+		t.Fatalf("RetryDownload failed: %v", err)
+	}
+	if usage := b.QuotaUsage("alice"); usage.Daily != 3 {
+		t.Errorf("Expected the retry to count against the daily quota, got %+v", usage)
+	}
+
+	if err := b.RetryDownload("missing"); !errors.Is(err, ErrCannotRetry) {
+		t.Errorf("RetryDownload() error = %v, want ErrCannotRetry", err)
+	}
+}
+
+func TestNewBackendCountsRestoredRequests(t *testing.T) {
+	queue := models.NewBookQueue(time.Hour)
+	queue.Add("a", &models.BookInfo{ID: "a", RequestedBy: "alice"}, 0)
+	queue.Add("b", &models.BookInfo{ID: "b", RequestedBy: "alice"}, 0)
+	queue.UpdateStatus("a", models.StatusAvailable)
+
+	b := NewBackend(queue, zap.NewNop())
+	b.SetQuotas(Quotas{Daily: 2})
+
+	if err := queueFor(b, "c", "alice"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("QueueBook() error = %v, want the daily quota to be exceeded", err)
+	}
+}
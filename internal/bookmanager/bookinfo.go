@@ -2,7 +2,6 @@ package bookmanager
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"regexp"
 	"strings"
@@ -14,9 +13,9 @@ import (
 )
 
 // GetBookInfo retrieves detailed information for a specific book
-func GetBookInfo(ctx context.Context, cfg *config.Config, bookID string) (*models.BookInfo, error) {
-	url := fmt.Sprintf("%s/md5/%s", cfg.AABaseURL, bookID)
-	html, err := downloader.HTMLGetPage(ctx, cfg, url, false)
+func GetBookInfo(ctx context.Context, cfg *config.Config, network *downloader.Network, bookID string) (*models.BookInfo, error) {
+	url := fmt.Sprintf("%s/md5/%s", network.BaseURL(), bookID)
+	html, err := network.HTMLGetPage(ctx, url, false)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch book info for ID %s: %w", bookID, err)
 	}
@@ -26,11 +25,11 @@ func GetBookInfo(ctx context.Context, cfg *config.Config, bookID string) (*model
 		return nil, fmt.Errorf("failed to parse HTML: %w", err)
 	}
 
-	return parseBookInfoPage(ctx, cfg, doc, bookID)
+	return parseBookInfoPage(ctx, cfg, network, doc, bookID)
 }
 
 // parseBookInfoPage parses the book info page HTML into a BookInfo object
-func parseBookInfoPage(ctx context.Context, cfg *config.Config, doc *goquery.Document, bookID string) (*models.BookInfo, error) {
+func parseBookInfoPage(ctx context.Context, cfg *config.Config, network *downloader.Network, doc *goquery.Document, bookID string) (*models.BookInfo, error) {
 	// Get preview image
 	var preview *string
 	if img := doc.Find("body > main > div:nth-of-type(1) div:nth-of-type(1) > img"); img.Length() > 0 {
@@ -88,7 +87,7 @@ func parseBookInfoPage(ctx context.Context, cfg *config.Config, doc *goquery.Doc
 	// Get WELIB URLs if configured
 	externalURLsWELIB := make(map[string]bool)
 	if cfg.UseCFBypass && cfg.AllowUseWELIB {
-		welibURLs, err := getDownloadURLsFromWELIB(ctx, cfg, bookID)
+		welibURLs, err := getDownloadURLsFromWELIB(ctx, cfg, network, bookID)
 		if err == nil {
 			for _, u := range welibURLs {
 				externalURLsWELIB[u] = true
@@ -115,7 +114,7 @@ func parseBookInfoPage(ctx context.Context, cfg *config.Config, doc *goquery.Doc
 
 	// Convert to absolute URLs
 	for i := range urls {
-		absURL, err := downloader.GetAbsoluteURL(cfg.AABaseURL, urls[i])
+		absURL, err := downloader.GetAbsoluteURL(network.BaseURL(), urls[i])
 		if err == nil && absURL != "" {
 			urls[i] = absURL
 		}
@@ -239,13 +238,13 @@ func parseBookInfoPage(ctx context.Context, cfg *config.Config, doc *goquery.Doc
 }
 
 // getDownloadURLsFromWELIB retrieves download URLs from welib.org
-func getDownloadURLsFromWELIB(ctx context.Context, cfg *config.Config, bookID string) ([]string, error) {
+func getDownloadURLsFromWELIB(ctx context.Context, cfg *config.Config, network *downloader.Network, bookID string) ([]string, error) {
 	if !cfg.AllowUseWELIB {
 		return nil, nil
 	}
 
 	url := fmt.Sprintf("https://welib.org/md5/%s", bookID)
-	html, err := downloader.HTMLGetPage(ctx, cfg, url, true)
+	html, err := network.HTMLGetPage(ctx, url, true)
 	if err != nil {
 		return nil, err
 	}
@@ -335,112 +334,3 @@ func appendMapKeys(slice []string, m map[string]bool) []string {
 	}
 	return slice
 }
-
-// DownloadBook downloads a book from available sources
-func DownloadBook(ctx context.Context, cfg *config.Config, bookInfo *models.BookInfo, progressCallback func(float64)) ([]byte, error) {
-	// If download URLs are not set, fetch book info first
-	if len(bookInfo.DownloadURLs) == 0 {
-		fullInfo, err := GetBookInfo(ctx, cfg, bookInfo.ID)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get book info: %w", err)
-		}
-		bookInfo.DownloadURLs = fullInfo.DownloadURLs
-	}
-
-	downloadLinks := make([]string, len(bookInfo.DownloadURLs))
-	copy(downloadLinks, bookInfo.DownloadURLs)
-
-	// If AA_DONATOR_KEY is set, use the fast download URL
-	if cfg.AADonatorKey != "" {
-		fastURL := fmt.Sprintf("%s/dyn/api/fast_download.json?md5=%s&key=%s",
-			cfg.AABaseURL, bookInfo.ID, cfg.AADonatorKey)
-		downloadLinks = append([]string{fastURL}, downloadLinks...)
-	}
-
-	// Try each download link
-	for _, link := range downloadLinks {
-		downloadURL, err := getDownloadURL(ctx, cfg, link, bookInfo.Title)
-		if err != nil || downloadURL == "" {
-			continue
-		}
-
-		size := ""
-		if bookInfo.Size != nil {
-			size = *bookInfo.Size
-		}
-
-		buffer, err := downloader.DownloadURLToBuffer(ctx, cfg, downloadURL, size, progressCallback)
-		if err != nil {
-			continue
-		}
-
-		return buffer.Bytes(), nil
-	}
-
-	return nil, fmt.Errorf("failed to download book from any source")
-}
-
-// getDownloadURL extracts actual download URL from various source pages
-func getDownloadURL(ctx context.Context, cfg *config.Config, link, title string) (string, error) {
-	// Fast download API
-	if strings.HasPrefix(link, cfg.AABaseURL+"/dyn/api/fast_download.json") {
-		html, err := downloader.HTMLGetPage(ctx, cfg, link, false)
-		if err != nil {
-			return "", err
-		}
-
-		var result map[string]interface{}
-		if err := json.Unmarshal([]byte(html), &result); err != nil {
-			return "", fmt.Errorf("failed to parse JSON: %w", err)
-		}
-
-		if url, ok := result["download_url"].(string); ok {
-			return url, nil
-		}
-		return "", fmt.Errorf("no download_url in response")
-	}
-
-	// Regular download pages
-	html, err := downloader.HTMLGetPage(ctx, cfg, link, false)
-	if err != nil {
-		return "", err
-	}
-
-	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
-	if err != nil {
-		return "", err
-	}
-
-	var downloadURL string
-
-	// Z-Library
-	if strings.HasPrefix(link, "https://z-lib.") {
-		if downloadLink := doc.Find("a.addDownloadedBook[href]"); downloadLink.Length() > 0 {
-			downloadURL, _ = downloadLink.Attr("href")
-		}
-	} else if strings.Contains(link, "/slow_download/") {
-		// Slow download with countdown
-		if downloadLink := doc.Find("a:contains('📚 Download now')"); downloadLink.Length() > 0 {
-			downloadURL, _ = downloadLink.Attr("href")
-		} else {
-			// Check for countdown
-			if countdown := doc.Find("span.js-partner-countdown"); countdown.Length() > 0 {
-				// Note: Countdown wait logic not implemented in Phase 3
-				// This will be implemented in Phase 4 when browser automation is integrated
-				// The Python version waits for the countdown and retries the same URL
-				return "", fmt.Errorf("download requires countdown wait - will be implemented in Phase 4 with browser automation")
-			}
-		}
-	} else {
-		// LibGen and others - find "GET" link
-		if getLink := doc.Find("a:contains('GET')"); getLink.Length() > 0 {
-			downloadURL, _ = getLink.Attr("href")
-		}
-	}
-
-	if downloadURL == "" {
-		return "", fmt.Errorf("no download link found")
-	}
-
-	return downloader.GetAbsoluteURL(link, downloadURL)
-}
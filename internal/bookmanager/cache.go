@@ -0,0 +1,90 @@
+package bookmanager
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/config"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/downloader"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/models"
+)
+
+// DefaultInfoCacheTTL is how long scraped book info is kept by default
+const DefaultInfoCacheTTL = 10 * time.Minute
+
+// infoCacheEntry is a cached book info with its expiry time
+type infoCacheEntry struct {
+	book    *models.BookInfo
+	expires time.Time
+}
+
+// InfoCache is a short-lived in-memory cache of book info keyed by book ID.
+// It avoids scraping the same book page twice when the info modal is
+// followed by a download request.
+type InfoCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]infoCacheEntry
+}
+
+// NewInfoCache creates a new InfoCache with the given time to live
+func NewInfoCache(ttl time.Duration) *InfoCache {
+	return &InfoCache{
+		ttl:     ttl,
+		entries: make(map[string]infoCacheEntry),
+	}
+}
+
+// Get returns a copy of the cached book info if present and not expired
+func (c *InfoCache) Get(bookID string) (*models.BookInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, exists := c.entries[bookID]
+	if !exists {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, bookID)
+		return nil, false
+	}
+
+	book := *entry.book
+	return &book, true
+}
+
+// Set stores a copy of the book info and drops expired entries
+func (c *InfoCache) Set(bookID string, book *models.BookInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for id, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, id)
+		}
+	}
+
+	stored := *book
+	c.entries[bookID] = infoCacheEntry{
+		book:    &stored,
+		expires: now.Add(c.ttl),
+	}
+}
+
+// GetBookInfo returns book info from the cache, scraping it with GetBookInfo
+// on a miss. The returned BookInfo is a copy the caller may modify.
+func (c *InfoCache) GetBookInfo(ctx context.Context, cfg *config.Config, network *downloader.Network, bookID string) (*models.BookInfo, error) {
+	if book, ok := c.Get(bookID); ok {
+		return book, nil
+	}
+
+	book, err := GetBookInfo(ctx, cfg, network, bookID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Set(bookID, book)
+	return book, nil
+}
@@ -0,0 +1,44 @@
+package bookmanager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/models"
+)
+
+func TestInfoCacheGetSet(t *testing.T) {
+	cache := NewInfoCache(time.Minute)
+
+	if _, ok := cache.Get("book-1"); ok {
+		t.Fatal("Expected empty cache miss")
+	}
+
+	cache.Set("book-1", &models.BookInfo{ID: "book-1", Title: "Cached Book"})
+
+	book, ok := cache.Get("book-1")
+	if !ok {
+		t.Fatal("Expected cache hit")
+	}
+	if book.Title != "Cached Book" {
+		t.Errorf("Expected title 'Cached Book', got '%s'", book.Title)
+	}
+
+	// Modifying the returned copy must not affect the cached entry
+	book.Priority = 5
+	again, _ := cache.Get("book-1")
+	if again.Priority != 0 {
+		t.Errorf("Expected cached priority 0, got %d", again.Priority)
+	}
+}
+
+func TestInfoCacheExpiry(t *testing.T) {
+	cache := NewInfoCache(10 * time.Millisecond)
+	cache.Set("book-1", &models.BookInfo{ID: "book-1"})
+
+	time.Sleep(20 * time.Millisecond)
+
+	if _, ok := cache.Get("book-1"); ok {
+		t.Error("Expected expired entry to be a miss")
+	}
+}
@@ -2,6 +2,7 @@ package bookmanager
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/url"
 	"sort"
@@ -19,8 +20,11 @@ const (
 	textNodeType = html.TextNode
 )
 
+// ErrNoBooksFound is returned by SearchBooks when the search yields no results
+var ErrNoBooksFound = errors.New("no books found. Please try another query")
+
 // SearchBooks searches for books matching the query
-func SearchBooks(ctx context.Context, cfg *config.Config, query string, filters models.SearchFilters) ([]models.BookInfo, error) {
+func SearchBooks(ctx context.Context, cfg *config.Config, network *downloader.Network, query string, filters models.SearchFilters) ([]models.BookInfo, error) {
 	queryHTML := url.QueryEscape(query)
 
 	// Handle ISBN filters
@@ -76,20 +80,20 @@ func SearchBooks(ctx context.Context, cfg *config.Config, query string, filters
 	// Build URL
 	searchURL := fmt.Sprintf(
 		"%s/search?index=&page=1&display=table&acc=aa_download&acc=external_download&ext=%s&q=%s%s",
-		cfg.AABaseURL,
+		network.BaseURL(),
 		strings.Join(formatsToUse, "&ext="),
 		queryHTML,
 		filtersQuery,
 	)
 
 	// Fetch HTML page
-	html, err := downloader.HTMLGetPage(ctx, cfg, searchURL, false)
+	html, err := network.HTMLGetPage(ctx, searchURL, false)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch search results: %w", err)
 	}
 
 	if strings.Contains(html, "No files found.") {
-		return nil, fmt.Errorf("no books found. Please try another query")
+		return nil, ErrNoBooksFound
 	}
 
 	// Parse HTML
@@ -101,7 +105,7 @@ func SearchBooks(ctx context.Context, cfg *config.Config, query string, filters
 	// Find results table
 	table := doc.Find("table").First()
 	if table.Length() == 0 {
-		return nil, fmt.Errorf("no books found. Please try another query")
+		return nil, ErrNoBooksFound
 	}
 
 	// Parse results
@@ -7,6 +7,7 @@ import (
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/veverkap/calibre-web-automated-book-downloader/internal/config"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/downloader"
 )
 
 func TestParseSearchResultRow(t *testing.T) {
@@ -207,7 +208,7 @@ func TestParseBookInfoPage(t *testing.T) {
 		SupportedFormats: "epub,mobi,pdf",
 	}
 
-	book, err := parseBookInfoPage(context.Background(), cfg, doc, "test123")
+	book, err := parseBookInfoPage(context.Background(), cfg, downloader.NewNetwork(cfg), doc, "test123")
 	if err != nil {
 		t.Fatalf("Failed to parse book info: %v", err)
 	}
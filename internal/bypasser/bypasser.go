@@ -0,0 +1,210 @@
+package bypasser
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/config"
+)
+
+// ErrNotConfigured is returned when the external bypasser URL or path is missing
+var ErrNotConfigured = errors.New("external bypasser is not configured")
+
+// Error is returned when the external bypasser fails to solve a page
+type Error struct {
+	URL        string
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("external bypasser failed for %s: %s (%s)", e.URL, e.Message, e.Status)
+	}
+	return fmt.Sprintf("external bypasser failed for %s: HTTP %d", e.URL, e.StatusCode)
+}
+
+// request is a FlareSolverr command
+type request struct {
+	Cmd        string `json:"cmd"`
+	URL        string `json:"url"`
+	MaxTimeout int    `json:"maxTimeout"`
+}
+
+// Cookie is a cookie returned by FlareSolverr
+type Cookie struct {
+	Name     string  `json:"name"`
+	Value    string  `json:"value"`
+	Domain   string  `json:"domain"`
+	Path     string  `json:"path"`
+	Expires  float64 `json:"expires"`
+	HTTPOnly bool    `json:"httpOnly"`
+	Secure   bool    `json:"secure"`
+}
+
+// Solution is the page FlareSolverr fetched on our behalf
+type Solution struct {
+	URL       string   `json:"url"`
+	Status    int      `json:"status"`
+	Response  string   `json:"response"`
+	Cookies   []Cookie `json:"cookies"`
+	UserAgent string   `json:"userAgent"`
+}
+
+// response is a FlareSolverr reply
+type response struct {
+	Status   string    `json:"status"`
+	Message  string    `json:"message"`
+	Solution *Solution `json:"solution"`
+}
+
+// Client talks to an external FlareSolverr compatible Cloudflare bypasser.
+// Cookies and the user agent of solved pages are kept so follow-up requests,
+// such as file downloads, pass the same Cloudflare checks.
+type Client struct {
+	endpoint   string
+	maxTimeout int
+	httpClient *http.Client
+
+	mu        sync.RWMutex
+	jar       *cookiejar.Jar
+	userAgent string
+}
+
+// NewClient creates a new external bypasser client from the configuration
+func NewClient(cfg *config.Config) *Client {
+	jar, _ := cookiejar.New(nil)
+	return &Client{
+		endpoint:   cfg.ExtBypasserURL + cfg.ExtBypasserPath,
+		maxTimeout: cfg.ExtBypasserTimeout,
+		httpClient: &http.Client{
+			// Leave FlareSolverr time to answer after its own timeout expires
+			Timeout: time.Duration(cfg.ExtBypasserTimeout)*time.Millisecond + 30*time.Second,
+		},
+		jar: jar,
+	}
+}
+
+// GetPage fetches the HTML content of a URL through the bypasser
+func (c *Client) GetPage(ctx context.Context, pageURL string) (string, error) {
+	solution, err := c.Solve(ctx, pageURL)
+	if err != nil {
+		return "", err
+	}
+	return solution.Response, nil
+}
+
+// Solve sends a request.get command to the bypasser and records the
+// cookies and user agent of the solution
+func (c *Client) Solve(ctx context.Context, pageURL string) (*Solution, error) {
+	if c.endpoint == "" || !strings.HasPrefix(c.endpoint, "http") {
+		return nil, ErrNotConfigured
+	}
+
+	body, err := json.Marshal(request{
+		Cmd:        "request.get",
+		URL:        pageURL,
+		MaxTimeout: c.maxTimeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode bypasser request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bypasser request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach external bypasser: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bypasser response: %w", err)
+	}
+
+	var result response
+	if err := json.Unmarshal(data, &result); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, &Error{URL: pageURL, StatusCode: resp.StatusCode}
+		}
+		return nil, fmt.Errorf("failed to parse bypasser response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK || result.Status != "ok" || result.Solution == nil {
+		return nil, &Error{
+			URL:        pageURL,
+			StatusCode: resp.StatusCode,
+			Status:     result.Status,
+			Message:    result.Message,
+		}
+	}
+
+	c.remember(result.Solution)
+	return result.Solution, nil
+}
+
+// remember stores the cookies and user agent of a solution
+func (c *Client) remember(solution *Solution) {
+	u, err := url.Parse(solution.URL)
+	if err != nil || u.Host == "" {
+		return
+	}
+
+	cookies := make([]*http.Cookie, 0, len(solution.Cookies))
+	for _, ck := range solution.Cookies {
+		cookie := &http.Cookie{
+			Name:     ck.Name,
+			Value:    ck.Value,
+			Domain:   ck.Domain,
+			Path:     ck.Path,
+			HttpOnly: ck.HTTPOnly,
+			Secure:   ck.Secure,
+		}
+		if ck.Expires > 0 {
+			cookie.Expires = time.Unix(int64(ck.Expires), 0)
+		}
+		cookies = append(cookies, cookie)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.jar.SetCookies(u, cookies)
+	if solution.UserAgent != "" {
+		c.userAgent = solution.UserAgent
+	}
+}
+
+// ApplyTo adds the cookies and user agent from previously solved pages to a
+// request for the same site
+func (c *Client) ApplyTo(req *http.Request) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	cookies := c.jar.Cookies(req.URL)
+	if len(cookies) == 0 {
+		return
+	}
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+}
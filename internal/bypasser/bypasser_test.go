@@ -0,0 +1,129 @@
+package bypasser
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/config"
+)
+
+// newFlareSolverr creates a FlareSolverr stand-in that answers with the given handler
+func newFlareSolverr(t *testing.T, handler func(req request) (int, interface{})) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/v1" {
+			t.Errorf("Unexpected bypasser request %s %s", r.Method, r.URL.Path)
+		}
+
+		var req request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("Failed to decode bypasser request: %v", err)
+		}
+
+		status, body := handler(req)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}))
+}
+
+func newTestConfig(serverURL string) *config.Config {
+	return &config.Config{
+		UsingExternalBypasser: true,
+		ExtBypasserURL:        serverURL,
+		ExtBypasserPath:       "/v1",
+		ExtBypasserTimeout:    1000,
+	}
+}
+
+func TestClientGetPage(t *testing.T) {
+	server := newFlareSolverr(t, func(req request) (int, interface{}) {
+		if req.Cmd != "request.get" {
+			t.Errorf("Expected cmd 'request.get', got '%s'", req.Cmd)
+		}
+		if req.URL != "https://example.com/page" {
+			t.Errorf("Unexpected URL %s", req.URL)
+		}
+		if req.MaxTimeout != 1000 {
+			t.Errorf("Expected maxTimeout 1000, got %d", req.MaxTimeout)
+		}
+		return http.StatusOK, map[string]interface{}{
+			"status":  "ok",
+			"message": "Challenge solved!",
+			"solution": map[string]interface{}{
+				"url":       "https://example.com/page",
+				"status":    200,
+				"response":  "<html>solved</html>",
+				"userAgent": "Solver/1.0",
+				"cookies": []map[string]interface{}{
+					{"name": "cf_clearance", "value": "token", "domain": ".example.com", "path": "/"},
+				},
+			},
+		}
+	})
+	defer server.Close()
+
+	client := NewClient(newTestConfig(server.URL))
+
+	html, err := client.GetPage(context.Background(), "https://example.com/page")
+	if err != nil {
+		t.Fatalf("GetPage failed: %v", err)
+	}
+	if html != "<html>solved</html>" {
+		t.Errorf("Unexpected HTML %q", html)
+	}
+
+	// Follow-up requests to the same site reuse the session
+	req, _ := http.NewRequest("GET", "https://files.example.com/book.epub", nil)
+	client.ApplyTo(req)
+
+	cookie, err := req.Cookie("cf_clearance")
+	if err != nil || cookie.Value != "token" {
+		t.Errorf("Expected cf_clearance cookie, got %v", req.Header.Get("Cookie"))
+	}
+	if ua := req.Header.Get("User-Agent"); ua != "Solver/1.0" {
+		t.Errorf("Expected solver user agent, got '%s'", ua)
+	}
+
+	// Other sites are left alone
+	other, _ := http.NewRequest("GET", "https://other.org/book.epub", nil)
+	client.ApplyTo(other)
+	if other.Header.Get("Cookie") != "" || other.Header.Get("User-Agent") != "" {
+		t.Error("Session should not be applied to other sites")
+	}
+}
+
+func TestClientGetPageError(t *testing.T) {
+	server := newFlareSolverr(t, func(req request) (int, interface{}) {
+		return http.StatusInternalServerError, map[string]interface{}{
+			"status":  "error",
+			"message": "Error: Maximum timeout reached",
+		}
+	})
+	defer server.Close()
+
+	client := NewClient(newTestConfig(server.URL))
+
+	_, err := client.GetPage(context.Background(), "https://example.com/page")
+	var bypassErr *Error
+	if !errors.As(err, &bypassErr) {
+		t.Fatalf("Expected bypasser error, got %v", err)
+	}
+	if bypassErr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Expected status code 500, got %d", bypassErr.StatusCode)
+	}
+	if bypassErr.Message != "Error: Maximum timeout reached" {
+		t.Errorf("Unexpected message '%s'", bypassErr.Message)
+	}
+}
+
+func TestClientNotConfigured(t *testing.T) {
+	client := NewClient(&config.Config{ExtBypasserPath: "/v1"})
+
+	if _, err := client.GetPage(context.Background(), "https://example.com"); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("Expected ErrNotConfigured, got %v", err)
+	}
+}
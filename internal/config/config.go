@@ -11,7 +11,13 @@ import (
 // Config holds all application configuration
 type Config struct {
 	// Database
-	CWADBPath string
+	CWADBPath        string
+	QueueDBPath      string
+	CalibreLibraryDB string
+
+	// Sessions
+	SessionSecret string
+	SessionTTL    int
 
 	// Paths
 	LogRoot   string
@@ -49,6 +55,8 @@ type Config struct {
 	SupportedFormats string
 	BookLanguage     string
 	CustomScript     string
+	FilenameTemplate string
+	DuplicatePolicy  string
 
 	// Server settings
 	FlaskHost string
@@ -64,6 +72,27 @@ type Config struct {
 	MainLoopSleepTime              int
 	MaxConcurrentDownloads         int
 	DownloadProgressUpdateInterval int
+	DownloadMaxAttempts            int
+	DownloadRetryDelay             int
+	DownloadRetryMaxDelay          int
+
+	// Per-user quota settings
+	UserMaxActiveDownloads int
+	UserDailyDownloadLimit int
+
+	// Per-host politeness settings
+	HostMaxConcurrent int
+	HostMinIntervalMS int
+
+	// Bandwidth settings
+	DownloadRateLimit            string
+	DownloadRateLimitPerDownload string
+	DownloadRateSchedule         string
+
+	// Conversion settings
+	ConvertRules   string
+	ConvertCommand string
+	ConvertTimeout int
 
 	// DNS settings
 	CustomDNS string
@@ -87,6 +116,10 @@ func Load() (*Config, error) {
 
 	cfg := &Config{
 		CWADBPath:                      v.GetString("CWA_DB_PATH"),
+		QueueDBPath:                    strings.TrimSpace(v.GetString("QUEUE_DB_PATH")),
+		CalibreLibraryDB:               strings.TrimSpace(v.GetString("CALIBRE_LIBRARY_DB")),
+		SessionSecret:                  v.GetString("SESSION_SECRET"),
+		SessionTTL:                     v.GetInt("SESSION_TTL"),
 		LogRoot:                        v.GetString("LOG_ROOT"),
 		LogDir:                         v.GetString("LOG_DIR"),
 		TmpDir:                         v.GetString("TMP_DIR"),
@@ -104,6 +137,8 @@ func Load() (*Config, error) {
 		SupportedFormats:               strings.ToLower(v.GetString("SUPPORTED_FORMATS")),
 		BookLanguage:                   strings.ToLower(v.GetString("BOOK_LANGUAGE")),
 		CustomScript:                   strings.TrimSpace(v.GetString("CUSTOM_SCRIPT")),
+		FilenameTemplate:               strings.TrimSpace(v.GetString("FILENAME_TEMPLATE")),
+		DuplicatePolicy:                strings.ToLower(strings.TrimSpace(v.GetString("DUPLICATE_POLICY"))),
 		FlaskHost:                      v.GetString("FLASK_HOST"),
 		FlaskPort:                      v.GetInt("FLASK_PORT"),
 		Debug:                          v.GetBool("DEBUG"),
@@ -116,6 +151,19 @@ func Load() (*Config, error) {
 		MainLoopSleepTime:              v.GetInt("MAIN_LOOP_SLEEP_TIME"),
 		MaxConcurrentDownloads:         v.GetInt("MAX_CONCURRENT_DOWNLOADS"),
 		DownloadProgressUpdateInterval: v.GetInt("DOWNLOAD_PROGRESS_UPDATE_INTERVAL"),
+		DownloadMaxAttempts:            v.GetInt("DOWNLOAD_MAX_ATTEMPTS"),
+		DownloadRetryDelay:             v.GetInt("DOWNLOAD_RETRY_DELAY"),
+		DownloadRetryMaxDelay:          v.GetInt("DOWNLOAD_RETRY_MAX_DELAY"),
+		UserMaxActiveDownloads:         v.GetInt("USER_MAX_ACTIVE_DOWNLOADS"),
+		UserDailyDownloadLimit:         v.GetInt("USER_DAILY_DOWNLOAD_LIMIT"),
+		HostMaxConcurrent:              v.GetInt("HOST_MAX_CONCURRENT"),
+		HostMinIntervalMS:              v.GetInt("HOST_MIN_INTERVAL_MS"),
+		DownloadRateLimit:              strings.TrimSpace(v.GetString("DOWNLOAD_RATE_LIMIT")),
+		DownloadRateLimitPerDownload:   strings.TrimSpace(v.GetString("DOWNLOAD_RATE_LIMIT_PER_DOWNLOAD")),
+		DownloadRateSchedule:           strings.TrimSpace(v.GetString("DOWNLOAD_RATE_SCHEDULE")),
+		ConvertRules:                   strings.ToLower(strings.TrimSpace(v.GetString("CONVERT_RULES"))),
+		ConvertCommand:                 strings.TrimSpace(v.GetString("CONVERT_COMMAND")),
+		ConvertTimeout:                 v.GetInt("CONVERT_TIMEOUT"),
 		DockerMode:                     v.GetBool("DOCKERMODE"),
 		CustomDNS:                      strings.TrimSpace(v.GetString("CUSTOM_DNS")),
 		UseDOH:                         v.GetBool("USE_DOH"),
@@ -154,6 +202,7 @@ func Load() (*Config, error) {
 }
 
 func setDefaults(v *viper.Viper) {
+	v.SetDefault("SESSION_TTL", 604800)
 	v.SetDefault("LOG_ROOT", "/var/log/")
 	v.SetDefault("TMP_DIR", "/tmp/cwa-book-downloader")
 	v.SetDefault("INGEST_DIR", "/cwa-book-ingest")
@@ -165,6 +214,7 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("AA_BASE_URL", "auto")
 	v.SetDefault("SUPPORTED_FORMATS", "epub,mobi,azw3,fb2,djvu,cbz,cbr")
 	v.SetDefault("BOOK_LANGUAGE", "en")
+	v.SetDefault("DUPLICATE_POLICY", "warn")
 	v.SetDefault("FLASK_HOST", "0.0.0.0")
 	v.SetDefault("FLASK_PORT", 8084)
 	v.SetDefault("DEBUG", false)
@@ -178,6 +228,15 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("MAIN_LOOP_SLEEP_TIME", 5)
 	v.SetDefault("MAX_CONCURRENT_DOWNLOADS", 3)
 	v.SetDefault("DOWNLOAD_PROGRESS_UPDATE_INTERVAL", 5)
+	v.SetDefault("DOWNLOAD_MAX_ATTEMPTS", 3)
+	v.SetDefault("DOWNLOAD_RETRY_DELAY", 30)
+	v.SetDefault("DOWNLOAD_RETRY_MAX_DELAY", 900)
+	v.SetDefault("USER_MAX_ACTIVE_DOWNLOADS", 0)
+	v.SetDefault("USER_DAILY_DOWNLOAD_LIMIT", 0)
+	v.SetDefault("HOST_MAX_CONCURRENT", 2)
+	v.SetDefault("HOST_MIN_INTERVAL_MS", 1000)
+	v.SetDefault("CONVERT_COMMAND", "ebook-convert")
+	v.SetDefault("CONVERT_TIMEOUT", 300)
 	v.SetDefault("DOCKERMODE", false)
 	v.SetDefault("USE_DOH", false)
 	v.SetDefault("BYPASS_RELEASE_INACTIVE_MIN", 5)
@@ -0,0 +1,178 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/config"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/models"
+	"go.uber.org/zap"
+)
+
+// DefaultConvertTimeout is used when no conversion timeout is configured
+const DefaultConvertTimeout = 5 * time.Minute
+
+// ConversionRules maps a book format to the format it is converted to
+type ConversionRules map[string]string
+
+// ParseConversionRules parses a comma separated list of FROM=TO rules,
+// e.g. "mobi=epub,azw3=epub,fb2=epub"
+func ParseConversionRules(s string) (ConversionRules, error) {
+	rules := make(ConversionRules)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		from, to, found := strings.Cut(entry, "=")
+		from = normalizeFormat(from)
+		to = normalizeFormat(to)
+		if !found || from == "" || to == "" {
+			return nil, fmt.Errorf("invalid conversion rule %q: expected FROM=TO", entry)
+		}
+		if from == to {
+			return nil, fmt.Errorf("invalid conversion rule %q: formats are the same", entry)
+		}
+		rules[from] = to
+	}
+	return rules, nil
+}
+
+// normalizeFormat lower cases a format and drops a leading dot
+func normalizeFormat(format string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(format), "."))
+}
+
+// Converter converts downloaded books with an external command called as
+// "<command> <input> <output>", the way Calibre's ebook-convert expects.
+// The output format is picked from the extension of the output file.
+type Converter struct {
+	Command []string
+	Rules   ConversionRules
+	Timeout time.Duration
+}
+
+// NewConverter creates the converter from the conversion settings. It
+// returns nil when no conversion rules are configured.
+func NewConverter(cfg *config.Config) (*Converter, error) {
+	rules, err := ParseConversionRules(cfg.ConvertRules)
+	if err != nil {
+		return nil, fmt.Errorf("CONVERT_RULES: %w", err)
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	command := strings.Fields(cfg.ConvertCommand)
+	if len(command) == 0 {
+		return nil, errors.New("CONVERT_COMMAND: no converter command configured")
+	}
+
+	timeout := time.Duration(cfg.ConvertTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = DefaultConvertTimeout
+	}
+	return &Converter{Command: command, Rules: rules, Timeout: timeout}, nil
+}
+
+// Target returns the format a book in the given format is converted to
+func (c *Converter) Target(format string) (string, bool) {
+	target, ok := c.Rules[normalizeFormat(format)]
+	return target, ok
+}
+
+// Convert converts inputPath into outputPath and checks that the result
+// looks like a book in the target format
+func (c *Converter) Convert(ctx context.Context, inputPath, outputPath, format string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	args := append(append([]string{}, c.Command[1:]...), inputPath, outputPath)
+	cmd := exec.CommandContext(ctx, c.Command[0], args...)
+	// Don't wait forever on children of a killed converter holding the output open
+	cmd.WaitDelay = 5 * time.Second
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("converter timed out after %v", c.Timeout)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if last := lastLine(output.String()); last != "" {
+			return fmt.Errorf("converter failed: %w: %s", err, last)
+		}
+		return fmt.Errorf("converter failed: %w", err)
+	}
+
+	if _, err := os.Stat(outputPath); err != nil {
+		return fmt.Errorf("converter did not write %s", outputPath)
+	}
+	return ValidateFile(outputPath, format)
+}
+
+// lastLine returns the last non-empty line of a command's output
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// convert converts a downloaded book and returns the file to ingest: the
+// converted file on success, the original when the conversion failed
+func (d *Downloader) convert(ctx context.Context, path, from, to string) (string, *models.Conversion) {
+	convertedPath := strings.TrimSuffix(path, filepath.Ext(path)) + "." + to
+	conversion := &models.Conversion{From: normalizeFormat(from), To: to, Time: time.Now()}
+
+	d.logger.Info("Converting book",
+		zap.String("path", path),
+		zap.String("from", from),
+		zap.String("to", to))
+	if err := d.converter.Convert(ctx, path, convertedPath, to); err != nil {
+		os.Remove(convertedPath)
+		d.logger.Warn("Conversion failed, keeping the original format",
+			zap.String("path", path),
+			zap.Error(err))
+		conversion.Status = models.ConversionFailed
+		conversion.Error = err.Error()
+		return path, conversion
+	}
+
+	os.Remove(path)
+	conversion.Status = models.ConversionConverted
+	if checksum, err := fileMD5(convertedPath); err == nil {
+		conversion.OutputMD5 = checksum
+	} else {
+		d.logger.Warn("Failed to hash converted book", zap.String("path", convertedPath), zap.Error(err))
+	}
+	return convertedPath, conversion
+}
+
+// fileMD5 returns the hex encoded MD5 of a file
+func fileMD5(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := md5.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
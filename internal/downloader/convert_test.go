@@ -0,0 +1,149 @@
+package downloader
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/config"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/models"
+	"go.uber.org/zap"
+)
+
+// writeScript writes an executable shell script used as a fake converter
+func writeScript(t *testing.T, dir, body string) string {
+	t.Helper()
+	path := filepath.Join(dir, "convert.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseConversionRules(t *testing.T) {
+	rules, err := ParseConversionRules(" MOBI=epub, .azw3 = epub ,,fb2=epub")
+	if err != nil {
+		t.Fatalf("ParseConversionRules failed: %v", err)
+	}
+	if len(rules) != 3 || rules["mobi"] != "epub" || rules["azw3"] != "epub" || rules["fb2"] != "epub" {
+		t.Errorf("Unexpected rules %v", rules)
+	}
+
+	for _, invalid := range []string{"mobi", "mobi=", "=epub", "epub=epub"} {
+		if _, err := ParseConversionRules(invalid); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestNewConverterDisabledWithoutRules(t *testing.T) {
+	converter, err := NewConverter(&config.Config{ConvertCommand: "ebook-convert"})
+	if err != nil || converter != nil {
+		t.Errorf("NewConverter() = %v, %v, want nil, nil", converter, err)
+	}
+}
+
+func TestConverterTimeout(t *testing.T) {
+	dir := t.TempDir()
+	converter := &Converter{
+		Command: []string{writeScript(t, dir, "exec sleep 10")},
+		Rules:   ConversionRules{"mobi": "epub"},
+		Timeout: 100 * time.Millisecond,
+	}
+
+	start := time.Now()
+	err := converter.Convert(context.Background(), filepath.Join(dir, "in.mobi"), filepath.Join(dir, "out.epub"), "epub")
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("Convert() error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Convert() took %v, expected the converter to be killed", elapsed)
+	}
+}
+
+// downloadMobi downloads a MOBI book with the given converter script
+func downloadMobi(t *testing.T, script string) (*DownloadResult, string) {
+	t.Helper()
+	content := mobiHeader("BOOKMOBI")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	t.Cleanup(server.Close)
+
+	tmpDir := t.TempDir()
+	ingestDir := filepath.Join(tmpDir, "ingest")
+	if err := os.MkdirAll(ingestDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		TmpDir:         tmpDir,
+		IngestDir:      ingestDir,
+		ConvertRules:   "mobi=epub",
+		ConvertCommand: writeScript(t, tmpDir, script),
+		ConvertTimeout: 10,
+	}
+
+	format := "mobi"
+	book := &models.BookInfo{
+		ID:           "test123",
+		Title:        "Test Book",
+		Format:       &format,
+		DownloadURLs: []string{server.URL},
+	}
+
+	result, err := NewDownloader(cfg, zap.NewNop(), NewNetwork(cfg)).DownloadBook(context.Background(), book, nil, nil)
+	if err != nil {
+		t.Fatalf("DownloadBook failed: %v", err)
+	}
+	return result, tmpDir
+}
+
+func TestDownloadBookConvertsFormat(t *testing.T) {
+	result, tmpDir := downloadMobi(t, `printf 'PK\003\004converted' > "$2"`)
+
+	if filepath.Ext(result.Path) != ".epub" {
+		t.Errorf("Expected the converted EPUB to be ingested, got %s", result.Path)
+	}
+	if content, err := os.ReadFile(result.Path); err != nil || !strings.HasSuffix(string(content), "converted") {
+		t.Errorf("Unexpected ingested file: %q, %v", content, err)
+	}
+	if result.Conversion == nil || result.Conversion.Status != models.ConversionConverted ||
+		result.Conversion.From != "mobi" || result.Conversion.To != "epub" {
+		t.Errorf("Unexpected conversion %+v", result.Conversion)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "test123.mobi")); !os.IsNotExist(err) {
+		t.Error("Expected the original to be removed after the conversion")
+	}
+
+	converted := md5.Sum([]byte("PK\003\004converted"))
+	if result.Conversion != nil && result.Conversion.OutputMD5 != hex.EncodeToString(converted[:]) {
+		t.Errorf("Expected the MD5 of the ingested file, got %q", result.Conversion.OutputMD5)
+	}
+	original := md5.Sum(mobiHeader("BOOKMOBI"))
+	if result.MD5 != hex.EncodeToString(original[:]) {
+		t.Errorf("Expected the MD5 of the downloaded file, got %q", result.MD5)
+	}
+}
+
+func TestDownloadBookKeepsOriginalWhenConversionFails(t *testing.T) {
+	result, _ := downloadMobi(t, `echo "unsupported input" >&2; exit 1`)
+
+	if filepath.Ext(result.Path) != ".mobi" {
+		t.Errorf("Expected the original MOBI to be ingested, got %s", result.Path)
+	}
+	if _, err := os.Stat(result.Path); err != nil {
+		t.Errorf("Ingested file is missing: %v", err)
+	}
+	if result.Conversion == nil || result.Conversion.Status != models.ConversionFailed ||
+		!strings.Contains(result.Conversion.Error, "unsupported input") {
+		t.Errorf("Unexpected conversion %+v", result.Conversion)
+	}
+}
@@ -1,8 +1,10 @@
 package downloader
 
 import (
-	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,11 +12,11 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
 	"strings"
 	"time"
 
 	"github.com/veverkap/calibre-web-automated-book-downloader/internal/config"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/hostlimit"
 	"github.com/veverkap/calibre-web-automated-book-downloader/internal/models"
 	"go.uber.org/zap"
 )
@@ -24,9 +26,6 @@ const (
 	MinDownloadSizeRatio = 0.9
 	// TempDownloadExt is the extension used for files being downloaded
 	TempDownloadExt = ".crdownload"
-	// expectedSizeRatio is the minimum ratio of downloaded bytes to expected size
-	// required to consider the download successful (90%) - used for buffer downloads
-	expectedSizeRatio = 0.9
 )
 
 // ProgressCallback is a function that receives download progress updates (0-100)
@@ -46,50 +45,107 @@ var DefaultClient HTTPClient = &http.Client{
 type Downloader struct {
 	config     *config.Config
 	logger     *zap.Logger
+	network    *Network
 	httpClient *http.Client
+	converter  *Converter
+	filenames  *FilenameTemplate
 }
 
-// NewDownloader creates a new Downloader instance
-func NewDownloader(cfg *config.Config, logger *zap.Logger) *Downloader {
-	// Create HTTP client with proxy support if configured
-	transport := &http.Transport{}
-
-	if cfg.HTTPProxy != "" || cfg.HTTPSProxy != "" {
-		// Proxy configuration would go here
-		// For now, using default transport
-	}
-
+// NewDownloader creates a new Downloader instance making its requests through network
+func NewDownloader(cfg *config.Config, logger *zap.Logger, network *Network) *Downloader {
+	// Create HTTP client with proxy and DNS settings. Downloads only count
+	// against the host limit until their headers arrive, so they do not
+	// starve page requests to the same host.
+	transport := network.newTransport()
+	transport.ReleaseOnHeaders = true
 	client := &http.Client{
 		Transport: transport,
 		Timeout:   0, // No timeout for downloads, we'll handle cancellation
 	}
 
+	converter, err := NewConverter(cfg)
+	if err != nil {
+		logger.Warn("Invalid conversion settings, books are ingested as downloaded", zap.Error(err))
+	}
+
+	filenames, err := NewFilenameTemplate(cfg)
+	if err != nil {
+		logger.Warn("Invalid filename template, naming books by ID", zap.Error(err))
+		filenames, _ = ParseFilenameTemplate("{id}")
+	}
+
 	return &Downloader{
 		config:     cfg,
 		logger:     logger,
+		network:    network,
 		httpClient: client,
+		converter:  converter,
+		filenames:  filenames,
 	}
 }
 
-// sanitizeFilename removes invalid characters from filename
-func sanitizeFilename(filename string) string {
-	// Keep only alphanumeric, spaces, dots, and underscores
-	reg := regexp.MustCompile(`[^a-zA-Z0-9 ._-]`)
-	sanitized := reg.ReplaceAllString(filename, "")
-	return strings.TrimSpace(sanitized)
+// HTMLGetPage fetches HTML content from a URL with retry mechanism
+func (n *Network) HTMLGetPage(ctx context.Context, urlStr string, useBypasser bool) (string, error) {
+	return n.htmlGetPageRetry(ctx, urlStr, n.config.MaxRetry, useBypasser)
 }
 
-// HTMLGetPage fetches HTML content from a URL with retry mechanism
-func HTMLGetPage(ctx context.Context, cfg *config.Config, urlStr string, useBypasser bool) (string, error) {
-	return htmlGetPageRetry(ctx, cfg, urlStr, cfg.MaxRetry, useBypasser)
+// htmlGetPageRetry fetches a page up to retry+1 times. Rate limits, server
+// errors and network failures are retried after a growing pause; a 403 is
+// retried once through the Cloudflare bypasser when one is configured, even
+// without retries left. Other failures end the loop right away. The error
+// lists every failed attempt.
+func (n *Network) htmlGetPageRetry(ctx context.Context, urlStr string, retry int, useBypasser bool) (string, error) {
+	fetchErr := &FetchError{URL: urlStr}
+
+	backoff := false
+	for attempt := 0; attempt <= retry; attempt++ {
+		if backoff {
+			if err := sleepContext(ctx, time.Duration(n.config.DefaultSleep*attempt)*time.Second); err != nil {
+				fetchErr.Attempts = append(fetchErr.Attempts, err)
+				return "", fetchErr
+			}
+		}
+
+		// Follow a mirror switch made by a previous attempt
+		html, err := n.fetchPage(ctx, n.Mirrors.Rewrite(urlStr), useBypasser)
+		if err == nil {
+			return html, nil
+		}
+		fetchErr.Attempts = append(fetchErr.Attempts, err)
+		if ctx.Err() != nil {
+			return "", fetchErr
+		}
+
+		switch {
+		case errors.Is(err, ErrForbidden) && !useBypasser && n.bypasserAvailable():
+			// Probably a Cloudflare challenge, ask the bypasser right away
+			// without using up the retry budget
+			useBypasser = true
+			backoff = false
+			retry++
+		case errors.Is(err, ErrRateLimited) || IsTransient(err):
+			backoff = true
+		default:
+			return "", fetchErr
+		}
+	}
+	return "", fetchErr
 }
 
-// htmlGetPageRetry internal function with retry logic
-func htmlGetPageRetry(ctx context.Context, cfg *config.Config, urlStr string, retry int, useBypasser bool) (string, error) {
-	// TODO: Implement Cloudflare bypasser integration when useBypasser is true
-	if useBypasser && cfg.UseCFBypass {
-		// For now, we'll fall through to regular HTTP request
-		// This will be implemented in Phase 4
+// bypasserAvailable reports whether pages can be fetched through the external Cloudflare bypasser
+func (n *Network) bypasserAvailable() bool {
+	return n.config.UseCFBypass && n.config.UsingExternalBypasser
+}
+
+// fetchPage makes a single attempt at fetching a page
+func (n *Network) fetchPage(ctx context.Context, urlStr string, useBypasser bool) (string, error) {
+	// Use the external Cloudflare bypasser when requested
+	if useBypasser && n.bypasserAvailable() {
+		html, err := n.Bypasser.GetPage(ctx, urlStr)
+		if err != nil {
+			return "", fmt.Errorf("failed to fetch page through bypasser: %w", err)
+		}
+		return html, nil
 	}
 
 	req, err := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
@@ -99,76 +155,85 @@ func htmlGetPageRetry(ctx context.Context, cfg *config.Config, urlStr string, re
 
 	// Add headers
 	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.3")
+	n.applyBypasserSession(req)
 
 	// Create client with proxy if configured
-	client := createHTTPClient(cfg)
+	client := n.newHTTPClient()
 
 	resp, err := client.Do(req)
 	if err != nil {
-		if retry == 0 {
-			return "", fmt.Errorf("failed to fetch page: %w", err)
-		}
-		sleepTime := time.Duration(cfg.DefaultSleep*(cfg.MaxRetry-retry+1)) * time.Second
-		time.Sleep(sleepTime)
-		return htmlGetPageRetry(ctx, cfg, urlStr, retry-1, useBypasser)
+		n.Mirrors.ReportFailure(urlStr, err)
+		return "", fmt.Errorf("failed to fetch page: %w", err)
 	}
 	defer resp.Body.Close()
 
-	// Handle specific status codes
-	if resp.StatusCode == 404 {
-		return "", fmt.Errorf("404 error for URL: %s", urlStr)
-	}
-
-	if resp.StatusCode == 403 {
-		// 403 detected, should retry using cloudflare bypass
-		if retry > 0 {
-			return htmlGetPageRetry(ctx, cfg, urlStr, retry-1, true)
-		}
-		return "", fmt.Errorf("403 error for URL: %s", urlStr)
-	}
-
-	if resp.StatusCode != 200 {
-		if retry == 0 {
-			return "", fmt.Errorf("unexpected status code %d for URL: %s", resp.StatusCode, urlStr)
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode >= 500 {
+			n.Mirrors.ReportFailure(urlStr, fmt.Errorf("unexpected status code %d", resp.StatusCode))
 		}
-		sleepTime := time.Duration(cfg.DefaultSleep*(cfg.MaxRetry-retry+1)) * time.Second
-		time.Sleep(sleepTime)
-		return htmlGetPageRetry(ctx, cfg, urlStr, retry-1, useBypasser)
+		return "", &HTTPStatusError{StatusCode: resp.StatusCode, URL: urlStr}
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return "", fmt.Errorf("failed to read response body: %w", err)
 	}
-
-	// Small delay to be respectful
-	time.Sleep(1 * time.Second)
+	n.Mirrors.ReportSuccess(urlStr)
 
 	return string(body), nil
 }
 
-// DownloadURL downloads content from a URL with progress tracking and cancellation support (method on Downloader)
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// DownloadURL downloads content from a URL with progress tracking and cancellation support (method on Downloader).
+// Partial downloads are kept next to outputPath and resumed with a Range request
+// when the server supports it and the file has not changed.
 func (d *Downloader) DownloadURL(ctx context.Context, url string, outputPath string, size string, progressCallback ProgressCallback) error {
+	_, err := d.downloadFile(ctx, url, outputPath, size, progressCallback)
+	return err
+}
+
+// downloadFile works like DownloadURL and returns the hex encoded MD5 of the
+// downloaded file, hashed while streaming
+func (d *Downloader) downloadFile(ctx context.Context, url string, outputPath string, size string, progressCallback ProgressCallback) (string, error) {
 	d.logger.Info("Downloading from URL", zap.String("url", url), zap.String("output", outputPath))
 
-	// Create HTTP request with context for cancellation
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
+	tempPath := outputPath + TempDownloadExt
 
-	// Execute request
-	resp, err := d.httpClient.Do(req)
+	resp, file, offset, remoteSize, err := d.openDownload(ctx, url, tempPath)
 	if err != nil {
 		if ctx.Err() == context.Canceled {
-			return fmt.Errorf("download cancelled")
+			removePartial(tempPath)
+			return "", fmt.Errorf("download cancelled")
 		}
-		return fmt.Errorf("failed to download: %w", err)
+		return "", err
 	}
 	defer resp.Body.Close()
+	defer file.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status: %s", resp.Status)
+	// Hash the bytes already on disk before appending to them
+	hasher := md5.New()
+	if offset > 0 {
+		if err := hashPartial(tempPath, offset, hasher); err != nil {
+			file.Close()
+			removePartial(tempPath)
+			return "", err
+		}
 	}
 
 	// Determine total size
@@ -178,27 +243,21 @@ func (d *Downloader) DownloadURL(ctx context.Context, url string, outputPath str
 		totalSize = parseSizeStringInt64(size)
 	}
 	if totalSize == 0 {
-		totalSize = resp.ContentLength
+		totalSize = remoteSize
 	}
 
-	// Create temporary file for download
-	tempPath := outputPath + TempDownloadExt
-	file, err := os.Create(tempPath)
-	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
-	}
-	defer file.Close()
-
-	// Download with progress tracking
-	var downloaded int64
+	// Download with progress tracking, within the bandwidth limits
+	downloaded := offset
 	buffer := make([]byte, 32*1024) // 32KB buffer
+	stream := d.network.Bandwidth.NewStream()
 
 	for {
 		select {
 		case <-ctx.Done():
 			// Cleanup temp file on cancellation
-			os.Remove(tempPath)
-			return fmt.Errorf("download cancelled")
+			file.Close()
+			removePartial(tempPath)
+			return "", fmt.Errorf("download cancelled")
 		default:
 		}
 
@@ -206,23 +265,36 @@ func (d *Downloader) DownloadURL(ctx context.Context, url string, outputPath str
 		if n > 0 {
 			_, writeErr := file.Write(buffer[:n])
 			if writeErr != nil {
-				os.Remove(tempPath)
-				return fmt.Errorf("failed to write to file: %w", writeErr)
+				file.Close()
+				removePartial(tempPath)
+				return "", fmt.Errorf("failed to write to file: %w", writeErr)
 			}
+			hasher.Write(buffer[:n])
 			downloaded += int64(n)
 
 			// Report progress
 			if progressCallback != nil && totalSize > 0 {
 				progressCallback(float64(downloaded) * 100.0 / float64(totalSize))
 			}
+
+			if waitErr := stream.Wait(ctx, n); waitErr != nil {
+				file.Close()
+				removePartial(tempPath)
+				return "", fmt.Errorf("download cancelled")
+			}
 		}
 
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			os.Remove(tempPath)
-			return fmt.Errorf("failed to read from response: %w", err)
+			file.Close()
+			if ctx.Err() == context.Canceled {
+				removePartial(tempPath)
+				return "", fmt.Errorf("download cancelled")
+			}
+			discardUnresumable(tempPath)
+			return "", fmt.Errorf("failed to read from response: %w", err)
 		}
 	}
 
@@ -231,89 +303,138 @@ func (d *Downloader) DownloadURL(ctx context.Context, url string, outputPath str
 
 	// Validate download size
 	if totalSize > 0 && float64(downloaded) < float64(totalSize)*MinDownloadSizeRatio {
-		os.Remove(tempPath)
-		return fmt.Errorf("incomplete download: got %d bytes, expected %d", downloaded, totalSize)
+		discardUnresumable(tempPath)
+		return "", fmt.Errorf("%w: got %d bytes, expected %d", ErrIncompleteDownload, downloaded, totalSize)
 	}
 
 	// Rename temp file to final path
+	os.Remove(tempPath + ResumeMetaExt)
 	if err := os.Rename(tempPath, outputPath); err != nil {
 		// Try copy if rename fails (cross-device link)
 		if copyErr := copyFile(tempPath, outputPath); copyErr != nil {
 			os.Remove(tempPath)
-			return fmt.Errorf("failed to move file: %w", err)
+			return "", fmt.Errorf("failed to move file: %w", err)
 		}
 		os.Remove(tempPath)
 	}
 
-	d.logger.Info("Download complete", zap.String("path", outputPath), zap.Int64("size", downloaded))
-	return nil
+	checksum := hex.EncodeToString(hasher.Sum(nil))
+	d.logger.Info("Download complete",
+		zap.String("path", outputPath),
+		zap.Int64("size", downloaded),
+		zap.String("md5", checksum))
+	return checksum, nil
 }
 
-// DownloadURLToBuffer downloads content from URL into a buffer (standalone function for bookmanager)
-func DownloadURLToBuffer(ctx context.Context, cfg *config.Config, link string, size string, progressCallback func(float64)) (*bytes.Buffer, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", link, nil)
+// openDownload starts the request for a download, resuming an existing partial
+// file at tempPath when possible. It returns the response, the file to write the
+// body to, the number of bytes already on disk and the remote file size (-1 if unknown).
+func (d *Downloader) openDownload(ctx context.Context, url string, tempPath string) (*http.Response, *os.File, int64, int64, error) {
+	offset, meta := partialOffset(tempPath, url)
+
+	resp, err := d.requestRange(ctx, url, offset, meta)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, nil, 0, 0, err
+	}
+
+	if offset > 0 {
+		switch resp.StatusCode {
+		case http.StatusPartialContent:
+			start, total, err := parseContentRangeStart(resp.Header.Get("Content-Range"))
+			if err == nil && start == offset {
+				file, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_APPEND, 0644)
+				if err != nil {
+					resp.Body.Close()
+					return nil, nil, 0, 0, fmt.Errorf("failed to open partial file: %w", err)
+				}
+				if total < 0 && resp.ContentLength >= 0 {
+					total = offset + resp.ContentLength
+				}
+				d.logger.Info("Resuming partial download",
+					zap.String("url", url),
+					zap.Int64("offset", offset))
+				return resp, file, offset, total, nil
+			}
+			d.logger.Warn("Unexpected Content-Range, restarting download",
+				zap.String("url", url),
+				zap.String("content_range", resp.Header.Get("Content-Range")))
+		case http.StatusOK:
+			// The server ignored the range or the If-Range validator no longer matches
+			d.logger.Info("Partial download cannot be resumed, restarting", zap.String("url", url))
+		case http.StatusRequestedRangeNotSatisfiable:
+			d.logger.Info("Partial download is out of range, restarting", zap.String("url", url))
+		default:
+			// Keep the partial file for a later attempt
+			resp.Body.Close()
+			return nil, nil, 0, 0, &HTTPStatusError{StatusCode: resp.StatusCode, URL: url}
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			// Retry once from scratch
+			resp.Body.Close()
+			removePartial(tempPath)
+			resp, err = d.requestRange(ctx, url, 0, nil)
+			if err != nil {
+				return nil, nil, 0, 0, err
+			}
+		}
 	}
 
-	// Create client with proxy if configured
-	client := createHTTPClient(cfg)
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, nil, 0, 0, &HTTPStatusError{StatusCode: resp.StatusCode, URL: url}
+	}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to download from %s: %w", link, err)
+	// Landing and error pages are not books
+	if isHTMLResponse(resp) {
+		resp.Body.Close()
+		return nil, nil, 0, 0, fmt.Errorf("received an HTML page instead of a file from %s", url)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("unexpected status code %d for URL: %s", resp.StatusCode, link)
+	// Create temporary file for download
+	file, err := os.Create(tempPath)
+	if err != nil {
+		resp.Body.Close()
+		return nil, nil, 0, 0, fmt.Errorf("failed to create file: %w", err)
 	}
 
-	// Parse expected size
-	totalSize := float64(resp.ContentLength)
-	if totalSize == 0 && size != "" {
-		// Try to parse size from string (e.g., "5.2 MB")
-		totalSize = parseSizeStringFloat64(size)
+	// Remember the validators so a later attempt can resume
+	if newMeta := newResumeMeta(url, resp); newMeta != nil {
+		if err := saveResumeMeta(tempPath, newMeta); err != nil {
+			d.logger.Warn("Failed to save resume metadata", zap.Error(err))
+		}
+	} else {
+		os.Remove(tempPath + ResumeMetaExt)
 	}
 
-	buffer := new(bytes.Buffer)
-	downloaded := float64(0)
+	return resp, file, 0, resp.ContentLength, nil
+}
 
-	// Read in chunks
-	chunk := make([]byte, 1000)
-	for {
-		select {
-		case <-ctx.Done():
-			return nil, fmt.Errorf("download cancelled: %s", link)
-		default:
-		}
+// requestRange sends a GET request, asking for the bytes from offset onwards
+// when offset is greater than zero
+func (d *Downloader) requestRange(ctx context.Context, url string, offset int64, meta *resumeMeta) (*http.Response, error) {
+	// Create HTTP request with context for cancellation
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
 
-		n, err := resp.Body.Read(chunk)
-		if n > 0 {
-			buffer.Write(chunk[:n])
-			downloaded += float64(n)
-			if progressCallback != nil && totalSize > 0 {
-				progressCallback(downloaded * 100.0 / totalSize)
-			}
-		}
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf("failed to read from %s: %w", link, err)
-		}
+	d.network.applyBypasserSession(req)
+
+	if offset > 0 && meta != nil {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", meta.ifRange())
 	}
 
-	// Validate that we downloaded enough data
-	// If we received less than 90% of the expected size, check if it's an error page
-	if totalSize > 0 && downloaded < totalSize*expectedSizeRatio {
-		contentType := resp.Header.Get("Content-Type")
-		if strings.HasPrefix(contentType, "text/html") {
-			return nil, fmt.Errorf("failed to download content for %s. Found HTML content instead", link)
+	// Execute request
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() == context.Canceled {
+			return nil, fmt.Errorf("download cancelled")
 		}
+		return nil, fmt.Errorf("failed to download: %w", err)
 	}
-
-	return buffer, nil
+	return resp, nil
 }
 
 // parseSizeStringInt64 parses size string like "5.2 MB" to bytes as int64
@@ -394,10 +515,73 @@ func GetAbsoluteURL(baseURL, relURL string) (string, error) {
 	return base.ResolveReference(rel).String(), nil
 }
 
-// createHTTPClient creates an HTTP client with proxy configuration
-func createHTTPClient(cfg *config.Config) *http.Client {
+// ProbeMirrors checks every configured Anna's Archive mirror and activates
+// the first one that answers with 200. Returns the active mirror.
+func (n *Network) ProbeMirrors(ctx context.Context, logger *zap.Logger) string {
+	client := n.newHTTPClient()
+
+	for _, mirrorURL := range n.Mirrors.URLs() {
+		err := probeMirror(ctx, client, mirrorURL)
+		n.Mirrors.SetProbeResult(mirrorURL, err)
+		if err != nil {
+			logger.Warn("Mirror is not reachable", zap.String("mirror", mirrorURL), zap.Error(err))
+			continue
+		}
+		logger.Debug("Mirror is reachable", zap.String("mirror", mirrorURL))
+	}
+
+	active := n.Mirrors.SelectFirstHealthy()
+	logger.Info("Using Anna's Archive mirror", zap.String("mirror", active))
+	return active
+}
+
+// probeMirror sends a GET request to the mirror's front page
+func probeMirror(ctx context.Context, client *http.Client, mirrorURL string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", mirrorURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.3")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// applyBypasserSession reuses the cookies and user agent obtained by the
+// external bypasser so requests to solved sites pass the Cloudflare checks
+func (n *Network) applyBypasserSession(req *http.Request) {
+	if n.config.UsingExternalBypasser {
+		n.Bypasser.ApplyTo(req)
+	}
+}
+
+// newHTTPClient creates an HTTP client with proxy and DNS configuration
+func (n *Network) newHTTPClient() *http.Client {
+	return &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: n.newTransport(),
+	}
+}
+
+// newTransport creates an HTTP transport that uses the configured proxies,
+// resolves host names with the custom DNS or DNS over HTTPS settings and
+// keeps requests to each host within the politeness limits
+func (n *Network) newTransport() *hostlimit.Transport {
+	cfg := n.config
 	transport := &http.Transport{}
 
+	if n.Resolver != nil {
+		transport.DialContext = n.Resolver.DialContext
+	}
+
 	// Configure proxy
 	if cfg.HTTPProxy != "" || cfg.HTTPSProxy != "" {
 		transport.Proxy = func(req *http.Request) (*url.URL, error) {
@@ -411,59 +595,103 @@ func createHTTPClient(cfg *config.Config) *http.Client {
 		}
 	}
 
-	return &http.Client{
-		Timeout:   30 * time.Second,
-		Transport: transport,
-	}
+	return &hostlimit.Transport{Base: transport, Limiter: n.Hosts}
 }
 
-// DownloadBook downloads a book using the provided book info (method on Downloader)
-func (d *Downloader) DownloadBook(ctx context.Context, book *models.BookInfo, progressCallback ProgressCallback) (string, error) {
+// DownloadResult describes a completed book download
+type DownloadResult struct {
+	// Path is the location of the book in the ingest directory
+	Path string
+	// MD5 is the hex encoded MD5 of the downloaded file. It matches the book
+	// ID of Anna's Archive books, so it is kept when the book is converted.
+	MD5 string
+	// Conversion is set when the book was converted to another format. It
+	// holds the MD5 of the ingested file.
+	Conversion *models.Conversion
+}
+
+// DownloadBook downloads a book using the provided book info (method on Downloader).
+// Each source link is resolved to a direct file URL and streamed to disk until
+// one succeeds, then the file is moved to the ingest directory. When the book ID
+// is an MD5, as it is for Anna's Archive, the file must match it.
+func (d *Downloader) DownloadBook(ctx context.Context, book *models.BookInfo, progressCallback ProgressCallback, waitCallback WaitCallback) (*DownloadResult, error) {
 	if len(book.DownloadURLs) == 0 {
-		return "", fmt.Errorf("no download URLs available for book: %s", book.Title)
+		return nil, fmt.Errorf("%w for book: %s", ErrNoSources, book.Title)
 	}
 
 	// Add donator key URL if configured
-	urls := make([]string, 0, len(book.DownloadURLs)+1)
+	links := make([]string, 0, len(book.DownloadURLs)+1)
 	if d.config.AADonatorKey != "" {
 		fastURL := fmt.Sprintf("%s/dyn/api/fast_download.json?md5=%s&key=%s",
-			d.config.AABaseURL, book.ID, d.config.AADonatorKey)
-		urls = append(urls, fastURL)
-	}
-	urls = append(urls, book.DownloadURLs...)
-
-	// Determine output filename
-	filename := book.Title
-	if d.config.UseBookTitle && book.Title != "" {
-		filename = book.Title
-	} else {
-		filename = book.ID
+			d.network.BaseURL(), book.ID, d.config.AADonatorKey)
+		links = append(links, fastURL)
 	}
+	links = append(links, book.DownloadURLs...)
 
-	// Add format extension if available
+	// Download to a file named after the book ID, the final name is picked
+	// once the format is known for sure
+	filename := sanitizeFilename(book.ID)
 	if book.Format != nil && *book.Format != "" {
 		filename = fmt.Sprintf("%s.%s", filename, *book.Format)
 	}
+	outputPath := filepath.Join(d.config.TmpDir, filename)
 
-	// Sanitize filename
-	filename = sanitizeFilename(filename)
+	size := ""
+	if book.Size != nil {
+		size = *book.Size
+	}
 
-	// Create output path
-	outputPath := filepath.Join(d.config.TmpDir, filename)
+	format := ""
+	if book.Format != nil {
+		format = *book.Format
+	}
 
-	// Try each URL until one succeeds
-	var lastErr error
-	for _, downloadURL := range urls {
-		d.logger.Info("Attempting download", zap.String("url", downloadURL))
+	// Try each link until one succeeds
+	var lastErr, transientErr error
+	for _, link := range links {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("download cancelled")
+		}
 
-		size := ""
-		if book.Size != nil {
-			size = *book.Size
+		d.logger.Info("Resolving download link", zap.String("link", link))
+		downloadURL, err := d.network.ResolveDownloadURL(ctx, link, waitCallback)
+		if err != nil {
+			lastErr = err
+			if IsTransient(err) {
+				transientErr = err
+			}
+			d.logger.Warn("Failed to resolve download link, trying next link",
+				zap.String("link", link),
+				zap.Error(err))
+			continue
 		}
 
-		err := d.DownloadURL(ctx, downloadURL, outputPath, size, progressCallback)
+		d.logger.Info("Attempting download", zap.String("url", downloadURL))
+		checksum, err := d.downloadFile(ctx, downloadURL, outputPath, size, progressCallback)
+		if err == nil {
+			// Mirrors answer with captcha and error pages, make sure we got a book
+			err = ValidateFile(outputPath, format)
+		}
+		if err == nil {
+			err = verifyChecksum(book.ID, checksum)
+		}
+		if err != nil {
+			os.Remove(outputPath)
+		}
 		if err == nil {
 			// Download successful
+			// Convert to the configured format, keeping the original on failure
+			var conversion *models.Conversion
+			if d.converter != nil {
+				if target, ok := d.converter.Target(format); ok {
+					outputPath, conversion = d.convert(ctx, outputPath, format, target)
+					if ctx.Err() != nil {
+						os.Remove(outputPath)
+						return nil, fmt.Errorf("download cancelled")
+					}
+				}
+			}
+
 			// Execute custom script if configured
 			if d.config.CustomScript != "" {
 				d.logger.Info("Executing custom script", zap.String("script", d.config.CustomScript))
@@ -474,25 +702,36 @@ func (d *Downloader) DownloadBook(ctx context.Context, book *models.BookInfo, pr
 				}
 			}
 
-			// Move to ingest directory
-			finalPath := filepath.Join(d.config.IngestDir, filename)
-			if err := os.Rename(outputPath, finalPath); err != nil {
-				// Try copy if rename fails
-				if copyErr := copyFile(outputPath, finalPath); copyErr != nil {
-					return "", fmt.Errorf("failed to move file to ingest dir: %w", err)
-				}
-				os.Remove(outputPath)
+			// Move to ingest directory under the name rendered from the template
+			ext := ""
+			if book.Format != nil && *book.Format != "" {
+				ext = strings.TrimPrefix(filepath.Ext(outputPath), ".")
+			}
+			dir, base := d.filenames.Render(book, ext)
+			finalPath, err := moveToIngest(outputPath, filepath.Join(d.config.IngestDir, dir), base, ext)
+			if err != nil {
+				return nil, err
 			}
 
-			d.logger.Info("Book download complete", zap.String("path", finalPath))
-			return finalPath, nil
+			d.logger.Info("Book download complete",
+				zap.String("path", finalPath),
+				zap.String("md5", checksum))
+			return &DownloadResult{Path: finalPath, MD5: checksum, Conversion: conversion}, nil
 		}
 
 		lastErr = err
-		d.logger.Warn("Download failed, trying next URL", zap.Error(err))
+		if IsTransient(err) {
+			transientErr = err
+		}
+		d.logger.Warn("Download failed, trying next link", zap.Error(err))
 	}
 
-	return "", fmt.Errorf("all download attempts failed, last error: %w", lastErr)
+	err := fmt.Errorf("all download attempts failed, last error: %w", lastErr)
+	if transientErr != nil && transientErr != lastErr {
+		// Keep the transient failure of an earlier link visible to the retry policy
+		err = fmt.Errorf("%w (earlier transient error: %w)", err, transientErr)
+	}
+	return nil, err
 }
 
 // copyFile copies a file from src to dst
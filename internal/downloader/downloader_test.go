@@ -1,16 +1,20 @@
 package downloader
 
 import (
+"bytes"
 "context"
+"errors"
 "fmt"
 "net/http"
 "net/http/httptest"
 "os"
 "path/filepath"
+"sync/atomic"
 "testing"
 "time"
 
 "github.com/veverkap/calibre-web-automated-book-downloader/internal/config"
+"github.com/veverkap/calibre-web-automated-book-downloader/internal/mirror"
 "github.com/veverkap/calibre-web-automated-book-downloader/internal/models"
 "go.uber.org/zap"
 )
@@ -188,7 +192,7 @@ IngestDir: tmpDir,
 }
 
 logger, _ := zap.NewDevelopment()
-downloader := NewDownloader(cfg, logger)
+downloader := NewDownloader(cfg, logger, NewNetwork(cfg))
 
 // Test download
 outputPath := filepath.Join(tmpDir, "test.txt")
@@ -247,7 +251,7 @@ IngestDir: tmpDir,
 }
 
 logger, _ := zap.NewDevelopment()
-downloader := NewDownloader(cfg, logger)
+downloader := NewDownloader(cfg, logger, NewNetwork(cfg))
 
 // Create context that will be cancelled
 ctx, cancel := context.WithCancel(context.Background())
@@ -272,8 +276,10 @@ t.Error("Temp file was not cleaned up")
 }
 
 func TestDownloadBook(t *testing.T) {
+// EPUB files are ZIP archives
+content := []byte("PK\x03\x04test book content")
+
 // Create a test HTTP server
-content := []byte("test book content")
 server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 w.Header().Set("Content-Length", fmt.Sprintf("%d", len(content)))
 w.WriteHeader(http.StatusOK)
@@ -295,7 +301,7 @@ t.Fatal(err)
 
 // Create test config
 format := "epub"
-size := "17 B"
+size := "21 B"
 cfg := &config.Config{
 TmpDir:       tmpDir,
 IngestDir:    ingestDir,
@@ -303,7 +309,7 @@ UseBookTitle: true,
 }
 
 logger, _ := zap.NewDevelopment()
-downloader := NewDownloader(cfg, logger)
+downloader := NewDownloader(cfg, logger, NewNetwork(cfg))
 
 // Create book info
 book := &models.BookInfo{
@@ -315,10 +321,11 @@ DownloadURLs: []string{server.URL},
 }
 
 ctx := context.Background()
-downloadedPath, err := downloader.DownloadBook(ctx, book, nil)
+result, err := downloader.DownloadBook(ctx, book, nil, nil)
 if err != nil {
 t.Fatalf("DownloadBook failed: %v", err)
 }
+downloadedPath := result.Path
 
 // Verify file is in ingest directory
 if !filepath.IsAbs(downloadedPath) {
@@ -341,7 +348,7 @@ t.Errorf("Downloaded content = %q, want %q", downloadedContent, content)
 }
 
 func TestDownloadBookWithMultipleURLs(t *testing.T) {
-content := []byte("test book content")
+content := []byte("PK\x03\x04test book content")
 
 // Create a server that fails
 failServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -377,7 +384,7 @@ IngestDir: ingestDir,
 }
 
 logger, _ := zap.NewDevelopment()
-downloader := NewDownloader(cfg, logger)
+downloader := NewDownloader(cfg, logger, NewNetwork(cfg))
 
 // Create book info with failing URL first, then succeeding URL
 book := &models.BookInfo{
@@ -391,10 +398,11 @@ successServer.URL,
 }
 
 ctx := context.Background()
-downloadedPath, err := downloader.DownloadBook(ctx, book, nil)
+result, err := downloader.DownloadBook(ctx, book, nil, nil)
 if err != nil {
 t.Fatalf("DownloadBook failed: %v", err)
 }
+downloadedPath := result.Path
 
 // Verify file exists
 if _, err := os.Stat(downloadedPath); err != nil {
@@ -407,7 +415,221 @@ func TestHTMLGetPage_InvalidURL(t *testing.T) {
 t.Skip("Requires test HTTP server")
 }
 
-func TestDownloadURLToBuffer_InvalidURL(t *testing.T) {
-// This test would require mocking or a test server
-t.Skip("Requires test HTTP server")
+func TestHTMLGetPageUsesExternalBypasser(t *testing.T) {
+	bypasserServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status": "ok", "message": "", "solution": {"url": "https://example.com/md5/abc", "status": 200, "response": "<html>bypassed</html>"}}`)
+	}))
+	defer bypasserServer.Close()
+
+	cfg := &config.Config{
+		UseCFBypass:           true,
+		UsingExternalBypasser: true,
+		ExtBypasserURL:        bypasserServer.URL,
+		ExtBypasserPath:       "/v1",
+		ExtBypasserTimeout:    1000,
+	}
+
+	html, err := NewNetwork(cfg).HTMLGetPage(context.Background(), "https://example.com/md5/abc", true)
+	if err != nil {
+		t.Fatalf("HTMLGetPage failed: %v", err)
+	}
+	if html != "<html>bypassed</html>" {
+		t.Errorf("HTMLGetPage() = %q, want bypassed page", html)
+	}
+}
+
+func TestHTMLGetPageBypassesForbiddenWithoutRetries(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	bypasserServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"status": "ok", "message": "", "solution": {"url": "%s/md5/abc", "status": 200, "response": "<html>bypassed</html>"}}`, server.URL)
+	}))
+	defer bypasserServer.Close()
+
+	cfg := &config.Config{
+		MaxRetry:              0,
+		UseCFBypass:           true,
+		UsingExternalBypasser: true,
+		ExtBypasserURL:        bypasserServer.URL,
+		ExtBypasserPath:       "/v1",
+		ExtBypasserTimeout:    1000,
+	}
+
+	html, err := NewNetwork(cfg).HTMLGetPage(context.Background(), server.URL+"/md5/abc", false)
+	if err != nil {
+		t.Fatalf("HTMLGetPage failed: %v", err)
+	}
+	if html != "<html>bypassed</html>" {
+		t.Errorf("HTMLGetPage() = %q, want bypassed page", html)
+	}
+	if got := atomic.LoadInt32(&hits); got != 1 {
+		t.Errorf("Expected one direct request, got %d", got)
+	}
+}
+
+func TestHTMLGetPageFailsOverToNextMirror(t *testing.T) {
+	var primaryHits int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&primaryHits, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer primary.Close()
+
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/md5/abc" {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+		fmt.Fprint(w, "<html>secondary</html>")
+	}))
+	defer secondary.Close()
+
+	cfg := &config.Config{
+		AABaseURL:        primary.URL,
+		AAAdditionalURLs: secondary.URL,
+		MaxRetry:         mirror.FailureThreshold,
+	}
+
+	network := NewNetwork(cfg)
+	html, err := network.HTMLGetPage(context.Background(), primary.URL+"/md5/abc", false)
+	if err != nil {
+		t.Fatalf("HTMLGetPage failed: %v", err)
+	}
+	if html != "<html>secondary</html>" {
+		t.Errorf("HTMLGetPage() = %q, want secondary page", html)
+	}
+	if got := atomic.LoadInt32(&primaryHits); got != mirror.FailureThreshold {
+		t.Errorf("Expected %d requests to the failing mirror, got %d", mirror.FailureThreshold, got)
+	}
+	if active := network.BaseURL(); active != secondary.URL {
+		t.Errorf("Expected active mirror %s, got %s", secondary.URL, active)
+	}
+}
+
+func TestHTMLGetPageTypedErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		wantErr  error
+		wantHits int32
+	}{
+		{"not found", http.StatusNotFound, ErrNotFound, 1},
+		{"forbidden without bypasser", http.StatusForbidden, ErrForbidden, 1},
+		{"rate limited", http.StatusTooManyRequests, ErrRateLimited, 3},
+		{"server error", http.StatusServiceUnavailable, ErrUpstream5xx, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&hits, 1)
+				// Keep the shared host limiter from backing off
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			cfg := &config.Config{MaxRetry: 2}
+
+			_, err := NewNetwork(cfg).HTMLGetPage(context.Background(), server.URL+"/md5/abc", false)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("HTMLGetPage() error = %v, want %v", err, tt.wantErr)
+			}
+
+			var fetchErr *FetchError
+			if !errors.As(err, &fetchErr) {
+				t.Fatalf("Expected a FetchError, got %T", err)
+			}
+			if got := atomic.LoadInt32(&hits); got != tt.wantHits || len(fetchErr.Attempts) != int(tt.wantHits) {
+				t.Errorf("Expected %d attempts, got %d requests and %d reported attempts", tt.wantHits, got, len(fetchErr.Attempts))
+			}
+		})
+	}
+}
+
+func TestHTMLGetPageStopsBackoffOnCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{MaxRetry: 3, DefaultSleep: 60}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := NewNetwork(cfg).HTMLGetPage(ctx, server.URL+"/md5/abc", false)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("HTMLGetPage() error = %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("HTMLGetPage() took %v, expected it to stop once the context was done", elapsed)
+	}
+
+	var fetchErr *FetchError
+	if !errors.As(err, &fetchErr) || len(fetchErr.Attempts) != 2 {
+		t.Errorf("Expected the failed request and the interrupted backoff to be reported, got %v", err)
+	}
+}
+
+func TestProbeMirrorsSelectsFirstHealthy(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer up.Close()
+
+	cfg := &config.Config{
+		AABaseURL:        down.URL,
+		AAAdditionalURLs: up.URL,
+	}
+	logger, _ := zap.NewDevelopment()
+
+	network := NewNetwork(cfg)
+	if active := network.ProbeMirrors(context.Background(), logger); active != up.URL {
+		t.Errorf("Expected %s to be selected, got %s", up.URL, active)
+	}
+	if active := network.BaseURL(); active != up.URL {
+		t.Errorf("Expected active mirror %s, got %s", up.URL, active)
+	}
+}
+
+func TestDownloadURLRespectsRateLimit(t *testing.T) {
+	content := bytes.Repeat([]byte("x"), 96*1024)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(content)))
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		TmpDir:                       tmpDir,
+		IngestDir:                    tmpDir,
+		DownloadRateLimitPerDownload: "64KB",
+	}
+
+	logger, _ := zap.NewDevelopment()
+	downloader := NewDownloader(cfg, logger, NewNetwork(cfg))
+
+	// One second of burst, then 32KB at 64KB/s
+	start := time.Now()
+	if err := downloader.DownloadURL(context.Background(), server.URL, filepath.Join(tmpDir, "test.txt"), "", nil); err != nil {
+		t.Fatalf("DownloadURL failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("Expected the download to be throttled, took %v", elapsed)
+	}
 }
@@ -0,0 +1,249 @@
+package downloader
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/config"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/models"
+)
+
+// MaxNameLength caps every file and directory name rendered from a template,
+// in bytes. Most filesystems allow 255, the rest is left for the extension
+// and a collision suffix.
+const MaxNameLength = 200
+
+// invalidTemplateChars may not appear in the literal text of a template
+const invalidTemplateChars = `<>:"\|?*`
+
+// filenamePunctuation lists the punctuation kept in file names besides letters,
+// digits and spaces
+const filenamePunctuation = "._-,'()[]&!+"
+
+// ingestMu serialises picking a free name in the ingest directory and moving
+// the file there, so concurrent downloads never take the same name
+var ingestMu sync.Mutex
+
+// FilenameTemplate renders the path of a book in the ingest directory from
+// its metadata, e.g. "{author}/{series}/{title} ({year})". Placeholders are
+// {id}, {title}, {author}, {publisher}, {year}, {language}, {format} and
+// {ext}; any other name is looked up in the book's Info metadata. "/"
+// separates directories, which are dropped when they render empty.
+type FilenameTemplate struct {
+	segments []string
+}
+
+// ParseFilenameTemplate parses and validates a template. A trailing ".{ext}"
+// is optional, the extension is always added.
+func ParseFilenameTemplate(s string) (*FilenameTemplate, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimSuffix(s, ".{ext}")
+	if s == "" {
+		return nil, fmt.Errorf("empty filename template")
+	}
+	if strings.HasPrefix(s, "/") {
+		return nil, fmt.Errorf("invalid filename template %q: must be relative", s)
+	}
+
+	segments := strings.Split(s, "/")
+	for _, segment := range segments {
+		literal, err := templateLiteral(segment)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filename template %q: %w", s, err)
+		}
+		if strings.TrimSpace(literal) == "." || strings.TrimSpace(literal) == ".." {
+			return nil, fmt.Errorf("invalid filename template %q: %q is not a valid name", s, segment)
+		}
+		if strings.ContainsAny(literal, invalidTemplateChars) {
+			return nil, fmt.Errorf("invalid filename template %q: names may not contain any of %s", s, invalidTemplateChars)
+		}
+	}
+	return &FilenameTemplate{segments: segments}, nil
+}
+
+// NewFilenameTemplate creates the template from the configuration. Without
+// FILENAME_TEMPLATE books are named by title or ID depending on USE_BOOK_TITLE.
+func NewFilenameTemplate(cfg *config.Config) (*FilenameTemplate, error) {
+	if cfg.FilenameTemplate != "" {
+		return ParseFilenameTemplate(cfg.FilenameTemplate)
+	}
+	if cfg.UseBookTitle {
+		return ParseFilenameTemplate("{title}")
+	}
+	return ParseFilenameTemplate("{id}")
+}
+
+// templateLiteral returns the text of a template segment without its
+// placeholders, and checks that braces are balanced
+func templateLiteral(segment string) (string, error) {
+	var literal strings.Builder
+	for {
+		start := strings.IndexAny(segment, "{}")
+		if start < 0 {
+			literal.WriteString(segment)
+			return literal.String(), nil
+		}
+		if segment[start] == '}' {
+			return "", fmt.Errorf("unexpected '}'")
+		}
+		end := strings.IndexAny(segment[start+1:], "{}")
+		if end < 0 || segment[start+1+end] != '}' {
+			return "", fmt.Errorf("unclosed placeholder")
+		}
+		if strings.TrimSpace(segment[start+1:start+1+end]) == "" {
+			return "", fmt.Errorf("empty placeholder")
+		}
+		literal.WriteString(segment[:start])
+		segment = segment[start+end+2:]
+	}
+}
+
+// Render returns the directory, relative to the ingest directory, and the
+// base name without extension for a book. The name falls back to the book ID
+// when the template renders empty.
+func (t *FilenameTemplate) Render(book *models.BookInfo, ext string) (string, string) {
+	last := len(t.segments) - 1
+
+	var dirs []string
+	for _, segment := range t.segments[:last] {
+		if name := renderSegment(segment, book, ext); name != "" {
+			dirs = append(dirs, name)
+		}
+	}
+
+	base := renderSegment(t.segments[last], book, ext)
+	if base == "" {
+		base = truncateName(sanitizeFilename(book.ID), MaxNameLength)
+	}
+	return filepath.Join(dirs...), base
+}
+
+// renderSegment fills in the placeholders of a template segment and tidies up
+// what is left around empty values
+func renderSegment(segment string, book *models.BookInfo, ext string) string {
+	var rendered strings.Builder
+	for {
+		start := strings.IndexByte(segment, '{')
+		if start < 0 {
+			rendered.WriteString(segment)
+			break
+		}
+		end := strings.IndexByte(segment[start:], '}') + start
+		rendered.WriteString(segment[:start])
+		rendered.WriteString(sanitizeFilename(placeholderValue(book, segment[start+1:end], ext)))
+		segment = segment[end+1:]
+	}
+
+	name := rendered.String()
+	// Drop the brackets around missing values, e.g. "Title ()"
+	for _, empty := range []string{"()", "[]"} {
+		name = strings.ReplaceAll(name, empty, "")
+	}
+	name = strings.Join(strings.Fields(name), " ")
+	name = strings.Trim(name, " .-_,")
+	return truncateName(name, MaxNameLength)
+}
+
+// placeholderValue returns the value of a placeholder for a book
+func placeholderValue(book *models.BookInfo, name, ext string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch name {
+	case "id":
+		return book.ID
+	case "title":
+		return book.Title
+	case "author":
+		return stringValue(book.Author)
+	case "publisher":
+		return stringValue(book.Publisher)
+	case "year":
+		return stringValue(book.Year)
+	case "language":
+		return stringValue(book.Language)
+	case "format":
+		return stringValue(book.Format)
+	case "ext":
+		return ext
+	}
+
+	for key, values := range book.Info {
+		if strings.EqualFold(key, name) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// stringValue dereferences an optional string
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// sanitizeFilename removes characters that are not safe in file names. Letters
+// and digits of any script are kept, as are spaces and common punctuation.
+func sanitizeFilename(filename string) string {
+	var sanitized strings.Builder
+	for _, r := range filename {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r), unicode.IsMark(r):
+			sanitized.WriteRune(r)
+		case r == ' ', strings.ContainsRune(filenamePunctuation, r):
+			sanitized.WriteRune(r)
+		case unicode.IsSpace(r):
+			sanitized.WriteRune(' ')
+		}
+	}
+	return strings.TrimSpace(sanitized.String())
+}
+
+// truncateName shortens a name to at most max bytes without splitting a character
+func truncateName(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+	name = name[:max]
+	for !utf8.ValidString(name) {
+		name = name[:len(name)-1]
+	}
+	return strings.TrimRight(name, " .-_,")
+}
+
+// moveToIngest moves a downloaded file to dir/base.ext. When that name is
+// taken, " (2)", " (3)" and so on are added to the base name.
+func moveToIngest(src, dir, base, ext string) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create ingest directory: %w", err)
+	}
+	if ext != "" {
+		ext = "." + ext
+	}
+
+	ingestMu.Lock()
+	defer ingestMu.Unlock()
+
+	src = filepath.Clean(src)
+	finalPath := filepath.Join(dir, base+ext)
+	for n := 2; finalPath != src; n++ {
+		if _, err := os.Lstat(finalPath); os.IsNotExist(err) {
+			break
+		}
+		finalPath = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", base, n, ext))
+	}
+
+	if err := os.Rename(src, finalPath); err != nil {
+		// Try copy if rename fails
+		if copyErr := copyFile(src, finalPath); copyErr != nil {
+			return "", fmt.Errorf("failed to move file to ingest dir: %w", err)
+		}
+		os.Remove(src)
+	}
+	return finalPath, nil
+}
@@ -0,0 +1,174 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/config"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/models"
+	"go.uber.org/zap"
+)
+
+func TestSanitizeFilenameUnicode(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"Война и мир", "Война и мир"},
+		{"三体", "三体"},
+		{"Ελληνικά: Τίτλος?", "Ελληνικά Τίτλος"},
+		{"Ender's Game (Ender, #1)", "Ender's Game (Ender, 1)"},
+		{"Tab\tand\nnewline", "Tab and newline"},
+	}
+
+	for _, tt := range tests {
+		if result := sanitizeFilename(tt.input); result != tt.expected {
+			t.Errorf("sanitizeFilename(%q) = %q, want %q", tt.input, result, tt.expected)
+		}
+	}
+}
+
+func TestParseFilenameTemplateRejectsInvalid(t *testing.T) {
+	for _, template := range []string{"", "/books/{title}", "{title", "title}", "{}", "a:b/{title}", "../{title}", "{author}/./{title}"} {
+		if _, err := ParseFilenameTemplate(template); err == nil {
+			t.Errorf("Expected an error for %q", template)
+		}
+	}
+}
+
+func TestFilenameTemplateRender(t *testing.T) {
+	tmpl, err := ParseFilenameTemplate("{author}/{series}/{title} ({year}).{ext}")
+	if err != nil {
+		t.Fatalf("ParseFilenameTemplate failed: %v", err)
+	}
+
+	author := "Isaac Asimov"
+	year := "1951"
+	tests := []struct {
+		name     string
+		book     *models.BookInfo
+		wantDir  string
+		wantBase string
+	}{
+		{
+			name:     "all fields",
+			book:     &models.BookInfo{ID: "abc", Title: "Foundation", Author: &author, Year: &year, Info: map[string][]string{"Series": {"Foundation"}}},
+			wantDir:  filepath.Join("Isaac Asimov", "Foundation"),
+			wantBase: "Foundation (1951)",
+		},
+		{
+			name:     "missing values",
+			book:     &models.BookInfo{ID: "abc", Title: "Foundation", Author: &author},
+			wantDir:  "Isaac Asimov",
+			wantBase: "Foundation",
+		},
+		{
+			name:     "empty name falls back to ID",
+			book:     &models.BookInfo{ID: "abc"},
+			wantDir:  "",
+			wantBase: "abc",
+		},
+		{
+			name:     "no path traversal",
+			book:     &models.BookInfo{ID: "abc", Title: "..", Author: stringPtr("../../etc")},
+			wantDir:  "etc",
+			wantBase: "abc",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, base := tmpl.Render(tt.book, "epub")
+			if dir != tt.wantDir || base != tt.wantBase {
+				t.Errorf("Render() = %q, %q, want %q, %q", dir, base, tt.wantDir, tt.wantBase)
+			}
+		})
+	}
+}
+
+func TestFilenameTemplateRenderLimitsLength(t *testing.T) {
+	tmpl, err := ParseFilenameTemplate("{title}")
+	if err != nil {
+		t.Fatalf("ParseFilenameTemplate failed: %v", err)
+	}
+
+	_, base := tmpl.Render(&models.BookInfo{ID: "abc", Title: strings.Repeat("я", 150)}, "epub")
+	if len(base) > MaxNameLength || !utf8.ValidString(base) {
+		t.Errorf("Render() = %q (%d bytes), want a valid name of at most %d bytes", base, len(base), MaxNameLength)
+	}
+}
+
+func TestMoveToIngestAddsSuffixOnCollision(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "Book.epub"), []byte("existing"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"Book (2).epub", "Book (3).epub"} {
+		src := filepath.Join(t.TempDir(), "download.epub")
+		if err := os.WriteFile(src, []byte("new"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		path, err := moveToIngest(src, dir, "Book", "epub")
+		if err != nil {
+			t.Fatalf("moveToIngest failed: %v", err)
+		}
+		if path != filepath.Join(dir, want) {
+			t.Errorf("moveToIngest() = %s, want %s", path, filepath.Join(dir, want))
+		}
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(dir, "Book.epub")); string(data) != "existing" {
+		t.Error("Existing file was overwritten")
+	}
+}
+
+func TestDownloadBookUsesFilenameTemplate(t *testing.T) {
+	content := []byte("PK\x03\x04test book content")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	tmpDir := t.TempDir()
+	ingestDir := filepath.Join(tmpDir, "ingest")
+	cfg := &config.Config{
+		TmpDir:           tmpDir,
+		IngestDir:        ingestDir,
+		FilenameTemplate: "{author}/{title} ({year})",
+	}
+
+	format := "epub"
+	book := &models.BookInfo{
+		ID:           "test123",
+		Title:        "Мастер и Маргарита",
+		Author:       stringPtr("Михаил Булгаков"),
+		Format:       &format,
+		DownloadURLs: []string{server.URL},
+	}
+
+	result, err := NewDownloader(cfg, zap.NewNop(), NewNetwork(cfg)).DownloadBook(context.Background(), book, nil, nil)
+	if err != nil {
+		t.Fatalf("DownloadBook failed: %v", err)
+	}
+
+	want := filepath.Join(ingestDir, "Михаил Булгаков", "Мастер и Маргарита.epub")
+	if result.Path != want {
+		t.Errorf("DownloadBook() path = %s, want %s", result.Path, want)
+	}
+	if _, err := os.Stat(want); err != nil {
+		t.Errorf("Ingested file is missing: %v", err)
+	}
+}
+
+// stringPtr returns a pointer to s
+func stringPtr(s string) *string {
+	return &s
+}
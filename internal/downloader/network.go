@@ -0,0 +1,45 @@
+package downloader
+
+import (
+	"time"
+
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/bypasser"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/config"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/hostlimit"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/mirror"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/resolver"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/throttle"
+)
+
+// Network holds the state shared by every outgoing request: the Anna's
+// Archive mirrors, the Cloudflare bypasser session, DNS resolution, the
+// per-host limits and the bandwidth limits. Create one per process and pass
+// it to everything making requests.
+type Network struct {
+	config *config.Config
+
+	Mirrors   *mirror.Manager
+	Bypasser  *bypasser.Client
+	Resolver  *resolver.Resolver // nil without custom DNS
+	Hosts     *hostlimit.Limiter
+	Bandwidth *throttle.Limiter
+}
+
+// NewNetwork creates the shared network state for the configuration.
+// Invalid bandwidth settings leave downloads unlimited.
+func NewNetwork(cfg *config.Config) *Network {
+	bandwidth, _ := throttle.SettingsFromConfig(cfg)
+	return &Network{
+		config:    cfg,
+		Mirrors:   mirror.NewManager(mirror.Candidates(cfg)),
+		Bypasser:  bypasser.NewClient(cfg),
+		Resolver:  resolver.New(cfg),
+		Hosts:     hostlimit.New(cfg.HostMaxConcurrent, time.Duration(cfg.HostMinIntervalMS)*time.Millisecond),
+		Bandwidth: throttle.New(bandwidth),
+	}
+}
+
+// BaseURL returns the active Anna's Archive mirror
+func (n *Network) BaseURL() string {
+	return n.Mirrors.Active()
+}
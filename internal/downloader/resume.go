@@ -0,0 +1,176 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ResumeMetaExt is the extension of the sidecar file holding the validators
+// of a partial download
+const ResumeMetaExt = ".meta"
+
+// resumeMeta records what is needed to safely resume a partial download
+type resumeMeta struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	TotalSize    int64  `json:"total_size,omitempty"`
+}
+
+// newResumeMeta builds resume metadata from a full (200) response.
+// Returns nil if the server does not support resuming this resource.
+func newResumeMeta(url string, resp *http.Response) *resumeMeta {
+	if !strings.EqualFold(strings.TrimSpace(resp.Header.Get("Accept-Ranges")), "bytes") {
+		return nil
+	}
+
+	meta := &resumeMeta{
+		URL:          url,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		TotalSize:    resp.ContentLength,
+	}
+	if meta.ifRange() == "" {
+		// Without a validator we cannot tell whether the file changed
+		return nil
+	}
+	return meta
+}
+
+// ifRange returns the validator to send in an If-Range header.
+// Weak ETags are not allowed in If-Range, so Last-Modified is used instead.
+func (m *resumeMeta) ifRange() string {
+	if m.ETag != "" && !strings.HasPrefix(m.ETag, "W/") {
+		return m.ETag
+	}
+	return m.LastModified
+}
+
+// loadResumeMeta reads the resume metadata stored next to a partial download
+func loadResumeMeta(tempPath string) (*resumeMeta, error) {
+	data, err := os.ReadFile(tempPath + ResumeMetaExt)
+	if err != nil {
+		return nil, err
+	}
+
+	var meta resumeMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("invalid resume metadata: %w", err)
+	}
+	return &meta, nil
+}
+
+// saveResumeMeta stores resume metadata next to a partial download
+func saveResumeMeta(tempPath string, meta *resumeMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(tempPath+ResumeMetaExt, data, 0644)
+}
+
+// removePartial removes a partial download and its resume metadata
+func removePartial(tempPath string) {
+	os.Remove(tempPath)
+	os.Remove(tempPath + ResumeMetaExt)
+}
+
+// partialOffset returns the number of bytes of an existing partial download
+// that can be resumed from downloadURL, along with its metadata. Returns 0 if
+// the partial download cannot be resumed. Partial downloads from another host
+// are removed, since its validators mean nothing to other servers.
+func partialOffset(tempPath string, downloadURL string) (int64, *resumeMeta) {
+	info, err := os.Stat(tempPath)
+	if err != nil || info.Size() == 0 {
+		return 0, nil
+	}
+
+	meta, err := loadResumeMeta(tempPath)
+	if err != nil || meta.ifRange() == "" {
+		return 0, nil
+	}
+	if !sameHost(meta.URL, downloadURL) {
+		removePartial(tempPath)
+		return 0, nil
+	}
+	if meta.TotalSize > 0 && info.Size() >= meta.TotalSize {
+		return 0, nil
+	}
+
+	return info.Size(), meta
+}
+
+// sameHost reports whether two URLs point to the same server
+func sameHost(a, b string) bool {
+	urlA, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	urlB, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(urlA.Scheme, urlB.Scheme) && strings.EqualFold(urlA.Host, urlB.Host)
+}
+
+// hashPartial feeds the first offset bytes of a partial download to w so a
+// resumed download can be hashed as a whole
+func hashPartial(tempPath string, offset int64, w io.Writer) error {
+	file, err := os.Open(tempPath)
+	if err != nil {
+		return fmt.Errorf("failed to open partial file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := io.CopyN(w, file, offset); err != nil {
+		return fmt.Errorf("failed to hash partial file: %w", err)
+	}
+	return nil
+}
+
+// parseContentRangeStart parses the start offset and complete length from a
+// Content-Range header like "bytes 100-199/200". The length is -1 if unknown.
+func parseContentRangeStart(header string) (start int64, total int64, err error) {
+	header = strings.TrimSpace(header)
+	if !strings.HasPrefix(header, "bytes ") {
+		return 0, 0, fmt.Errorf("invalid Content-Range: %q", header)
+	}
+
+	rangeAndTotal := strings.SplitN(strings.TrimPrefix(header, "bytes "), "/", 2)
+	if len(rangeAndTotal) != 2 {
+		return 0, 0, fmt.Errorf("invalid Content-Range: %q", header)
+	}
+
+	bounds := strings.SplitN(rangeAndTotal[0], "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, fmt.Errorf("invalid Content-Range: %q", header)
+	}
+
+	start, err = strconv.ParseInt(strings.TrimSpace(bounds[0]), 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid Content-Range start: %w", err)
+	}
+
+	total = -1
+	if t := strings.TrimSpace(rangeAndTotal[1]); t != "*" {
+		total, err = strconv.ParseInt(t, 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid Content-Range length: %w", err)
+		}
+	}
+
+	return start, total, nil
+}
+
+// discardUnresumable removes a partial download that cannot be resumed later
+func discardUnresumable(tempPath string) {
+	if _, err := os.Stat(tempPath + ResumeMetaExt); err != nil {
+		os.Remove(tempPath)
+	}
+}
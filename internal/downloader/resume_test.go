@@ -0,0 +1,247 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/config"
+	"go.uber.org/zap"
+)
+
+// newResumeTestServer serves content with the given ETag. The first request
+// is cut off half way through to simulate a dropped connection.
+func newResumeTestServer(content []byte, etag *atomic.Value, requests *int32, ranges chan<- string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(requests, 1)
+		if ranges != nil {
+			ranges <- r.Header.Get("Range")
+		}
+
+		w.Header().Set("ETag", etag.Load().(string))
+		if n == 1 {
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", len(content)))
+			w.WriteHeader(http.StatusOK)
+			w.Write(content[:len(content)/2])
+			return
+		}
+
+		http.ServeContent(w, r, "book.epub", time.Time{}, bytes.NewReader(content))
+	}))
+}
+
+func newResumeTestDownloader(t *testing.T) (*Downloader, string) {
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		TmpDir:    tmpDir,
+		IngestDir: tmpDir,
+	}
+	logger, _ := zap.NewDevelopment()
+	return NewDownloader(cfg, logger, NewNetwork(cfg)), tmpDir
+}
+
+func TestDownloadURLResume(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+
+	var etag atomic.Value
+	etag.Store(`"v1"`)
+	var requests int32
+	ranges := make(chan string, 2)
+	server := newResumeTestServer(content, &etag, &requests, ranges)
+	defer server.Close()
+
+	downloader, tmpDir := newResumeTestDownloader(t)
+	outputPath := filepath.Join(tmpDir, "book.epub")
+	ctx := context.Background()
+
+	if err := downloader.DownloadURL(ctx, server.URL, outputPath, "", nil); err == nil {
+		t.Fatal("Expected first download to fail")
+	}
+
+	info, err := os.Stat(outputPath + TempDownloadExt)
+	if err != nil {
+		t.Fatalf("Expected partial file to be kept: %v", err)
+	}
+	if info.Size() != int64(len(content)/2) {
+		t.Errorf("Expected partial size %d, got %d", len(content)/2, info.Size())
+	}
+
+	if err := downloader.DownloadURL(ctx, server.URL, outputPath, "", nil); err != nil {
+		t.Fatalf("Resumed download failed: %v", err)
+	}
+
+	<-ranges
+	if got, want := <-ranges, fmt.Sprintf("bytes=%d-", len(content)/2); got != want {
+		t.Errorf("Expected Range %q, got %q", want, got)
+	}
+
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read downloaded file: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("Resumed content mismatch: got %d bytes, want %d", len(data), len(content))
+	}
+
+	if _, err := os.Stat(outputPath + TempDownloadExt + ResumeMetaExt); !os.IsNotExist(err) {
+		t.Error("Resume metadata should be removed after completion")
+	}
+}
+
+func TestDownloadURLResumeValidatorChanged(t *testing.T) {
+	content := bytes.Repeat([]byte("abcdefghij"), 10000)
+
+	var etag atomic.Value
+	etag.Store(`"v1"`)
+	var requests int32
+	server := newResumeTestServer(content, &etag, &requests, nil)
+	defer server.Close()
+
+	downloader, tmpDir := newResumeTestDownloader(t)
+	outputPath := filepath.Join(tmpDir, "book.epub")
+	ctx := context.Background()
+
+	if err := downloader.DownloadURL(ctx, server.URL, outputPath, "", nil); err == nil {
+		t.Fatal("Expected first download to fail")
+	}
+
+	// The file changed on the server, so the If-Range check must fail
+	etag.Store(`"v2"`)
+
+	if err := downloader.DownloadURL(ctx, server.URL, outputPath, "", nil); err != nil {
+		t.Fatalf("Restarted download failed: %v", err)
+	}
+
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read downloaded file: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("Restarted content mismatch: got %d bytes, want %d", len(data), len(content))
+	}
+}
+
+func TestDownloadURLFromOtherHostDiscardsPartial(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+
+	var etag atomic.Value
+	etag.Store(`"v1"`)
+	var requests int32
+	server := newResumeTestServer(content, &etag, &requests, nil)
+	defer server.Close()
+
+	// Another mirror serving a different file with the same ETag
+	otherContent := bytes.Repeat([]byte("abcdefghij"), 10000)
+	ranges := make(chan string, 1)
+	otherServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges <- r.Header.Get("Range")
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "book.epub", time.Time{}, bytes.NewReader(otherContent))
+	}))
+	defer otherServer.Close()
+
+	downloader, tmpDir := newResumeTestDownloader(t)
+	outputPath := filepath.Join(tmpDir, "book.epub")
+	ctx := context.Background()
+
+	if err := downloader.DownloadURL(ctx, server.URL, outputPath, "", nil); err == nil {
+		t.Fatal("Expected first download to fail")
+	}
+	if err := downloader.DownloadURL(ctx, otherServer.URL, outputPath, "", nil); err != nil {
+		t.Fatalf("Download from the other host failed: %v", err)
+	}
+
+	if got := <-ranges; got != "" {
+		t.Errorf("Expected the other host to be asked for the whole file, got Range %q", got)
+	}
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read downloaded file: %v", err)
+	}
+	if !bytes.Equal(data, otherContent) {
+		t.Error("Downloaded file mixes the content of both hosts")
+	}
+}
+
+func TestDownloadURLWithoutRangeSupportDiscardsPartial(t *testing.T) {
+	content := bytes.Repeat([]byte("x"), 1000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(content)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(content[:100])
+	}))
+	defer server.Close()
+
+	downloader, tmpDir := newResumeTestDownloader(t)
+	outputPath := filepath.Join(tmpDir, "book.epub")
+
+	if err := downloader.DownloadURL(context.Background(), server.URL, outputPath, "", nil); err == nil {
+		t.Fatal("Expected download to fail")
+	}
+
+	if _, err := os.Stat(outputPath + TempDownloadExt); !os.IsNotExist(err) {
+		t.Error("Partial file without resume support should be removed")
+	}
+}
+
+func TestDownloadFileHashesResumedContent(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	sum := md5.Sum(content)
+
+	var etag atomic.Value
+	etag.Store(`"v1"`)
+	var requests int32
+	server := newResumeTestServer(content, &etag, &requests, nil)
+	defer server.Close()
+
+	downloader, tmpDir := newResumeTestDownloader(t)
+	outputPath := filepath.Join(tmpDir, "book.epub")
+	ctx := context.Background()
+
+	if _, err := downloader.downloadFile(ctx, server.URL, outputPath, "", nil); err == nil {
+		t.Fatal("Expected first download to fail")
+	}
+
+	checksum, err := downloader.downloadFile(ctx, server.URL, outputPath, "", nil)
+	if err != nil {
+		t.Fatalf("Resumed download failed: %v", err)
+	}
+	if checksum != hex.EncodeToString(sum[:]) {
+		t.Errorf("Expected MD5 of the whole file, got %s", checksum)
+	}
+}
+
+func TestParseContentRangeStart(t *testing.T) {
+	tests := []struct {
+		header  string
+		start   int64
+		total   int64
+		wantErr bool
+	}{
+		{"bytes 100-199/200", 100, 200, false},
+		{"bytes 0-99/*", 0, -1, false},
+		{"items 0-1/2", 0, 0, true},
+		{"bytes abc-1/2", 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		start, total, err := parseContentRangeStart(tt.header)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseContentRangeStart(%q) error = %v, wantErr %v", tt.header, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (start != tt.start || total != tt.total) {
+			t.Errorf("parseContentRangeStart(%q) = %d, %d, want %d, %d", tt.header, start, total, tt.start, tt.total)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/config"
+)
+
+// ErrIncompleteDownload is returned when a server closes the connection
+// before the whole file was received
+var ErrIncompleteDownload = errors.New("incomplete download")
+
+// ErrNoSources is returned when a book has no download links to try
+var ErrNoSources = errors.New("no download sources left")
+
+// Errors matched by HTTPStatusError, so callers can tell failures apart with errors.Is
+var (
+	// ErrNotFound matches 404 and 410 answers
+	ErrNotFound = errors.New("not found")
+	// ErrForbidden matches 401 and 403 answers
+	ErrForbidden = errors.New("forbidden")
+	// ErrRateLimited matches 429 answers
+	ErrRateLimited = errors.New("rate limited")
+	// ErrUpstream5xx matches 5xx answers
+	ErrUpstream5xx = errors.New("upstream server error")
+)
+
+// HTTPStatusError is returned when a server answers with an unexpected status code
+type HTTPStatusError struct {
+	StatusCode int
+	URL        string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d for URL: %s", e.StatusCode, e.URL)
+}
+
+// Unwrap returns the error matching the class of the status code, if any
+func (e *HTTPStatusError) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusNotFound || e.StatusCode == http.StatusGone:
+		return ErrNotFound
+	case e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden:
+		return ErrForbidden
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case e.StatusCode >= 500:
+		return ErrUpstream5xx
+	}
+	return nil
+}
+
+// Transient reports whether the status code is likely to change on a later request
+func (e *HTTPStatusError) Transient() bool {
+	return e.StatusCode == http.StatusRequestTimeout ||
+		e.StatusCode == http.StatusTooManyRequests ||
+		e.StatusCode >= 500
+}
+
+// FetchError is returned when a page could not be fetched. It holds the
+// error of every attempt; errors.Is and errors.As look at the last one.
+type FetchError struct {
+	URL      string
+	Attempts []error
+}
+
+func (e *FetchError) Error() string {
+	last := e.Unwrap()
+	if len(e.Attempts) == 1 {
+		return fmt.Sprintf("failed to fetch %s: %v", e.URL, last)
+	}
+	return fmt.Sprintf("failed to fetch %s after %d attempts: %v", e.URL, len(e.Attempts), last)
+}
+
+// Unwrap returns the error of the last attempt
+func (e *FetchError) Unwrap() error {
+	if len(e.Attempts) == 0 {
+		return nil
+	}
+	return e.Attempts[len(e.Attempts)-1]
+}
+
+// IsTransient reports whether a download error is worth retrying later:
+// timeouts, connection failures, 5xx answers and truncated bodies. Errors
+// joining several failures are transient if any of them is.
+func IsTransient(err error) bool {
+	switch e := err.(type) {
+	case nil:
+		return false
+	case *HTTPStatusError:
+		return e.Transient()
+	case *net.DNSError:
+		return !e.IsNotFound
+	case *url.Error:
+		// The server closed the connection before answering
+		if e.Err == io.EOF {
+			return true
+		}
+	case *net.OpError:
+		var dnsErr *net.DNSError
+		if errors.As(e.Err, &dnsErr) {
+			return !dnsErr.IsNotFound
+		}
+		return true
+	case interface{ Unwrap() []error }:
+		for _, inner := range e.Unwrap() {
+			if IsTransient(inner) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if err == ErrIncompleteDownload || err == io.ErrUnexpectedEOF || err == context.DeadlineExceeded {
+		return true
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+	return IsTransient(errors.Unwrap(err))
+}
+
+// RetryPolicy decides whether and when a failed download is queued again
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry, doubled for every further one
+	BaseDelay time.Duration
+	// MaxDelay caps the wait between attempts
+	MaxDelay time.Duration
+}
+
+// NewRetryPolicy creates the retry policy from the download settings
+func NewRetryPolicy(cfg *config.Config) RetryPolicy {
+	policy := RetryPolicy{
+		MaxAttempts: cfg.DownloadMaxAttempts,
+		BaseDelay:   time.Duration(cfg.DownloadRetryDelay) * time.Second,
+		MaxDelay:    time.Duration(cfg.DownloadRetryMaxDelay) * time.Second,
+	}
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	if policy.MaxDelay < policy.BaseDelay {
+		policy.MaxDelay = policy.BaseDelay
+	}
+	return policy
+}
+
+// ShouldRetry reports whether a download that failed with err after the
+// given number of attempts is queued again
+func (p RetryPolicy) ShouldRetry(attempts int, err error) bool {
+	return attempts < p.MaxAttempts && IsTransient(err)
+}
+
+// Delay returns how long to wait after the given failed attempt. The delay
+// doubles with every attempt up to MaxDelay, and a random jitter of up to
+// half of it keeps failed downloads from retrying in lockstep.
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
@@ -0,0 +1,84 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"server error", &HTTPStatusError{StatusCode: 503, URL: "https://example.com"}, true},
+		{"rate limited", &HTTPStatusError{StatusCode: 429, URL: "https://example.com"}, true},
+		{"not found", &HTTPStatusError{StatusCode: 404, URL: "https://example.com"}, false},
+		{"wrapped server error", fmt.Errorf("failed: %w", &HTTPStatusError{StatusCode: 502}), true},
+		{"truncated body", fmt.Errorf("%w: got 1 bytes, expected 2", ErrIncompleteDownload), true},
+		{"unexpected EOF", fmt.Errorf("failed to read from response: %w", io.ErrUnexpectedEOF), true},
+		{"deadline", fmt.Errorf("failed: %w", context.DeadlineExceeded), true},
+		{"connection refused", &url.Error{Op: "Get", URL: "https://example.com", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, true},
+		{"closed connection", &url.Error{Op: "Get", URL: "https://example.com", Err: io.EOF}, true},
+		{"unknown host", &url.Error{Op: "Get", URL: "https://example.com", Err: &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", IsNotFound: true}}}, false},
+		{"no sources", fmt.Errorf("%w for book: Test", ErrNoSources), false},
+		{"content mismatch", fmt.Errorf("%w: got an HTML page", ErrContentMismatch), false},
+		{"cancelled", context.Canceled, false},
+		{"earlier transient error", fmt.Errorf("%w (earlier transient error: %w)", &HTTPStatusError{StatusCode: 404}, &HTTPStatusError{StatusCode: 500}), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTransient(tt.err); got != tt.want {
+				t.Errorf("IsTransient(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, BaseDelay: 10 * time.Second, MaxDelay: 60 * time.Second}
+
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{4, 60 * time.Second},
+		{10, 60 * time.Second},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			delay := policy.Delay(tt.attempt)
+			if delay < tt.max/2 || delay > tt.max {
+				t.Fatalf("Delay(%d) = %v, want between %v and %v", tt.attempt, delay, tt.max/2, tt.max)
+			}
+		}
+	}
+}
+
+func TestRetryPolicyShouldRetry(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3}
+	transient := &HTTPStatusError{StatusCode: 503}
+	permanent := &HTTPStatusError{StatusCode: 404}
+
+	if !policy.ShouldRetry(1, transient) || !policy.ShouldRetry(2, transient) {
+		t.Error("Expected transient failures to be retried before the attempt limit")
+	}
+	if policy.ShouldRetry(3, transient) {
+		t.Error("Expected no retry once the attempt limit is reached")
+	}
+	if policy.ShouldRetry(1, permanent) {
+		t.Error("Expected permanent failures not to be retried")
+	}
+}
@@ -0,0 +1,225 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const (
+	// maxCountdownWaits is how many partner server countdowns are waited for before giving up
+	maxCountdownWaits = 3
+	// maxLandingPageSize limits how much of a landing page is read while looking for the file link
+	maxLandingPageSize = 5 * 1024 * 1024
+)
+
+// countdownPattern extracts the seconds from a partner server countdown
+var countdownPattern = regexp.MustCompile(`\d+`)
+
+// WaitCallback receives the remaining seconds of a partner server countdown.
+// It is called with 0 once the wait is over.
+type WaitCallback func(remaining int)
+
+// ResolveDownloadURL turns a source link into a direct file URL. Anna's
+// Archive fast and slow download links, Z-Library pages and LibGen style
+// landing pages are followed to the file they point to; links that already
+// serve a file are returned as they are.
+func (n *Network) ResolveDownloadURL(ctx context.Context, link string, waitCallback WaitCallback) (string, error) {
+	// Fast download API
+	if strings.Contains(link, "/dyn/api/fast_download.json") {
+		html, err := n.HTMLGetPage(ctx, link, false)
+		if err != nil {
+			return "", err
+		}
+
+		var result map[string]interface{}
+		if err := json.Unmarshal([]byte(html), &result); err != nil {
+			return "", fmt.Errorf("failed to parse JSON: %w", err)
+		}
+
+		if url, ok := result["download_url"].(string); ok {
+			return url, nil
+		}
+		return "", fmt.Errorf("no download_url in response")
+	}
+
+	// Anna's Archive and Z-Library pages
+	if strings.HasPrefix(link, "https://z-lib.") || strings.Contains(link, "/slow_download/") {
+		html, err := n.HTMLGetPage(ctx, link, false)
+		if err != nil {
+			return "", err
+		}
+
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+		if err != nil {
+			return "", err
+		}
+
+		var downloadURL string
+		if strings.HasPrefix(link, "https://z-lib.") {
+			if downloadLink := doc.Find("a.addDownloadedBook[href]"); downloadLink.Length() > 0 {
+				downloadURL, _ = downloadLink.Attr("href")
+			}
+		} else {
+			// Slow download with countdown
+			downloadURL, err = n.getSlowDownloadURL(ctx, link, doc, waitCallback)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		if downloadURL == "" {
+			return "", fmt.Errorf("no download link found")
+		}
+		return GetAbsoluteURL(link, downloadURL)
+	}
+
+	// LibGen and others
+	return n.resolveLandingPage(ctx, link)
+}
+
+// resolveLandingPage requests link and, if it answers with an HTML page,
+// returns the target of its "GET" link. Links serving anything else are
+// assumed to be direct file links.
+func (n *Network) resolveLandingPage(ctx context.Context, link string) (string, error) {
+	client := n.newHTTPClient()
+
+	// Ask for the headers first so direct file links are not fetched twice.
+	// Servers refusing HEAD requests are asked with a GET.
+	if resp, err := n.requestLandingPage(ctx, client, http.MethodHead, link); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK && !isHTMLResponse(resp) {
+			return link, nil
+		}
+	}
+
+	resp, err := n.requestLandingPage(ctx, client, http.MethodGet, link)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", &HTTPStatusError{StatusCode: resp.StatusCode, URL: link}
+	}
+	if !isHTMLResponse(resp) {
+		return link, nil
+	}
+
+	doc, err := goquery.NewDocumentFromReader(io.LimitReader(resp.Body, maxLandingPageSize))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse landing page: %w", err)
+	}
+
+	getLink := doc.Find("a:contains('GET')")
+	if getLink.Length() == 0 {
+		return "", fmt.Errorf("no download link found on %s", link)
+	}
+	downloadURL, _ := getLink.First().Attr("href")
+	if downloadURL == "" {
+		return "", fmt.Errorf("no download link found on %s", link)
+	}
+
+	return GetAbsoluteURL(resp.Request.URL.String(), downloadURL)
+}
+
+// requestLandingPage sends a request for a landing page the way a browser would
+func (n *Network) requestLandingPage(ctx context.Context, client *http.Client, method, link string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, link, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.3")
+	n.applyBypasserSession(req)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", link, err)
+	}
+	return resp, nil
+}
+
+// isHTMLResponse reports whether a response is an HTML page
+func isHTMLResponse(resp *http.Response) bool {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return err == nil && (mediaType == "text/html" || mediaType == "application/xhtml+xml")
+}
+
+// getSlowDownloadURL extracts the "Download now" link from a slow partner
+// server page, waiting for the partner countdown and re-fetching the page
+// when needed
+func (n *Network) getSlowDownloadURL(ctx context.Context, link string, doc *goquery.Document, waitCallback WaitCallback) (string, error) {
+	for waits := 0; ; waits++ {
+		if downloadLink := doc.Find("a:contains('📚 Download now')"); downloadLink.Length() > 0 {
+			downloadURL, _ := downloadLink.Attr("href")
+			return downloadURL, nil
+		}
+
+		countdown := doc.Find("span.js-partner-countdown")
+		if countdown.Length() == 0 {
+			return "", nil
+		}
+		if waits >= maxCountdownWaits {
+			return "", fmt.Errorf("download link still not available after %d countdowns", waits)
+		}
+
+		seconds, err := parseCountdown(countdown.First().Text())
+		if err != nil {
+			return "", err
+		}
+		if err := waitCountdown(ctx, seconds, waitCallback); err != nil {
+			return "", err
+		}
+
+		html, err := n.HTMLGetPage(ctx, link, false)
+		if err != nil {
+			return "", err
+		}
+		doc, err = goquery.NewDocumentFromReader(strings.NewReader(html))
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// parseCountdown parses the seconds shown in a partner server countdown
+func parseCountdown(text string) (int, error) {
+	match := countdownPattern.FindString(text)
+	if match == "" {
+		return 0, fmt.Errorf("invalid countdown: %q", text)
+	}
+	return strconv.Atoi(match)
+}
+
+// waitCountdown waits for the given number of seconds, reporting the
+// remaining time every second, until the countdown ends or ctx is done
+func waitCountdown(ctx context.Context, seconds int, waitCallback WaitCallback) error {
+	if waitCallback != nil {
+		defer waitCallback(0)
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for remaining := seconds; remaining > 0; remaining-- {
+		if waitCallback != nil {
+			waitCallback(remaining)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
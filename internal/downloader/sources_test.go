@@ -0,0 +1,205 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/config"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/models"
+)
+
+const downloadNowPageHTML = `<html><body>
+<a href="https://partner.example.com/file/book.epub">📚 Download now</a>
+</body></html>`
+
+func TestResolveDownloadURLWaitsForCountdown(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.Write([]byte(`<html><body><span class="js-partner-countdown">1</span></body></html>`))
+			return
+		}
+		w.Write([]byte(downloadNowPageHTML))
+	}))
+	defer server.Close()
+
+	var remaining []int
+	waitCallback := func(seconds int) {
+		remaining = append(remaining, seconds)
+	}
+
+	cfg := &config.Config{}
+	link := server.URL + "/slow_download/abc123/0/0"
+
+	downloadURL, err := NewNetwork(cfg).ResolveDownloadURL(context.Background(), link, waitCallback)
+	if err != nil {
+		t.Fatalf("ResolveDownloadURL failed: %v", err)
+	}
+
+	if downloadURL != "https://partner.example.com/file/book.epub" {
+		t.Errorf("Unexpected download URL %s", downloadURL)
+	}
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Errorf("Expected page to be fetched twice, got %d", got)
+	}
+	if len(remaining) != 2 || remaining[0] != 1 || remaining[1] != 0 {
+		t.Errorf("Expected wait updates [1 0], got %v", remaining)
+	}
+}
+
+func TestResolveDownloadURLCountdownCancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><body><span class="js-partner-countdown">60</span></body></html>`))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	_, err := NewNetwork(&config.Config{}).ResolveDownloadURL(ctx, server.URL+"/slow_download/abc123/0/0", nil)
+	if err == nil {
+		t.Fatal("Expected cancellation error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Cancellation took too long: %v", elapsed)
+	}
+}
+
+func TestResolveDownloadURLDirectFileLink(t *testing.T) {
+	var gets int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			atomic.AddInt32(&gets, 1)
+		}
+		w.Header().Set("Content-Type", "application/epub+zip")
+		w.Write([]byte("PK\x03\x04 book content"))
+	}))
+	defer server.Close()
+
+	link := server.URL + "/file/book.epub"
+	downloadURL, err := NewNetwork(&config.Config{}).ResolveDownloadURL(context.Background(), link, nil)
+	if err != nil {
+		t.Fatalf("ResolveDownloadURL failed: %v", err)
+	}
+	if downloadURL != link {
+		t.Errorf("Expected the direct file link %s, got %s", link, downloadURL)
+	}
+	if got := atomic.LoadInt32(&gets); got != 0 {
+		t.Errorf("Expected the file to be left for the download, got %d GET requests", got)
+	}
+}
+
+func TestResolveDownloadURLWithoutHEADSupport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><a href="/get.php?md5=abc123">GET</a></body></html>`))
+	}))
+	defer server.Close()
+
+	downloadURL, err := NewNetwork(&config.Config{}).ResolveDownloadURL(context.Background(), server.URL+"/ads.php?md5=abc123", nil)
+	if err != nil {
+		t.Fatalf("ResolveDownloadURL failed: %v", err)
+	}
+	if downloadURL != server.URL+"/get.php?md5=abc123" {
+		t.Errorf("Unexpected download URL %s", downloadURL)
+	}
+}
+
+func TestParseCountdown(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected int
+		wantErr  bool
+	}{
+		{"45", 45, false},
+		{" 120 seconds", 120, false},
+		{"soon", 0, true},
+	}
+
+	for _, tt := range tests {
+		result, err := parseCountdown(tt.text)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCountdown(%q) error = %v, wantErr %v", tt.text, err, tt.wantErr)
+			continue
+		}
+		if result != tt.expected {
+			t.Errorf("parseCountdown(%q) = %d, expected %d", tt.text, result, tt.expected)
+		}
+	}
+}
+
+func TestDownloadBookFollowsLandingPage(t *testing.T) {
+	content := []byte("%PDF-1.4 book content")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ads.php", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<html><body><a href="get.php?md5=abc123&key=XYZ"><h2>GET</h2></a></body></html>`))
+	})
+	mux.HandleFunc("/get.php", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("key") != "XYZ" {
+			t.Errorf("Unexpected file request %s", r.URL)
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write(content)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	downloader, tmpDir := newResumeTestDownloader(t)
+	format := "pdf"
+	book := &models.BookInfo{
+		ID:           "abc123",
+		Title:        "Test Book",
+		Format:       &format,
+		DownloadURLs: []string{server.URL + "/ads.php?md5=abc123"},
+	}
+
+	result, err := downloader.DownloadBook(context.Background(), book, nil, nil)
+	if err != nil {
+		t.Fatalf("DownloadBook failed: %v", err)
+	}
+	path := result.Path
+	if path != filepath.Join(tmpDir, "abc123.pdf") {
+		t.Errorf("Unexpected path %s", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read downloaded file: %v", err)
+	}
+	if string(data) != string(content) {
+		t.Errorf("Expected the linked file, got %q", data)
+	}
+}
+
+func TestDownloadURLRejectsHTML(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body>Please wait</body></html>"))
+	}))
+	defer server.Close()
+
+	downloader, tmpDir := newResumeTestDownloader(t)
+	outputPath := filepath.Join(tmpDir, "book.epub")
+
+	if err := downloader.DownloadURL(context.Background(), server.URL, outputPath, "", nil); err == nil {
+		t.Fatal("Expected HTML page to be rejected")
+	}
+	if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+		t.Error("HTML page should not be saved as a book")
+	}
+}
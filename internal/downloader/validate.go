@@ -0,0 +1,163 @@
+package downloader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// headerSniffSize is how many leading bytes of a file are inspected
+const headerSniffSize = 4096
+
+// ErrContentMismatch is returned when a downloaded file does not look like
+// the expected book format
+var ErrContentMismatch = errors.New("downloaded content does not match the expected format")
+
+// ErrChecksumMismatch is returned when the MD5 of a downloaded file differs
+// from the book ID
+var ErrChecksumMismatch = errors.New("downloaded file does not match the expected MD5")
+
+// md5Pattern matches a hex encoded MD5 such as an Anna's Archive book ID
+var md5Pattern = regexp.MustCompile(`^[0-9a-fA-F]{32}$`)
+
+var (
+	zipMagic      = []byte("PK\x03\x04")
+	rarMagic      = []byte("Rar!\x1a\x07")
+	sevenZipMagic = []byte("7z\xbc\xaf\x27\x1c")
+	pdfMagic      = []byte("%PDF-")
+	djvuMagic     = []byte("AT&TFORM")
+	utf8BOM       = []byte("\xef\xbb\xbf")
+)
+
+// formatCheckers maps book formats to a check of the file header
+var formatCheckers = map[string]func(header []byte) bool{
+	"epub": isZip,
+	"cbz":  isZip,
+	"zip":  isZip,
+	"mobi": isMobi,
+	"azw":  isMobi,
+	"azw3": isMobi,
+	"prc":  isMobi,
+	"pdf":  isPDF,
+	"djvu": isDjVu,
+	"fb2":  isFB2,
+	"cbr":  isCBR,
+	"rar":  isRarOr7z,
+	"cb7":  isRarOr7z,
+}
+
+// ValidateFile checks the magic bytes of a downloaded file against the
+// expected format. Files that look like HTML pages are always rejected.
+// Unknown formats only get the HTML check.
+func ValidateFile(path string, format string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open downloaded file: %w", err)
+	}
+	defer file.Close()
+
+	header := make([]byte, headerSniffSize)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return fmt.Errorf("failed to read downloaded file: %w", err)
+	}
+	return validateHeader(header[:n], format)
+}
+
+// validateHeader checks the leading bytes of a file against the expected format
+func validateHeader(header []byte, format string) error {
+	if len(header) == 0 {
+		return fmt.Errorf("%w: file is empty", ErrContentMismatch)
+	}
+	if looksLikeHTML(header) {
+		return fmt.Errorf("%w: got an HTML page", ErrContentMismatch)
+	}
+
+	format = strings.ToLower(strings.TrimPrefix(format, "."))
+	check, exists := formatCheckers[format]
+	if !exists {
+		return nil
+	}
+	if !check(header) {
+		return fmt.Errorf("%w: file is not a valid %s", ErrContentMismatch, format)
+	}
+	return nil
+}
+
+// isZip reports whether header starts a ZIP archive (EPUB, CBZ)
+func isZip(header []byte) bool {
+	return bytes.HasPrefix(header, zipMagic)
+}
+
+// isMobi reports whether header is a Palm database with a MOBI or PalmDOC
+// type and creator (MOBI, AZW, AZW3)
+func isMobi(header []byte) bool {
+	if len(header) < 68 {
+		return false
+	}
+	typeCreator := string(header[60:68])
+	return typeCreator == "BOOKMOBI" || typeCreator == "TEXtREAd"
+}
+
+// isPDF reports whether header contains the PDF signature. Readers accept it
+// anywhere in the first kilobyte.
+func isPDF(header []byte) bool {
+	if len(header) > 1024 {
+		header = header[:1024]
+	}
+	return bytes.Contains(header, pdfMagic)
+}
+
+// isDjVu reports whether header starts a DjVu document
+func isDjVu(header []byte) bool {
+	return bytes.HasPrefix(header, djvuMagic)
+}
+
+// isFB2 reports whether header is a FictionBook XML document
+func isFB2(header []byte) bool {
+	text := bytes.TrimLeft(bytes.TrimPrefix(header, utf8BOM), " \t\r\n")
+	if !bytes.HasPrefix(text, []byte("<?xml")) && !bytes.HasPrefix(text, []byte("<FictionBook")) {
+		return false
+	}
+	return bytes.Contains(text, []byte("<FictionBook"))
+}
+
+// isRarOr7z reports whether header starts a RAR or 7z archive (CBR, CB7)
+func isRarOr7z(header []byte) bool {
+	return bytes.HasPrefix(header, rarMagic) || bytes.HasPrefix(header, sevenZipMagic)
+}
+
+// isCBR reports whether header starts a comic book archive. Many CBR files
+// are ZIP archives with the wrong extension, so ZIP is accepted as well.
+func isCBR(header []byte) bool {
+	return isRarOr7z(header) || isZip(header)
+}
+
+// looksLikeHTML reports whether header is the start of an HTML document
+func looksLikeHTML(header []byte) bool {
+	text := bytes.TrimLeft(bytes.TrimPrefix(header, utf8BOM), " \t\r\n")
+	if len(text) > 512 {
+		text = text[:512]
+	}
+	lower := bytes.ToLower(text)
+	return bytes.HasPrefix(lower, []byte("<!doctype html")) ||
+		bytes.HasPrefix(lower, []byte("<html")) ||
+		bytes.HasPrefix(lower, []byte("<head")) ||
+		(bytes.HasPrefix(lower, []byte("<?xml")) && bytes.Contains(lower, []byte("<html")))
+}
+
+// verifyChecksum compares the MD5 of a downloaded file with the book ID.
+// IDs that are not an MD5 are not checked.
+func verifyChecksum(bookID string, checksum string) error {
+	if !md5Pattern.MatchString(bookID) {
+		return nil
+	}
+	if !strings.EqualFold(bookID, checksum) {
+		return fmt.Errorf("%w: expected %s, got %s", ErrChecksumMismatch, strings.ToLower(bookID), checksum)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/models"
+)
+
+// mobiHeader builds a Palm database header with the given type and creator
+func mobiHeader(typeCreator string) []byte {
+	header := make([]byte, 78)
+	copy(header, "Test_Book")
+	copy(header[60:], typeCreator)
+	return header
+}
+
+func TestValidateHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		header  []byte
+		wantErr bool
+	}{
+		{"epub", "epub", []byte("PK\x03\x04\x14\x00mimetypeapplication/epub+zip"), false},
+		{"epub html", "epub", []byte("<!DOCTYPE html><html><body>captcha</body></html>"), true},
+		{"epub text", "epub", []byte("not a zip"), true},
+		{"cbz", "CBZ", []byte("PK\x03\x04data"), false},
+		{"mobi", "mobi", mobiHeader("BOOKMOBI"), false},
+		{"azw3", "azw3", mobiHeader("BOOKMOBI"), false},
+		{"mobi short", "mobi", []byte("BOOKMOBI"), true},
+		{"pdf", "pdf", []byte("%PDF-1.7\n"), false},
+		{"pdf zip", "pdf", []byte("PK\x03\x04"), true},
+		{"djvu", "djvu", []byte("AT&TFORM\x00\x00\x00\x00DJVM"), false},
+		{"fb2", "fb2", []byte("\xef\xbb\xbf<?xml version=\"1.0\"?>\n<FictionBook xmlns=\"http://www.gribuser.ru/xml/fictionbook/2.0\">"), false},
+		{"fb2 xhtml", "fb2", []byte("<?xml version=\"1.0\"?><html><body></body></html>"), true},
+		{"cbr", "cbr", []byte("Rar!\x1a\x07\x00"), false},
+		{"cbr 7z", "cbr", []byte("7z\xbc\xaf\x27\x1c"), false},
+		{"cbr zip", "cbr", []byte("PK\x03\x04data"), false},
+		{"cbr pdf", "cbr", []byte("%PDF-1.7\n"), true},
+		{"unknown format", "txt", []byte("plain text"), false},
+		{"unknown format html", "", []byte("  <html><head></head></html>"), true},
+		{"empty", "epub", []byte{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateHeader(tt.header, tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateHeader() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrContentMismatch) {
+				t.Errorf("Expected ErrContentMismatch, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDownloadBookSkipsInvalidContent(t *testing.T) {
+	// A mirror that answers with an error page served as a binary file
+	badServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write([]byte("<html><body>Too many requests</body></html>"))
+	}))
+	defer badServer.Close()
+
+	content := append([]byte("%PDF-1.4\n"), bytes.Repeat([]byte("x"), 100)...)
+	goodServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write(content)
+	}))
+	defer goodServer.Close()
+
+	downloader, tmpDir := newResumeTestDownloader(t)
+	format := "pdf"
+	book := &models.BookInfo{
+		ID:           "abc123",
+		Title:        "Test Book",
+		Format:       &format,
+		DownloadURLs: []string{badServer.URL, goodServer.URL},
+	}
+
+	result, err := downloader.DownloadBook(context.Background(), book, nil, nil)
+	if err != nil {
+		t.Fatalf("DownloadBook failed: %v", err)
+	}
+	path := result.Path
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read downloaded file: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("Expected content from the second source, got %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "abc123.pdf"+TempDownloadExt)); !os.IsNotExist(err) {
+		t.Error("Rejected download should not leave files behind")
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	checksum := "d41d8cd98f00b204e9800998ecf8427e"
+
+	if err := verifyChecksum("D41D8CD98F00B204E9800998ECF8427E", checksum); err != nil {
+		t.Errorf("Expected matching checksum, got %v", err)
+	}
+	if err := verifyChecksum("00000000000000000000000000000000", checksum); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Expected ErrChecksumMismatch, got %v", err)
+	}
+	if err := verifyChecksum("test123", checksum); err != nil {
+		t.Errorf("IDs that are not an MD5 should not be checked, got %v", err)
+	}
+}
+
+func TestDownloadBookVerifiesMD5(t *testing.T) {
+	content := append([]byte("%PDF-1.4\n"), bytes.Repeat([]byte("a"), 100)...)
+	sum := md5.Sum(content)
+	bookID := hex.EncodeToString(sum[:])
+
+	// A mirror serving a different edition of the book
+	otherServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(append([]byte("%PDF-1.4\n"), bytes.Repeat([]byte("b"), 100)...))
+	}))
+	defer otherServer.Close()
+
+	goodServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer goodServer.Close()
+
+	downloader, _ := newResumeTestDownloader(t)
+	format := "pdf"
+	book := &models.BookInfo{
+		ID:           bookID,
+		Title:        "Test Book",
+		Format:       &format,
+		DownloadURLs: []string{otherServer.URL, goodServer.URL},
+	}
+
+	result, err := downloader.DownloadBook(context.Background(), book, nil, nil)
+	if err != nil {
+		t.Fatalf("DownloadBook failed: %v", err)
+	}
+	if result.MD5 != bookID {
+		t.Errorf("Expected MD5 %s, got %s", bookID, result.MD5)
+	}
+
+	data, err := os.ReadFile(result.Path)
+	if err != nil {
+		t.Fatalf("Failed to read downloaded file: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("Expected the file matching the book ID")
+	}
+
+	// No source has the right file
+	book.DownloadURLs = []string{otherServer.URL}
+	if _, err := downloader.DownloadBook(context.Background(), book, nil, nil); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Expected ErrChecksumMismatch, got %v", err)
+	}
+}
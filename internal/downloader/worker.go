@@ -2,6 +2,8 @@ package downloader
 
 import (
 	"context"
+	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -10,24 +12,69 @@ import (
 	"go.uber.org/zap"
 )
 
+// MaxWorkers is the largest number of workers a pool can be resized to
+const MaxWorkers = 32
+
+// ErrInvalidWorkerCount is returned when resizing a pool out of range
+var ErrInvalidWorkerCount = fmt.Errorf("worker count must be between 0 and %d", MaxWorkers)
+
+// WorkerState is what a worker is doing
+type WorkerState string
+
+const (
+	// WorkerIdle means the worker waits for a book
+	WorkerIdle WorkerState = "idle"
+	// WorkerBusy means the worker downloads a book
+	WorkerBusy WorkerState = "busy"
+	// WorkerStopping means the worker finishes its download and then exits
+	WorkerStopping WorkerState = "stopping"
+)
+
+// WorkerStatus describes a single worker of the pool
+type WorkerStatus struct {
+	ID        int         `json:"id"`
+	State     WorkerState `json:"state"`
+	BookID    string      `json:"book_id,omitempty"`
+	StartedAt *time.Time  `json:"started_at,omitempty"`
+}
+
+// workerHandle tracks a running worker goroutine
+type workerHandle struct {
+	id        int
+	stop      context.CancelFunc
+	stopping  bool
+	bookID    string
+	startedAt time.Time
+}
+
 // WorkerPool manages concurrent book downloads using goroutines
 type WorkerPool struct {
 	config     *config.Config
 	logger     *zap.Logger
 	downloader *Downloader
 	queue      *models.BookQueue
-	stopChan   chan struct{}
+	retry      RetryPolicy
+	ctx        context.Context
+	stop       context.CancelFunc
 	wg         sync.WaitGroup
+
+	mu      sync.Mutex
+	workers map[int]*workerHandle
+	lastID  int
 }
 
-// NewWorkerPool creates a new download worker pool
-func NewWorkerPool(cfg *config.Config, logger *zap.Logger, queue *models.BookQueue) *WorkerPool {
+// NewWorkerPool creates a new download worker pool making its requests through network
+func NewWorkerPool(cfg *config.Config, logger *zap.Logger, queue *models.BookQueue, network *Network) *WorkerPool {
+	ctx, stop := context.WithCancel(context.Background())
 	return &WorkerPool{
 		config:     cfg,
 		logger:     logger,
-		downloader: NewDownloader(cfg, logger),
+		downloader: NewDownloader(cfg, logger, network),
 		queue:      queue,
-		stopChan:   make(chan struct{}),
+		retry:      NewRetryPolicy(cfg),
+		ctx:        ctx,
+		stop:       stop,
+		workers:    make(map[int]*workerHandle),
 	}
 }
 
@@ -36,48 +83,142 @@ func (wp *WorkerPool) Start() {
 	wp.logger.Info("Starting download worker pool",
 		zap.Int("max_workers", wp.config.MaxConcurrentDownloads))
 
-	// Start worker goroutines
-	for i := 0; i < wp.config.MaxConcurrentDownloads; i++ {
-		wp.wg.Add(1)
-		go wp.worker(i + 1)
+	if err := wp.Resize(wp.config.MaxConcurrentDownloads); err != nil {
+		wp.logger.Error("Invalid number of workers, starting one",
+			zap.Int("max_workers", wp.config.MaxConcurrentDownloads),
+			zap.Error(err))
+		wp.Resize(1)
 	}
 }
 
 // Stop gracefully stops the worker pool
 func (wp *WorkerPool) Stop() {
 	wp.logger.Info("Stopping download worker pool")
-	close(wp.stopChan)
+	wp.stop()
 	wp.wg.Wait()
 	wp.logger.Info("Download worker pool stopped")
 }
 
-// worker is a goroutine that processes downloads
-func (wp *WorkerPool) worker(id int) {
+// Resize changes the number of workers. New workers start right away.
+// Removed workers stop once their current download is done; idle ones are
+// removed first.
+func (wp *WorkerPool) Resize(size int) error {
+	if size < 0 || size > MaxWorkers {
+		return ErrInvalidWorkerCount
+	}
+
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	active := wp.activeWorkers()
+	for len(active) < size {
+		wp.lastID++
+		ctx, stop := context.WithCancel(wp.ctx)
+		handle := &workerHandle{id: wp.lastID, stop: stop}
+		wp.workers[handle.id] = handle
+		active = append(active, handle)
+
+		wp.wg.Add(1)
+		go wp.worker(ctx, handle)
+	}
+
+	if excess := len(active) - size; excess > 0 {
+		// Stop idle workers before busy ones, newest first
+		sort.Slice(active, func(i, j int) bool {
+			if (active[i].bookID == "") != (active[j].bookID == "") {
+				return active[i].bookID == ""
+			}
+			return active[i].id > active[j].id
+		})
+		for _, handle := range active[:excess] {
+			handle.stopping = true
+			handle.stop()
+		}
+	}
+
+	wp.logger.Info("Worker pool resized", zap.Int("workers", size))
+	return nil
+}
+
+// Size returns the number of workers, not counting those about to stop
+func (wp *WorkerPool) Size() int {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	return len(wp.activeWorkers())
+}
+
+// Workers returns the state of every worker, ordered by ID
+func (wp *WorkerPool) Workers() []WorkerStatus {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	workers := make([]WorkerStatus, 0, len(wp.workers))
+	for _, handle := range wp.workers {
+		status := WorkerStatus{ID: handle.id, State: WorkerIdle}
+		if handle.bookID != "" {
+			startedAt := handle.startedAt
+			status.State = WorkerBusy
+			status.BookID = handle.bookID
+			status.StartedAt = &startedAt
+		}
+		if handle.stopping {
+			status.State = WorkerStopping
+		}
+		workers = append(workers, status)
+	}
+	sort.Slice(workers, func(i, j int) bool { return workers[i].ID < workers[j].ID })
+	return workers
+}
+
+// activeWorkers returns the workers that are not stopping. Callers must hold wp.mu.
+func (wp *WorkerPool) activeWorkers() []*workerHandle {
+	active := make([]*workerHandle, 0, len(wp.workers))
+	for _, handle := range wp.workers {
+		if !handle.stopping {
+			active = append(active, handle)
+		}
+	}
+	return active
+}
+
+// setBook records the book a worker is downloading, or clears it
+func (wp *WorkerPool) setBook(handle *workerHandle, bookID string) {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	handle.bookID = bookID
+	handle.startedAt = time.Now()
+}
+
+// worker is a goroutine that processes downloads until ctx is done
+func (wp *WorkerPool) worker(ctx context.Context, handle *workerHandle) {
 	defer wp.wg.Done()
+	defer func() {
+		wp.mu.Lock()
+		delete(wp.workers, handle.id)
+		wp.mu.Unlock()
+	}()
 
+	id := handle.id
 	wp.logger.Info("Worker started", zap.Int("worker_id", id))
 
 	for {
-		select {
-		case <-wp.stopChan:
+		// Wait for the next book, or for the worker to be stopped
+		bookID, cancelChan, err := wp.queue.Next(ctx)
+		if err != nil {
 			wp.logger.Info("Worker stopping", zap.Int("worker_id", id))
 			return
-		default:
-			// Try to get next book from queue
-			bookID, cancelChan, ok := wp.queue.GetNext()
-			if !ok {
-				// Queue is empty, sleep briefly and retry
-				time.Sleep(time.Duration(wp.config.MainLoopSleepTime) * time.Second)
-				continue
-			}
+		}
 
-			wp.logger.Info("Worker processing download",
-				zap.Int("worker_id", id),
-				zap.String("book_id", bookID))
+		wp.logger.Info("Worker processing download",
+			zap.Int("worker_id", id),
+			zap.String("book_id", bookID))
 
-			// Process the download
-			wp.processDownload(bookID, cancelChan)
-		}
+		// Process the download, it is not interrupted when the worker is stopped
+		wp.setBook(handle, bookID)
+		wp.processDownload(bookID, cancelChan)
+		wp.setBook(handle, "")
 	}
 }
 
@@ -112,7 +253,7 @@ func (wp *WorkerPool) processDownload(bookID string, cancelChan chan struct{}) {
 
 	if book == nil {
 		wp.logger.Error("Book not found in queue", zap.String("book_id", bookID))
-		wp.queue.UpdateStatus(bookID, models.StatusError)
+		wp.queue.FinishDownload(bookID, cancelChan, models.StatusError)
 		return
 	}
 
@@ -121,32 +262,65 @@ func (wp *WorkerPool) processDownload(bookID string, cancelChan chan struct{}) {
 		wp.queue.UpdateProgress(bookID, progress)
 	}
 
+	// Show partner server countdowns in the queue status
+	waitCallback := func(remaining int) {
+		wp.queue.UpdateWaiting(bookID, remaining)
+	}
+
 	// Attempt download
-	downloadPath, err := wp.downloader.DownloadBook(ctx, book, progressCallback)
+	result, err := wp.downloader.DownloadBook(ctx, book, progressCallback, waitCallback)
 
 	// Check if cancelled
 	select {
 	case <-ctx.Done():
 		wp.logger.Info("Download cancelled", zap.String("book_id", bookID))
-		wp.queue.UpdateStatus(bookID, models.StatusCancelled)
+		wp.queue.FinishDownload(bookID, cancelChan, models.StatusCancelled)
 		return
 	default:
 	}
 
 	// Update queue based on result
 	if err != nil {
-		wp.logger.Error("Download failed",
-			zap.String("book_id", bookID),
-			zap.Error(err))
-		wp.queue.UpdateStatus(bookID, models.StatusError)
+		wp.handleFailure(bookID, cancelChan, err)
 		return
 	}
 
 	// Success
-	wp.queue.UpdateDownloadPath(bookID, downloadPath)
-	wp.queue.UpdateStatus(bookID, models.StatusAvailable)
+	wp.queue.UpdateDownloadPath(bookID, result.Path)
+	wp.queue.UpdateMD5(bookID, result.MD5)
+	if result.Conversion != nil {
+		wp.queue.UpdateConversion(bookID, *result.Conversion)
+	}
+	wp.queue.FinishDownload(bookID, cancelChan, models.StatusAvailable)
 
 	wp.logger.Info("Download completed successfully",
 		zap.String("book_id", bookID),
-		zap.String("path", downloadPath))
+		zap.String("path", result.Path),
+		zap.String("md5", result.MD5))
+}
+
+// handleFailure records a failed download attempt and either queues the book
+// again after a backoff or marks it as failed
+func (wp *WorkerPool) handleFailure(bookID string, cancelChan chan struct{}, err error) {
+	transient := IsTransient(err)
+	attempts := wp.queue.RecordAttempt(bookID, err.Error(), transient)
+
+	if wp.retry.ShouldRetry(attempts, err) {
+		delay := wp.retry.Delay(attempts)
+		wp.logger.Warn("Download failed, retrying later",
+			zap.String("book_id", bookID),
+			zap.Int("attempt", attempts),
+			zap.Int("max_attempts", wp.retry.MaxAttempts),
+			zap.Duration("delay", delay),
+			zap.Error(err))
+		wp.queue.RequeueDownload(bookID, cancelChan, delay)
+		return
+	}
+
+	wp.logger.Error("Download failed",
+		zap.String("book_id", bookID),
+		zap.Int("attempt", attempts),
+		zap.Bool("transient", transient),
+		zap.Error(err))
+	wp.queue.FinishDownload(bookID, cancelChan, models.StatusError)
 }
@@ -6,6 +6,8 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -66,7 +68,7 @@ func TestWorkerPoolIntegration(t *testing.T) {
 	queue := models.NewBookQueue(time.Duration(cfg.StatusTimeout) * time.Second)
 
 	// Create and start worker pool
-	workerPool := NewWorkerPool(cfg, logger, queue)
+	workerPool := NewWorkerPool(cfg, logger, queue, NewNetwork(cfg))
 	workerPool.Start()
 	defer workerPool.Stop()
 
@@ -207,7 +209,7 @@ func TestWorkerPoolCancellation(t *testing.T) {
 	queue := models.NewBookQueue(time.Duration(cfg.StatusTimeout) * time.Second)
 
 	// Create and start worker pool
-	workerPool := NewWorkerPool(cfg, logger, queue)
+	workerPool := NewWorkerPool(cfg, logger, queue, NewNetwork(cfg))
 	workerPool.Start()
 	defer workerPool.Stop()
 
@@ -248,3 +250,303 @@ func TestWorkerPoolCancellation(t *testing.T) {
 		t.Error("Download file should not exist after cancellation")
 	}
 }
+
+func TestWorkerPoolRetriesTransientFailure(t *testing.T) {
+	content := []byte("test book content")
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail the first download, not the HEAD request resolving the link
+		if r.Method == http.MethodGet && atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(content)))
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		TmpDir:                 t.TempDir(),
+		IngestDir:              t.TempDir(),
+		MaxConcurrentDownloads: 1,
+		MainLoopSleepTime:      1,
+		StatusTimeout:          3600,
+		DownloadMaxAttempts:    2,
+	}
+
+	logger, _ := zap.NewDevelopment()
+	queue := models.NewBookQueue(time.Duration(cfg.StatusTimeout) * time.Second)
+
+	workerPool := NewWorkerPool(cfg, logger, queue, NewNetwork(cfg))
+	workerPool.Start()
+	defer workerPool.Stop()
+
+	format := "txt"
+	queue.Add("retry-book", &models.BookInfo{
+		ID:           "retry-book",
+		Title:        "Retry Book",
+		Format:       &format,
+		DownloadURLs: []string{server.URL},
+	}, 0)
+
+	timeout := time.After(testTimeout)
+	ticker := time.NewTicker(testPollInterval)
+	defer ticker.Stop()
+
+	for {
+		status := queue.GetStatus()
+		if book, exists := status[models.StatusAvailable]["retry-book"]; exists {
+			if len(book.Attempts) != 1 || !book.Attempts[0].Transient {
+				t.Errorf("Expected one transient attempt to be recorded, got %+v", book.Attempts)
+			}
+			return
+		}
+		if _, exists := status[models.StatusError]["retry-book"]; exists {
+			t.Fatal("Expected the download to be retried instead of failing")
+		}
+
+		select {
+		case <-timeout:
+			t.Fatal("Timeout waiting for the retried download")
+		case <-ticker.C:
+		}
+	}
+}
+
+func TestWorkerPoolStopInterruptsIdleWorkers(t *testing.T) {
+	cfg := &config.Config{
+		TmpDir:                 t.TempDir(),
+		IngestDir:              t.TempDir(),
+		MaxConcurrentDownloads: 2,
+		MainLoopSleepTime:      5,
+		StatusTimeout:          3600,
+	}
+
+	logger, _ := zap.NewDevelopment()
+	queue := models.NewBookQueue(time.Duration(cfg.StatusTimeout) * time.Second)
+
+	workerPool := NewWorkerPool(cfg, logger, queue, NewNetwork(cfg))
+	workerPool.Start()
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	workerPool.Stop()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected idle workers to stop right away, took %v", elapsed)
+	}
+}
+
+func TestWorkerPoolResize(t *testing.T) {
+	cfg := &config.Config{
+		TmpDir:                 t.TempDir(),
+		IngestDir:              t.TempDir(),
+		MaxConcurrentDownloads: 2,
+		StatusTimeout:          3600,
+	}
+
+	logger, _ := zap.NewDevelopment()
+	queue := models.NewBookQueue(time.Duration(cfg.StatusTimeout) * time.Second)
+
+	workerPool := NewWorkerPool(cfg, logger, queue, NewNetwork(cfg))
+	workerPool.Start()
+	defer workerPool.Stop()
+
+	if size := workerPool.Size(); size != 2 {
+		t.Fatalf("Expected 2 workers, got %d", size)
+	}
+
+	if err := workerPool.Resize(4); err != nil {
+		t.Fatalf("Failed to scale up: %v", err)
+	}
+	workers := workerPool.Workers()
+	if len(workers) != 4 {
+		t.Fatalf("Expected 4 workers, got %d", len(workers))
+	}
+	for _, worker := range workers {
+		if worker.State != WorkerIdle {
+			t.Errorf("Expected worker %d to be idle, got %s", worker.ID, worker.State)
+		}
+	}
+
+	if err := workerPool.Resize(1); err != nil {
+		t.Fatalf("Failed to scale down: %v", err)
+	}
+	if size := workerPool.Size(); size != 1 {
+		t.Errorf("Expected 1 worker, got %d", size)
+	}
+
+	// Idle workers exit right away
+	deadline := time.Now().Add(time.Second)
+	for len(workerPool.Workers()) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected stopped workers to exit, still have %+v", workerPool.Workers())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := workerPool.Resize(MaxWorkers + 1); err != ErrInvalidWorkerCount {
+		t.Errorf("Expected ErrInvalidWorkerCount, got %v", err)
+	}
+}
+
+func TestWorkerPoolScaleDownFinishesDownload(t *testing.T) {
+	release := make(chan struct{})
+	content := []byte("test book content")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(content)))
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		TmpDir:                 t.TempDir(),
+		IngestDir:              t.TempDir(),
+		MaxConcurrentDownloads: 1,
+		StatusTimeout:          3600,
+	}
+
+	logger, _ := zap.NewDevelopment()
+	queue := models.NewBookQueue(time.Duration(cfg.StatusTimeout) * time.Second)
+
+	workerPool := NewWorkerPool(cfg, logger, queue, NewNetwork(cfg))
+	workerPool.Start()
+	defer workerPool.Stop()
+
+	format := "txt"
+	queue.Add("busy-book", &models.BookInfo{
+		ID:           "busy-book",
+		Title:        "Busy Book",
+		Format:       &format,
+		DownloadURLs: []string{server.URL},
+	}, 0)
+
+	// Wait for the worker to pick up the book
+	deadline := time.Now().Add(testTimeout)
+	for {
+		workers := workerPool.Workers()
+		if len(workers) == 1 && workers[0].State == WorkerBusy {
+			if workers[0].BookID != "busy-book" || workers[0].StartedAt == nil {
+				t.Errorf("Unexpected worker state %+v", workers[0])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timeout waiting for the worker to start downloading")
+		}
+		time.Sleep(testPollInterval)
+	}
+
+	if err := workerPool.Resize(0); err != nil {
+		t.Fatalf("Failed to scale down: %v", err)
+	}
+	if workers := workerPool.Workers(); len(workers) != 1 || workers[0].State != WorkerStopping {
+		t.Errorf("Expected the busy worker to be stopping, got %+v", workers)
+	}
+	close(release)
+
+	for {
+		status := queue.GetStatus()
+		if _, exists := status[models.StatusAvailable]["busy-book"]; exists && len(workerPool.Workers()) == 0 {
+			return
+		}
+		if _, exists := status[models.StatusError]["busy-book"]; exists {
+			t.Fatal("Expected the download to finish after scaling down")
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timeout waiting for the download to finish")
+		}
+		time.Sleep(testPollInterval)
+	}
+}
+
+func TestWorkerPoolScaleDownStopsTakingBooks(t *testing.T) {
+	// The first three books block on firstBatch, later ones on rest
+	firstBatch := make(chan struct{})
+	rest := make(chan struct{})
+	var mu sync.Mutex
+	started := make(map[string]bool)
+	content := []byte("test book content")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		started[r.URL.Path] = true
+		gate := rest
+		if len(started) <= 3 {
+			gate = firstBatch
+		}
+		mu.Unlock()
+
+		<-gate
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(content)))
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		TmpDir:                 t.TempDir(),
+		IngestDir:              t.TempDir(),
+		MaxConcurrentDownloads: 3,
+		StatusTimeout:          3600,
+	}
+
+	logger, _ := zap.NewDevelopment()
+	queue := models.NewBookQueue(time.Duration(cfg.StatusTimeout) * time.Second)
+
+	workerPool := NewWorkerPool(cfg, logger, queue, NewNetwork(cfg))
+	workerPool.Start()
+	defer workerPool.Stop()
+	defer close(rest)
+
+	format := "txt"
+	for i := 0; i < 6; i++ {
+		bookID := fmt.Sprintf("book-%d", i)
+		queue.Add(bookID, &models.BookInfo{
+			ID:           bookID,
+			Title:        bookID,
+			Format:       &format,
+			DownloadURLs: []string{server.URL + "/" + bookID},
+		}, 0)
+	}
+
+	// Wait for all three workers to be busy
+	deadline := time.Now().Add(testTimeout)
+	for {
+		busy := 0
+		for _, worker := range workerPool.Workers() {
+			if worker.State == WorkerBusy {
+				busy++
+			}
+		}
+		if busy == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timeout waiting for the workers to start downloading")
+		}
+		time.Sleep(testPollInterval)
+	}
+
+	if err := workerPool.Resize(1); err != nil {
+		t.Fatalf("Failed to scale down: %v", err)
+	}
+	close(firstBatch)
+
+	// The stopping workers exit, the remaining one takes the next book
+	for len(workerPool.Workers()) != 1 || len(queue.GetStatus()[models.StatusDownloading]) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timeout waiting for the pool to shrink, workers %+v", workerPool.Workers())
+		}
+		time.Sleep(testPollInterval)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	mu.Lock()
+	startedBooks := len(started)
+	mu.Unlock()
+	if startedBooks != 4 {
+		t.Errorf("Expected 4 books to be started after scaling down to one worker, got %d", startedBooks)
+	}
+	if queued := len(queue.GetStatus()[models.StatusQueued]); queued != 2 {
+		t.Errorf("Expected 2 books to stay queued, got %d", queued)
+	}
+}
@@ -0,0 +1,238 @@
+package hostlimit
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRateLimitBackoff is how long a host is left alone after a 429
+	// without a Retry-After header
+	DefaultRateLimitBackoff = 10 * time.Second
+	// MaxBackoff caps the time a host is left alone after a Retry-After header
+	MaxBackoff = 10 * time.Minute
+)
+
+// Limiter keeps requests to each host polite: at most MaxConcurrent requests
+// at a time, starting at least MinInterval apart, and none while the host
+// asked us to back off. Zero values mean no limit.
+type Limiter struct {
+	MaxConcurrent int
+	MinInterval   time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*host
+	now   func() time.Time
+}
+
+// host is the state of a single host
+type host struct {
+	slots        chan struct{}
+	next         time.Time
+	blockedUntil time.Time
+}
+
+// HostStatus describes the state of a single host
+type HostStatus struct {
+	Host         string     `json:"host"`
+	Active       int        `json:"active"`
+	BlockedUntil *time.Time `json:"blocked_until,omitempty"`
+}
+
+// New creates a limiter
+func New(maxConcurrent int, minInterval time.Duration) *Limiter {
+	return &Limiter{
+		MaxConcurrent: maxConcurrent,
+		MinInterval:   minInterval,
+		hosts:         make(map[string]*host),
+		now:           time.Now,
+	}
+}
+
+// host returns the state of a host, creating it when needed. Callers must hold l.mu.
+func (l *Limiter) host(name string) *host {
+	h, exists := l.hosts[name]
+	if !exists {
+		h = &host{}
+		if l.MaxConcurrent > 0 {
+			h.slots = make(chan struct{}, l.MaxConcurrent)
+		}
+		l.hosts[name] = h
+	}
+	return h
+}
+
+// Acquire waits for a request slot for the host and for its turn to start.
+// The returned function gives the slot back and must be called once the
+// request, including reading its body, is done.
+func (l *Limiter) Acquire(ctx context.Context, hostName string) (func(), error) {
+	hostName = strings.ToLower(hostName)
+
+	l.mu.Lock()
+	h := l.host(hostName)
+	l.mu.Unlock()
+
+	release := func() {}
+	if h.slots != nil {
+		select {
+		case h.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		var once sync.Once
+		release = func() {
+			once.Do(func() { <-h.slots })
+		}
+	}
+
+	// Take the next start time for this host
+	l.mu.Lock()
+	now := l.now()
+	start := now
+	if h.next.After(start) {
+		start = h.next
+	}
+	if h.blockedUntil.After(start) {
+		start = h.blockedUntil
+	}
+	h.next = start.Add(l.MinInterval)
+	l.mu.Unlock()
+
+	if wait := start.Sub(now); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+	return release, nil
+}
+
+// Backoff keeps requests away from the host for d
+func (l *Limiter) Backoff(hostName string, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	if d > MaxBackoff {
+		d = MaxBackoff
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	h := l.host(strings.ToLower(hostName))
+	if until := l.now().Add(d); until.After(h.blockedUntil) {
+		h.blockedUntil = until
+	}
+}
+
+// Observe backs off from the host of a 429 or 503 response, for as long as
+// its Retry-After header asks
+func (l *Limiter) Observe(resp *http.Response) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return
+	}
+
+	delay, ok := ParseRetryAfter(resp.Header.Get("Retry-After"), l.now())
+	if !ok {
+		if resp.StatusCode != http.StatusTooManyRequests {
+			return
+		}
+		delay = DefaultRateLimitBackoff
+	}
+	l.Backoff(resp.Request.URL.Host, delay)
+}
+
+// Status returns the state of every host seen so far
+func (l *Limiter) Status() []HostStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	statuses := make([]HostStatus, 0, len(l.hosts))
+	for name, h := range l.hosts {
+		status := HostStatus{Host: name, Active: len(h.slots)}
+		if h.blockedUntil.After(now) {
+			blockedUntil := h.blockedUntil
+			status.BlockedUntil = &blockedUntil
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// ParseRetryAfter parses a Retry-After header given in seconds or as an HTTP date
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		delay := date.Sub(now)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
+
+// Transport is an http.RoundTripper that sends every request through a Limiter
+type Transport struct {
+	Base    http.RoundTripper
+	Limiter *Limiter
+	// ReleaseOnHeaders gives the host slot back once the response headers
+	// arrive, so long downloads do not hold it for the whole transfer
+	ReleaseOnHeaders bool
+}
+
+// RoundTrip waits for the host to accept another request, sends it and backs
+// off when the host answers 429 or 503. The host slot is held until the
+// response body is closed, or only until the headers arrive with
+// ReleaseOnHeaders.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	release, err := t.Limiter.Acquire(req.Context(), req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	t.Limiter.Observe(resp)
+	if t.ReleaseOnHeaders {
+		release()
+		return resp, nil
+	}
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// releaseBody gives the host slot back when the body is closed
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
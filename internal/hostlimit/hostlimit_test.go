@@ -0,0 +1,171 @@
+package hostlimit
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLimiterMaxConcurrent(t *testing.T) {
+	limiter := New(2, 0)
+	ctx := context.Background()
+
+	release1, _ := limiter.Acquire(ctx, "example.com")
+	release2, _ := limiter.Acquire(ctx, "example.com")
+
+	// Other hosts are not affected
+	releaseOther, err := limiter.Acquire(ctx, "other.example.com")
+	if err != nil {
+		t.Fatalf("Expected another host to get a slot, got %v", err)
+	}
+	releaseOther()
+
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := limiter.Acquire(timeout, "example.com"); err != context.DeadlineExceeded {
+		t.Errorf("Expected a third request to wait, got %v", err)
+	}
+
+	release1()
+	release1() // releasing twice must not free another slot
+	release3, err := limiter.Acquire(ctx, "example.com")
+	if err != nil {
+		t.Fatalf("Expected a slot after release, got %v", err)
+	}
+
+	timeout, cancel = context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := limiter.Acquire(timeout, "example.com"); err != context.DeadlineExceeded {
+		t.Errorf("Expected the host to be full again, got %v", err)
+	}
+
+	release2()
+	release3()
+}
+
+func TestLimiterMinInterval(t *testing.T) {
+	limiter := New(0, 50*time.Millisecond)
+	ctx := context.Background()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := limiter.Acquire(ctx, "example.com")
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+				return
+			}
+			release()
+		}()
+	}
+	wg.Wait()
+
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected three requests to take at least two intervals, took %v", elapsed)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	if d, ok := ParseRetryAfter("120", now); !ok || d != 2*time.Minute {
+		t.Errorf("Expected 2m, got %v, %v", d, ok)
+	}
+	if d, ok := ParseRetryAfter("Mon, 01 Jan 2024 12:00:30 GMT", now); !ok || d != 30*time.Second {
+		t.Errorf("Expected 30s, got %v, %v", d, ok)
+	}
+	for _, value := range []string{"", "soon", "-5"} {
+		if _, ok := ParseRetryAfter(value, now); ok {
+			t.Errorf("Expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestTransportBacksOffOnRetryAfter(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	limiter := New(1, 0)
+	client := &http.Client{Transport: &Transport{Base: http.DefaultTransport, Limiter: limiter}}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429, got %d", resp.StatusCode)
+	}
+
+	statuses := limiter.Status()
+	if len(statuses) != 1 || statuses[0].BlockedUntil == nil || statuses[0].Active != 0 {
+		t.Errorf("Expected the host to be blocked with no active requests, got %+v", statuses)
+	}
+
+	start := time.Now()
+	resp, err = client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Errorf("Expected the request to wait for Retry-After, took %v", elapsed)
+	}
+}
+
+func TestTransportReleaseOnHeaders(t *testing.T) {
+	unblock := make(chan struct{})
+	defer close(unblock)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/download" {
+			// Send the headers, then stall the body like a slow download
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			select {
+			case <-unblock:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	limiter := New(2, 0)
+	downloads := &http.Client{Transport: &Transport{Base: http.DefaultTransport, Limiter: limiter, ReleaseOnHeaders: true}}
+	pages := &http.Client{Transport: &Transport{Base: http.DefaultTransport, Limiter: limiter}}
+
+	for i := 0; i < 2; i++ {
+		resp, err := downloads.Get(server.URL + "/download")
+		if err != nil {
+			t.Fatalf("Download failed: %v", err)
+		}
+		defer resp.Body.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/page", nil)
+	resp, err := pages.Do(req)
+	if err != nil {
+		t.Fatalf("Expected the page request to go through while downloads run, got %v", err)
+	}
+	resp.Body.Close()
+}
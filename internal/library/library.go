@@ -0,0 +1,309 @@
+package library
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/config"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/models"
+)
+
+// How a book was matched against the library
+const (
+	MatchISBN        = "isbn"
+	MatchTitleAuthor = "title_author"
+)
+
+// Duplicate policies for download requests of books already in the library
+const (
+	PolicyOff    = "off"
+	PolicyWarn   = "warn"
+	PolicyRefuse = "refuse"
+)
+
+// Match describes a book found in the Calibre library
+type Match struct {
+	CalibreID int64    `json:"calibre_id"`
+	Title     string   `json:"title"`
+	Authors   []string `json:"authors,omitempty"`
+	MatchedBy string   `json:"matched_by"`
+}
+
+// Library looks books up in a Calibre metadata.db. The database is read into
+// memory and read again whenever the file changes.
+type Library struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	byISBN  map[string]*entry
+	byTitle map[string][]*entry
+}
+
+// entry is a book of the library
+type entry struct {
+	id      int64
+	title   string
+	authors []string
+}
+
+// New creates a library reading the Calibre metadata.db at path. An empty
+// path disables lookups.
+func New(path string) *Library {
+	return &Library{path: path}
+}
+
+// PathFromConfig returns the path of the Calibre metadata.db: CALIBRE_LIBRARY_DB
+// when set, otherwise metadata.db next to the Calibre-Web app.db
+func PathFromConfig(cfg *config.Config) string {
+	if cfg.CalibreLibraryDB != "" {
+		return cfg.CalibreLibraryDB
+	}
+	if cfg.CWADBPath != "" {
+		return filepath.Join(filepath.Dir(cfg.CWADBPath), "metadata.db")
+	}
+	return ""
+}
+
+// Enabled reports whether a library is configured
+func (l *Library) Enabled() bool {
+	return l.path != ""
+}
+
+// Lookup returns the library book matching one of the ISBNs in the book's
+// metadata or, failing that, its title and author. It returns nil when the
+// book is not in the library.
+func (l *Library) Lookup(book *models.BookInfo) (*Match, error) {
+	if !l.Enabled() {
+		return nil, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.refresh(); err != nil {
+		return nil, err
+	}
+
+	for _, isbn := range bookISBNs(book) {
+		if e, exists := l.byISBN[isbn]; exists {
+			return e.match(MatchISBN), nil
+		}
+	}
+
+	title := normalizeTitle(book.Title)
+	if title == "" || book.Author == nil {
+		return nil, nil
+	}
+	for _, e := range l.byTitle[title] {
+		if authorsMatch(*book.Author, e.authors) {
+			return e.match(MatchTitleAuthor), nil
+		}
+	}
+	return nil, nil
+}
+
+// match describes the entry as a Match
+func (e *entry) match(matchedBy string) *Match {
+	return &Match{CalibreID: e.id, Title: e.title, Authors: e.authors, MatchedBy: matchedBy}
+}
+
+// refresh reads the library again when the database file changed. Callers must hold l.mu.
+func (l *Library) refresh() error {
+	info, err := os.Stat(l.path)
+	if err != nil {
+		return fmt.Errorf("failed to open Calibre library: %w", err)
+	}
+	if l.byTitle != nil && info.ModTime().Equal(l.modTime) && info.Size() == l.size {
+		return nil
+	}
+
+	byISBN, byTitle, err := load(l.path)
+	if err != nil {
+		return err
+	}
+	l.byISBN = byISBN
+	l.byTitle = byTitle
+	l.modTime = info.ModTime()
+	l.size = info.Size()
+	return nil
+}
+
+// load reads the books, authors and ISBNs of a Calibre metadata.db
+func load(path string) (map[string]*entry, map[string][]*entry, error) {
+	dbURI := fmt.Sprintf("file:%s?mode=ro&_busy_timeout=5000", path)
+	db, err := sql.Open("sqlite3", dbURI)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open Calibre library: %w", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT b.id, b.title, COALESCE(a.name, '')
+		FROM books b
+		LEFT JOIN books_authors_link l ON l.book = b.id
+		LEFT JOIN authors a ON a.id = l.author
+		ORDER BY b.id, l.id`)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read Calibre library: %w", err)
+	}
+	defer rows.Close()
+
+	books := make(map[int64]*entry)
+	byTitle := make(map[string][]*entry)
+	for rows.Next() {
+		var (
+			id     int64
+			title  string
+			author string
+		)
+		if err := rows.Scan(&id, &title, &author); err != nil {
+			return nil, nil, fmt.Errorf("failed to read Calibre library: %w", err)
+		}
+
+		e, exists := books[id]
+		if !exists {
+			e = &entry{id: id, title: title}
+			books[id] = e
+			key := normalizeTitle(title)
+			byTitle[key] = append(byTitle[key], e)
+		}
+		if author != "" {
+			e.authors = append(e.authors, author)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read Calibre library: %w", err)
+	}
+
+	rows, err = db.Query("SELECT book, val FROM identifiers WHERE type = 'isbn'")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read Calibre identifiers: %w", err)
+	}
+	defer rows.Close()
+
+	byISBN := make(map[string]*entry)
+	for rows.Next() {
+		var (
+			id  int64
+			val string
+		)
+		if err := rows.Scan(&id, &val); err != nil {
+			return nil, nil, fmt.Errorf("failed to read Calibre identifiers: %w", err)
+		}
+		if isbn, ok := NormalizeISBN(val); ok && books[id] != nil {
+			byISBN[isbn] = books[id]
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read Calibre identifiers: %w", err)
+	}
+
+	return byISBN, byTitle, nil
+}
+
+// bookISBNs returns the normalized ISBNs found in the book's metadata
+func bookISBNs(book *models.BookInfo) []string {
+	var isbns []string
+	for key, values := range book.Info {
+		if !strings.Contains(strings.ToLower(key), "isbn") {
+			continue
+		}
+		for _, value := range values {
+			for _, candidate := range strings.FieldsFunc(value, func(r rune) bool {
+				return r == ',' || r == ';' || unicode.IsSpace(r)
+			}) {
+				if isbn, ok := NormalizeISBN(candidate); ok {
+					isbns = append(isbns, isbn)
+				}
+			}
+		}
+	}
+	return isbns
+}
+
+// NormalizeISBN strips separators from an ISBN and converts ISBN-10 to
+// ISBN-13, so both forms of the same book compare equal
+func NormalizeISBN(s string) (string, bool) {
+	var digits strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == 'x' || r == 'X':
+			digits.WriteRune('X')
+		case r == '-' || r == ' ':
+		default:
+			return "", false
+		}
+	}
+
+	isbn := digits.String()
+	switch {
+	case len(isbn) == 13 && !strings.Contains(isbn, "X"):
+		return isbn, true
+	case len(isbn) == 10 && !strings.Contains(isbn[:9], "X"):
+		isbn = "978" + isbn[:9]
+		sum := 0
+		for i, r := range isbn {
+			weight := 1
+			if i%2 == 1 {
+				weight = 3
+			}
+			sum += int(r-'0') * weight
+		}
+		return fmt.Sprintf("%s%d", isbn, (10-sum%10)%10), true
+	}
+	return "", false
+}
+
+// normalizeTitle reduces a title to lower case words, without subtitle or
+// series annotations
+func normalizeTitle(title string) string {
+	if i := strings.IndexAny(title, ":(["); i > 0 {
+		title = title[:i]
+	}
+	return strings.Join(words(title), " ")
+}
+
+// words splits s into lower case words of letters and digits
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// authorsMatch reports whether one of the library authors is named in the
+// author string of a search result. Initials are ignored, so "Tolkien, J.R.R."
+// matches "J. R. R. Tolkien".
+func authorsMatch(author string, libraryAuthors []string) bool {
+	named := make(map[string]bool)
+	for _, word := range words(author) {
+		named[word] = true
+	}
+
+	for _, libraryAuthor := range libraryAuthors {
+		matched := false
+		for _, word := range words(libraryAuthor) {
+			if len([]rune(word)) < 2 {
+				continue
+			}
+			if !named[word] {
+				matched = false
+				break
+			}
+			matched = true
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
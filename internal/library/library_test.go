@@ -0,0 +1,167 @@
+package library
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/models"
+)
+
+// newTestLibrary creates a metadata.db with the tables of a Calibre library
+func newTestLibrary(t *testing.T) (string, *sql.DB) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "metadata.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`
+		CREATE TABLE books (id INTEGER PRIMARY KEY, title TEXT NOT NULL);
+		CREATE TABLE authors (id INTEGER PRIMARY KEY, name TEXT NOT NULL);
+		CREATE TABLE books_authors_link (id INTEGER PRIMARY KEY, book INTEGER NOT NULL, author INTEGER NOT NULL);
+		CREATE TABLE identifiers (id INTEGER PRIMARY KEY, book INTEGER NOT NULL, type TEXT NOT NULL, val TEXT NOT NULL);
+		INSERT INTO books VALUES (1, 'Foundation'), (2, 'The Hobbit'), (3, 'Мастер и Маргарита');
+		INSERT INTO authors VALUES (1, 'Isaac Asimov'), (2, 'J. R. R. Tolkien'), (3, 'Михаил Булгаков');
+		INSERT INTO books_authors_link (book, author) VALUES (1, 1), (2, 2), (3, 3);
+		INSERT INTO identifiers (book, type, val) VALUES (1, 'isbn', '0553293354'), (2, 'goodreads', '5907');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, db
+}
+
+func TestNormalizeISBN(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		ok    bool
+	}{
+		{"978-0-553-29335-7", "9780553293357", true},
+		{"0553293354", "9780553293357", true},
+		{"0-8044-2957-X", "9780804429573", true},
+		{"12345", "", false},
+		{"not an isbn", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := NormalizeISBN(tt.input)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("NormalizeISBN(%q) = %q, %v, want %q, %v", tt.input, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLibraryLookup(t *testing.T) {
+	path, _ := newTestLibrary(t)
+	lib := New(path)
+
+	tests := []struct {
+		name      string
+		book      *models.BookInfo
+		wantID    int64
+		matchedBy string
+	}{
+		{
+			name:      "ISBN-13 of an ISBN-10 in the library",
+			book:      &models.BookInfo{Title: "Foundation (Foundation #1)", Info: map[string][]string{"ISBN-13": {"978-0-553-29335-7"}}},
+			wantID:    1,
+			matchedBy: MatchISBN,
+		},
+		{
+			name:      "title and author in another order",
+			book:      &models.BookInfo{Title: "The Hobbit: or There and Back Again", Author: stringPtr("Tolkien, J.R.R.")},
+			wantID:    2,
+			matchedBy: MatchTitleAuthor,
+		},
+		{
+			name:      "non Latin title",
+			book:      &models.BookInfo{Title: "Мастер и Маргарита", Author: stringPtr("Булгаков Михаил")},
+			wantID:    3,
+			matchedBy: MatchTitleAuthor,
+		},
+		{
+			name: "same title by another author",
+			book: &models.BookInfo{Title: "Foundation", Author: stringPtr("Someone Else")},
+		},
+		{
+			name: "title without author",
+			book: &models.BookInfo{Title: "Foundation"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, err := lib.Lookup(tt.book)
+			if err != nil {
+				t.Fatalf("Lookup failed: %v", err)
+			}
+			if tt.wantID == 0 {
+				if match != nil {
+					t.Errorf("Expected no match, got %+v", match)
+				}
+				return
+			}
+			if match == nil || match.CalibreID != tt.wantID || match.MatchedBy != tt.matchedBy {
+				t.Errorf("Lookup() = %+v, want book %d matched by %s", match, tt.wantID, tt.matchedBy)
+			}
+		})
+	}
+}
+
+func TestLibraryReloadsWhenDatabaseChanges(t *testing.T) {
+	path, db := newTestLibrary(t)
+	lib := New(path)
+
+	book := &models.BookInfo{Title: "Dune", Author: stringPtr("Frank Herbert")}
+	if match, err := lib.Lookup(book); err != nil || match != nil {
+		t.Fatalf("Lookup() = %+v, %v, want no match", match, err)
+	}
+
+	_, err := db.Exec(`INSERT INTO books VALUES (4, 'Dune');
+		INSERT INTO authors VALUES (4, 'Frank Herbert');
+		INSERT INTO books_authors_link (book, author) VALUES (4, 4);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Make the change visible even on filesystems with coarse timestamps
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	match, err := lib.Lookup(book)
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if match == nil || match.CalibreID != 4 {
+		t.Errorf("Expected the new book to be found, got %+v", match)
+	}
+}
+
+func TestLibraryDisabledWithoutPath(t *testing.T) {
+	lib := New("")
+	if lib.Enabled() {
+		t.Error("Expected the library to be disabled")
+	}
+	if match, err := lib.Lookup(&models.BookInfo{Title: "Foundation"}); match != nil || err != nil {
+		t.Errorf("Lookup() = %+v, %v, want nil, nil", match, err)
+	}
+}
+
+func TestLibraryMissingDatabase(t *testing.T) {
+	lib := New(filepath.Join(t.TempDir(), "metadata.db"))
+	if _, err := lib.Lookup(&models.BookInfo{Title: "Foundation"}); err == nil {
+		t.Error("Expected an error for a missing library")
+	}
+}
+
+// stringPtr returns a pointer to s
+func stringPtr(s string) *string {
+	return &s
+}
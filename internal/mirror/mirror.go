@@ -0,0 +1,267 @@
+package mirror
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/config"
+)
+
+// AutoBaseURL is the AA_BASE_URL value that enables automatic mirror selection
+const AutoBaseURL = "auto"
+
+// FailureThreshold is the number of consecutive failures after which the
+// active mirror is switched
+const FailureThreshold = 3
+
+// DefaultMirrors are the Anna's Archive mirrors tried when AA_BASE_URL is "auto"
+var DefaultMirrors = []string{
+	"https://annas-archive.org",
+	"https://annas-archive.se",
+	"https://annas-archive.li",
+}
+
+// Mirror is the health state of a single mirror
+type Mirror struct {
+	URL         string    `json:"url"`
+	Healthy     bool      `json:"healthy"`
+	Failures    int       `json:"failures"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastChecked time.Time `json:"last_checked,omitempty"`
+}
+
+// Status is a snapshot of the mirror manager state
+type Status struct {
+	Active  string   `json:"active"`
+	Mirrors []Mirror `json:"mirrors"`
+}
+
+// Manager keeps track of the available Anna's Archive mirrors and which one
+// is currently in use
+type Manager struct {
+	mu      sync.RWMutex
+	mirrors []*Mirror
+	active  int
+}
+
+// NewManager creates a manager for the given mirror URLs, using the first one
+// until told otherwise
+func NewManager(urls []string) *Manager {
+	m := &Manager{}
+	for _, u := range urls {
+		m.mirrors = append(m.mirrors, &Mirror{URL: u, Healthy: true})
+	}
+	return m
+}
+
+// Candidates returns the mirror URLs for the configuration. With "auto" the
+// default mirrors are used, otherwise the configured base URL comes first.
+// Additional URLs are always appended.
+func Candidates(cfg *config.Config) []string {
+	var urls []string
+	if cfg.AABaseURL == AutoBaseURL {
+		urls = append(urls, DefaultMirrors...)
+	} else {
+		urls = append(urls, strings.TrimRight(strings.TrimSpace(cfg.AABaseURL), "/"))
+	}
+
+	for _, u := range strings.Split(cfg.AAAdditionalURLs, ",") {
+		u = strings.TrimRight(strings.TrimSpace(u), "/")
+		if u != "" {
+			urls = append(urls, u)
+		}
+	}
+
+	// Drop duplicates while keeping the order
+	seen := make(map[string]bool)
+	unique := urls[:0]
+	for _, u := range urls {
+		if !seen[u] {
+			seen[u] = true
+			unique = append(unique, u)
+		}
+	}
+	return unique
+}
+
+// Active returns the URL of the mirror currently in use
+func (m *Manager) Active() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.mirrors) == 0 {
+		return ""
+	}
+	return m.mirrors[m.active].URL
+}
+
+// URLs returns all mirror URLs in order of preference
+func (m *Manager) URLs() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	urls := make([]string, len(m.mirrors))
+	for i, mirror := range m.mirrors {
+		urls[i] = mirror.URL
+	}
+	return urls
+}
+
+// SetProbeResult records the outcome of a health probe. A nil error marks
+// the mirror as healthy.
+func (m *Manager) SetProbeResult(mirrorURL string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mirror := m.find(mirrorURL)
+	if mirror == nil {
+		return
+	}
+
+	mirror.LastChecked = time.Now()
+	if err != nil {
+		mirror.Healthy = false
+		mirror.LastError = err.Error()
+		return
+	}
+	mirror.Healthy = true
+	mirror.Failures = 0
+	mirror.LastError = ""
+}
+
+// SelectFirstHealthy activates the first healthy mirror. The current mirror
+// is kept if none is healthy. Returns the active mirror.
+func (m *Manager) SelectFirstHealthy() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, mirror := range m.mirrors {
+		if mirror.Healthy {
+			m.active = i
+			break
+		}
+	}
+	if len(m.mirrors) == 0 {
+		return ""
+	}
+	return m.mirrors[m.active].URL
+}
+
+// ReportSuccess records a successful request. URLs that do not belong to a
+// known mirror are ignored.
+func (m *Manager) ReportSuccess(urlStr string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if mirror := m.match(urlStr); mirror != nil {
+		mirror.Healthy = true
+		mirror.Failures = 0
+		mirror.LastError = ""
+	}
+}
+
+// ReportFailure records a connection failure or server error for a request.
+// After FailureThreshold consecutive failures of the active mirror, the next
+// healthy mirror is activated. Returns true if the active mirror changed.
+func (m *Manager) ReportFailure(urlStr string, err error) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mirror := m.match(urlStr)
+	if mirror == nil {
+		return false
+	}
+
+	mirror.Failures++
+	if err != nil {
+		mirror.LastError = err.Error()
+	}
+	if mirror.Failures < FailureThreshold {
+		return false
+	}
+	mirror.Healthy = false
+
+	if mirror != m.mirrors[m.active] {
+		return false
+	}
+
+	// Prefer the next healthy mirror, otherwise just move on to the next one
+	next := (m.active + 1) % len(m.mirrors)
+	for i := 1; i < len(m.mirrors); i++ {
+		candidate := (m.active + i) % len(m.mirrors)
+		if m.mirrors[candidate].Healthy {
+			next = candidate
+			break
+		}
+	}
+	if next == m.active {
+		return false
+	}
+
+	m.active = next
+	m.mirrors[next].Failures = 0
+	return true
+}
+
+// Rewrite points a URL on any known mirror to the active mirror
+func (m *Manager) Rewrite(urlStr string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.mirrors) == 0 {
+		return urlStr
+	}
+
+	active := m.mirrors[m.active].URL
+	for _, mirror := range m.mirrors {
+		if mirror.URL != "" && mirror.URL != active && hasBase(urlStr, mirror.URL) {
+			return active + strings.TrimPrefix(urlStr, mirror.URL)
+		}
+	}
+	return urlStr
+}
+
+// Status returns a snapshot of all mirrors and the active one
+func (m *Manager) Status() Status {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	status := Status{Mirrors: make([]Mirror, len(m.mirrors))}
+	for i, mirror := range m.mirrors {
+		status.Mirrors[i] = *mirror
+	}
+	if len(m.mirrors) > 0 {
+		status.Active = m.mirrors[m.active].URL
+	}
+	return status
+}
+
+// find returns the mirror with the given URL
+func (m *Manager) find(mirrorURL string) *Mirror {
+	for _, mirror := range m.mirrors {
+		if mirror.URL == mirrorURL {
+			return mirror
+		}
+	}
+	return nil
+}
+
+// match returns the mirror a URL belongs to
+func (m *Manager) match(urlStr string) *Mirror {
+	for _, mirror := range m.mirrors {
+		if mirror.URL != "" && hasBase(urlStr, mirror.URL) {
+			return mirror
+		}
+	}
+	return nil
+}
+
+// hasBase reports whether urlStr is baseURL itself or a path below it
+func hasBase(urlStr, baseURL string) bool {
+	if !strings.HasPrefix(urlStr, baseURL) {
+		return false
+	}
+	rest := urlStr[len(baseURL):]
+	return rest == "" || strings.HasPrefix(rest, "/") || strings.HasPrefix(rest, "?")
+}
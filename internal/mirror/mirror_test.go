@@ -0,0 +1,94 @@
+package mirror
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/config"
+)
+
+func TestCandidates(t *testing.T) {
+	cfg := &config.Config{
+		AABaseURL:        "auto",
+		AAAdditionalURLs: " https://extra.example.com/ ,,https://annas-archive.se",
+	}
+
+	urls := Candidates(cfg)
+	expected := append(append([]string{}, DefaultMirrors...), "https://extra.example.com")
+	if len(urls) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, urls)
+	}
+	for i := range expected {
+		if urls[i] != expected[i] {
+			t.Errorf("Candidate %d: expected %s, got %s", i, expected[i], urls[i])
+		}
+	}
+
+	cfg.AABaseURL = "https://custom.example.com/"
+	cfg.AAAdditionalURLs = ""
+	if urls := Candidates(cfg); len(urls) != 1 || urls[0] != "https://custom.example.com" {
+		t.Errorf("Expected only the configured base URL, got %v", urls)
+	}
+}
+
+func TestManagerSelectFirstHealthy(t *testing.T) {
+	m := NewManager([]string{"https://a.example", "https://b.example", "https://c.example"})
+
+	m.SetProbeResult("https://a.example", errors.New("connection refused"))
+	m.SetProbeResult("https://b.example", nil)
+	m.SetProbeResult("https://c.example", nil)
+
+	if active := m.SelectFirstHealthy(); active != "https://b.example" {
+		t.Errorf("Expected https://b.example, got %s", active)
+	}
+
+	status := m.Status()
+	if status.Mirrors[0].Healthy || status.Mirrors[0].LastError != "connection refused" {
+		t.Errorf("Expected first mirror to be unhealthy, got %+v", status.Mirrors[0])
+	}
+}
+
+func TestManagerFailover(t *testing.T) {
+	m := NewManager([]string{"https://a.example", "https://b.example"})
+	pageURL := "https://a.example/md5/abc"
+
+	for i := 1; i < FailureThreshold; i++ {
+		if m.ReportFailure(pageURL, errors.New("HTTP 502")) {
+			t.Fatalf("Switched mirror after %d failures", i)
+		}
+	}
+
+	// A success resets the failure count
+	m.ReportSuccess(pageURL)
+	for i := 1; i < FailureThreshold; i++ {
+		m.ReportFailure(pageURL, errors.New("HTTP 502"))
+	}
+	if m.Active() != "https://a.example" {
+		t.Fatalf("Expected mirror to be kept, got %s", m.Active())
+	}
+
+	if !m.ReportFailure(pageURL, errors.New("HTTP 502")) {
+		t.Fatal("Expected mirror switch")
+	}
+	if m.Active() != "https://b.example" {
+		t.Errorf("Expected https://b.example, got %s", m.Active())
+	}
+
+	if got := m.Rewrite(pageURL); got != "https://b.example/md5/abc" {
+		t.Errorf("Expected rewritten URL, got %s", got)
+	}
+	if got := m.Rewrite("https://other.example/file"); got != "https://other.example/file" {
+		t.Errorf("Unrelated URLs should not be rewritten, got %s", got)
+	}
+}
+
+func TestManagerIgnoresUnknownURLs(t *testing.T) {
+	m := NewManager([]string{"https://a.example", "https://b.example"})
+
+	for i := 0; i < FailureThreshold; i++ {
+		m.ReportFailure("https://a.example.evil/page", errors.New("timeout"))
+	}
+	if m.Active() != "https://a.example" {
+		t.Errorf("Failures of unrelated hosts should not switch mirrors, got %s", m.Active())
+	}
+}
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType identifies what changed in the queue
+type EventType string
+
+const (
+	// EventAdded is emitted when a book is added to the queue
+	EventAdded EventType = "added"
+	// EventStatus is emitted when the status of a book changes, with the updated book
+	EventStatus EventType = "status"
+	// EventProgress is emitted when the download progress of a book changes
+	EventProgress EventType = "progress"
+	// EventWaiting is emitted when a book starts or stops waiting for a partner server countdown
+	EventWaiting EventType = "waiting"
+	// EventPriority is emitted when the priority of a book changes
+	EventPriority EventType = "priority"
+	// EventRemoved is emitted when a book is removed from the queue
+	EventRemoved EventType = "removed"
+)
+
+// DefaultEventBuffer is the number of events buffered per subscriber
+const DefaultEventBuffer = 64
+
+// Event describes a single change in the queue
+type Event struct {
+	ID          uint64      `json:"id"`
+	Type        EventType   `json:"type"`
+	BookID      string      `json:"book_id"`
+	Status      QueueStatus `json:"status,omitempty"`
+	Progress    *float64    `json:"progress,omitempty"`
+	Priority    *int        `json:"priority,omitempty"`
+	WaitSeconds *int        `json:"wait_seconds,omitempty"`
+	Book        *BookInfo   `json:"book,omitempty"`
+	Time        time.Time   `json:"time"`
+}
+
+// EventBus fans queue events out to subscribers. Publishing never blocks:
+// a subscriber that falls behind is dropped and its channel closed, so it
+// can reconnect and start over from a fresh snapshot.
+type EventBus struct {
+	mu          sync.Mutex
+	nextID      uint64
+	subscribers map[chan Event]struct{}
+}
+
+// NewEventBus creates a new EventBus instance
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// Subscribe registers a subscriber with room for buffer pending events. The
+// returned function unsubscribes; it is safe to call more than once.
+func (b *EventBus) Subscribe(buffer int) (<-chan Event, func()) {
+	if buffer <= 0 {
+		buffer = DefaultEventBuffer
+	}
+	ch := make(chan Event, buffer)
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	unsubscribe := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, exists := b.subscribers[ch]; exists {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, unsubscribe
+}
+
+// Publish sends an event to all subscribers, dropping those whose buffer is full
+func (b *EventBus) Publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	event.ID = b.nextID
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// Too slow, let the subscriber catch up with a new snapshot
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// SubscriberCount returns the number of active subscribers
+func (b *EventBus) SubscriberCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.subscribers)
+}
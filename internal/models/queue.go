@@ -2,6 +2,8 @@ package models
 
 import (
 	"container/heap"
+	"context"
+	"sort"
 	"sync"
 	"time"
 )
@@ -18,6 +20,43 @@ const (
 	StatusCancelled   QueueStatus = "cancelled"
 )
 
+// SubState gives more detail about what a downloading book is doing
+type SubState string
+
+const (
+	// SubStateWaiting means the download waits for a partner server countdown
+	SubStateWaiting SubState = "waiting"
+)
+
+// DownloadAttempt records why a download attempt failed
+type DownloadAttempt struct {
+	Attempt   int       `json:"attempt"`
+	Error     string    `json:"error"`
+	Transient bool      `json:"transient"`
+	Time      time.Time `json:"time"`
+}
+
+// ConversionStatus is the outcome of converting a downloaded book
+type ConversionStatus string
+
+const (
+	// ConversionConverted means the converted file was ingested instead of the original
+	ConversionConverted ConversionStatus = "converted"
+	// ConversionFailed means the conversion failed and the original was ingested
+	ConversionFailed ConversionStatus = "failed"
+)
+
+// Conversion records the conversion of a downloaded book to another format
+type Conversion struct {
+	Status ConversionStatus `json:"status"`
+	From   string           `json:"from"`
+	To     string           `json:"to"`
+	Error  string           `json:"error,omitempty"`
+	Time   time.Time        `json:"time"`
+	// OutputMD5 is the hex encoded MD5 of the converted file that was ingested
+	OutputMD5 string `json:"output_md5,omitempty"`
+}
+
 // BookInfo represents information about a book
 type BookInfo struct {
 	ID           string              `json:"id"`
@@ -32,8 +71,16 @@ type BookInfo struct {
 	Info         map[string][]string `json:"info,omitempty"`
 	DownloadURLs []string            `json:"download_urls,omitempty"`
 	DownloadPath *string             `json:"download_path,omitempty"`
+	MD5          string              `json:"md5,omitempty"`
 	Priority     int                 `json:"priority"`
 	Progress     *float64            `json:"progress,omitempty"`
+	SubState     SubState            `json:"sub_state,omitempty"`
+	WaitSeconds  int                 `json:"wait_seconds,omitempty"`
+	Attempts     []DownloadAttempt   `json:"attempts,omitempty"`
+	RetryAt      *time.Time          `json:"retry_at,omitempty"`
+	Conversion   *Conversion         `json:"conversion,omitempty"`
+	InLibrary    bool                `json:"in_library,omitempty"`
+	RequestedBy  string              `json:"requested_by,omitempty"`
 }
 
 // SearchFilters represents search filter criteria
@@ -52,7 +99,8 @@ type QueueItem struct {
 	BookID    string
 	Priority  int
 	AddedTime time.Time
-	Index     int // index in the heap
+	NotBefore time.Time // earliest time the item may be picked, zero for now
+	Index     int       // index in the heap
 }
 
 // PriorityQueue implements a priority queue for QueueItems
@@ -99,9 +147,14 @@ type BookQueue struct {
 	status            map[string]QueueStatus
 	bookData          map[string]*BookInfo
 	statusTimestamps  map[string]time.Time
+	addedTimes        map[string]time.Time
 	statusTimeout     time.Duration
 	cancelFlags       map[string]chan struct{}
-	activeDownloads   map[string]bool
+	activeDownloads   map[string]chan struct{}
+	store             QueueStore
+	storeErrorHandler func(bookID string, err error)
+	events            *EventBus
+	wake              chan struct{} // closed and replaced when a book may have become available
 }
 
 // NewBookQueue creates a new BookQueue instance
@@ -114,22 +167,106 @@ func NewBookQueue(statusTimeout time.Duration) *BookQueue {
 		status:           make(map[string]QueueStatus),
 		bookData:         make(map[string]*BookInfo),
 		statusTimestamps: make(map[string]time.Time),
+		addedTimes:       make(map[string]time.Time),
 		statusTimeout:    statusTimeout,
 		cancelFlags:      make(map[string]chan struct{}),
-		activeDownloads:  make(map[string]bool),
+		activeDownloads:  make(map[string]chan struct{}),
+		events:           NewEventBus(),
+		wake:             make(chan struct{}),
 	}
 }
 
-// Add adds a book to the queue with the specified priority
-func (bq *BookQueue) Add(bookID string, bookData *BookInfo, priority int) {
+// NewBookQueueWithStore creates a BookQueue backed by a persistent store and
+// restores its previous state. Books that were downloading when the queue was
+// last persisted are queued again.
+func NewBookQueueWithStore(statusTimeout time.Duration, store QueueStore) (*BookQueue, error) {
+	bq := NewBookQueue(statusTimeout)
+
+	entries, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.Book == nil {
+			continue
+		}
+
+		status := entry.Status
+		if status == StatusDownloading {
+			status = StatusQueued
+			entry.Book.Progress = nil
+			entry.StatusTime = time.Now()
+			if err := store.UpdateStatus(entry.BookID, status, entry.StatusTime); err != nil {
+				return nil, err
+			}
+		}
+
+		entry.Book.Priority = entry.Priority
+		bq.bookData[entry.BookID] = entry.Book
+		bq.status[entry.BookID] = status
+		bq.statusTimestamps[entry.BookID] = entry.StatusTime
+		bq.addedTimes[entry.BookID] = entry.AddedTime
+
+		if status == StatusQueued {
+			heap.Push(bq.queue, &QueueItem{
+				BookID:    entry.BookID,
+				Priority:  entry.Priority,
+				AddedTime: entry.AddedTime,
+			})
+		}
+	}
+
+	bq.store = store
+	return bq, nil
+}
+
+// SetStoreErrorHandler sets a function called when persisting a change fails
+func (bq *BookQueue) SetStoreErrorHandler(handler func(bookID string, err error)) {
+	bq.mu.Lock()
+	defer bq.mu.Unlock()
+
+	bq.storeErrorHandler = handler
+}
+
+// Close closes the persistent store, if any
+func (bq *BookQueue) Close() error {
+	bq.mu.Lock()
+	defer bq.mu.Unlock()
+
+	if bq.store == nil {
+		return nil
+	}
+	err := bq.store.Close()
+	bq.store = nil
+	return err
+}
+
+// Subscribe registers a listener for queue events. The returned channel is
+// closed when the listener falls behind or unsubscribes.
+func (bq *BookQueue) Subscribe(buffer int) (<-chan Event, func()) {
+	return bq.events.Subscribe(buffer)
+}
+
+// persist runs fn against the store, if any, and reports failures
+func (bq *BookQueue) persist(bookID string, fn func(store QueueStore) error) {
+	if bq.store == nil {
+		return
+	}
+	if err := fn(bq.store); err != nil && bq.storeErrorHandler != nil {
+		bq.storeErrorHandler(bookID, err)
+	}
+}
+
+// Add adds a book to the queue with the specified priority.
+// Returns false if the book is already queued or being downloaded.
+func (bq *BookQueue) Add(bookID string, bookData *BookInfo, priority int) bool {
 	bq.mu.Lock()
 	defer bq.mu.Unlock()
 
 	// Don't add if already exists and not in error/done state
-	if status, exists := bq.status[bookID]; exists {
-		if status != StatusError && status != StatusDone && status != StatusCancelled {
-			return
-		}
+	if bq.isPending(bookID) {
+		return false
 	}
 
 	bookData.Priority = priority
@@ -141,14 +278,102 @@ func (bq *BookQueue) Add(bookID string, bookData *BookInfo, priority int) {
 	
 	heap.Push(bq.queue, item)
 	bq.bookData[bookID] = bookData
-	bq.updateStatus(bookID, StatusQueued)
+	bq.status[bookID] = StatusQueued
+	bq.statusTimestamps[bookID] = item.AddedTime
+	bq.addedTimes[bookID] = item.AddedTime
+	bq.persist(bookID, func(store QueueStore) error {
+		return store.Save(QueueEntry{
+			BookID:     bookID,
+			Book:       bookData,
+			Status:     StatusQueued,
+			Priority:   priority,
+			AddedTime:  item.AddedTime,
+			StatusTime: item.AddedTime,
+		})
+	})
+	book := *bookData
+	bq.events.Publish(Event{Type: EventAdded, BookID: bookID, Status: StatusQueued, Book: &book, Time: item.AddedTime})
+	bq.notify()
+	return true
+}
+
+// IsPending reports whether a book is queued, downloading or available,
+// in which case it cannot be added again
+func (bq *BookQueue) IsPending(bookID string) bool {
+	bq.mu.RLock()
+	defer bq.mu.RUnlock()
+
+	return bq.isPending(bookID)
 }
 
-// GetNext retrieves the next book from the queue
+// isPending is the lock-free implementation of IsPending
+func (bq *BookQueue) isPending(bookID string) bool {
+	status, exists := bq.status[bookID]
+	if !exists {
+		return false
+	}
+	return status != StatusError && status != StatusDone && status != StatusCancelled
+}
+
+// GetNext retrieves the next book from the queue without waiting
 func (bq *BookQueue) GetNext() (string, chan struct{}, bool) {
 	bq.mu.Lock()
 	defer bq.mu.Unlock()
 
+	bookID, cancelChan, ok, _ := bq.next()
+	return bookID, cancelChan, ok
+}
+
+// Next waits until a book is available and retrieves it. It wakes up as soon
+// as a book is added or reprioritized, or a delayed retry becomes due, and
+// returns ctx.Err() once ctx is done.
+func (bq *BookQueue) Next(ctx context.Context) (string, chan struct{}, error) {
+	for {
+		// Stopped workers must not take another book
+		if err := ctx.Err(); err != nil {
+			return "", nil, err
+		}
+
+		bq.mu.Lock()
+		bookID, cancelChan, ok, retryAt := bq.next()
+		wake := bq.wake
+		bq.mu.Unlock()
+
+		if ok {
+			return bookID, cancelChan, nil
+		}
+
+		var timer *time.Timer
+		var due <-chan time.Time
+		if !retryAt.IsZero() {
+			timer = time.NewTimer(time.Until(retryAt))
+			due = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-wake:
+		case <-due:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// next is the lock-free implementation of GetNext. When no book is
+// available it returns the time the earliest delayed retry becomes due, or
+// the zero time if there is none.
+func (bq *BookQueue) next() (string, chan struct{}, bool, time.Time) {
+	// Put back items waiting for a retry once done
+	now := time.Now()
+	var deferred []*QueueItem
+	defer func() {
+		for _, item := range deferred {
+			heap.Push(bq.queue, item)
+		}
+	}()
+
 	// Loop until we find a non-cancelled item or the queue is empty
 	for bq.queue.Len() > 0 {
 		item := heap.Pop(bq.queue).(*QueueItem)
@@ -160,22 +385,66 @@ func (bq *BookQueue) GetNext() (string, chan struct{}, bool) {
 			continue
 		}
 
+		// Skip items that are not due for a retry yet
+		if item.NotBefore.After(now) {
+			deferred = append(deferred, item)
+			continue
+		}
+		if book, exists := bq.bookData[bookID]; exists {
+			book.RetryAt = nil
+		}
+
 		// Create cancellation channel for this download
 		cancelChan := make(chan struct{})
 		bq.cancelFlags[bookID] = cancelChan
-		bq.activeDownloads[bookID] = true
+		bq.activeDownloads[bookID] = cancelChan
 
-		return bookID, cancelChan, true
+		return bookID, cancelChan, true, time.Time{}
 	}
 
-	// Queue is empty or all items were cancelled
-	return "", nil, false
+	// Queue is empty, all items were cancelled or are waiting for a retry
+	var retryAt time.Time
+	for _, item := range deferred {
+		if retryAt.IsZero() || item.NotBefore.Before(retryAt) {
+			retryAt = item.NotBefore
+		}
+	}
+	return "", nil, false, retryAt
+}
+
+// notify wakes up everyone waiting in Next
+func (bq *BookQueue) notify() {
+	close(bq.wake)
+	bq.wake = make(chan struct{})
 }
 
 // updateStatus is an internal method to update status and timestamp
 func (bq *BookQueue) updateStatus(bookID string, status QueueStatus) {
+	now := time.Now()
 	bq.status[bookID] = status
-	bq.statusTimestamps[bookID] = time.Now()
+	bq.statusTimestamps[bookID] = now
+	bq.persist(bookID, func(store QueueStore) error {
+		return store.UpdateStatus(bookID, status, now)
+	})
+	event := Event{Type: EventStatus, BookID: bookID, Status: status, Time: now}
+	if book, exists := bq.bookData[bookID]; exists {
+		// Clients get fields set along with the status, like the download path
+		book := *book
+		event.Book = &book
+	}
+	bq.events.Publish(event)
+}
+
+// removeBook is an internal method to drop all tracking of a book
+func (bq *BookQueue) removeBook(bookID string) {
+	delete(bq.status, bookID)
+	delete(bq.statusTimestamps, bookID)
+	delete(bq.addedTimes, bookID)
+	delete(bq.bookData, bookID)
+	bq.persist(bookID, func(store QueueStore) error {
+		return store.Delete(bookID)
+	})
+	bq.events.Publish(Event{Type: EventRemoved, BookID: bookID})
 }
 
 // UpdateStatus updates the status of a book in the queue
@@ -187,14 +456,129 @@ func (bq *BookQueue) UpdateStatus(bookID string, status QueueStatus) {
 
 	// Clean up active download tracking when finished
 	if status == StatusAvailable || status == StatusError || status == StatusDone || status == StatusCancelled {
-		delete(bq.activeDownloads, bookID)
-		if ch, exists := bq.cancelFlags[bookID]; exists {
-			close(ch)
-			delete(bq.cancelFlags, bookID)
-		}
+		bq.releaseDownload(bookID)
+	}
+}
+
+// FinishDownload sets the final status of the download attempt cancelChan
+// was handed out for. Returns false and changes nothing if the attempt is no
+// longer the current one.
+func (bq *BookQueue) FinishDownload(bookID string, cancelChan chan struct{}, status QueueStatus) bool {
+	bq.mu.Lock()
+	defer bq.mu.Unlock()
+
+	if bq.activeDownloads[bookID] != cancelChan {
+		return false
+	}
+	bq.updateStatus(bookID, status)
+	bq.releaseDownload(bookID)
+	return true
+}
+
+// releaseDownload is an internal method to stop tracking an active download
+func (bq *BookQueue) releaseDownload(bookID string) {
+	delete(bq.activeDownloads, bookID)
+	if ch, exists := bq.cancelFlags[bookID]; exists {
+		close(ch)
+		delete(bq.cancelFlags, bookID)
 	}
 }
 
+// RecordAttempt adds a failed download attempt to the history of a book and
+// returns the number of attempts made so far
+func (bq *BookQueue) RecordAttempt(bookID string, err string, transient bool) int {
+	bq.mu.Lock()
+	defer bq.mu.Unlock()
+
+	book, exists := bq.bookData[bookID]
+	if !exists {
+		return 0
+	}
+
+	book.Attempts = append(book.Attempts, DownloadAttempt{
+		Attempt:   len(book.Attempts) + 1,
+		Error:     err,
+		Transient: transient,
+		Time:      time.Now(),
+	})
+	attempts := append([]DownloadAttempt(nil), book.Attempts...)
+	bq.persist(bookID, func(store QueueStore) error {
+		return store.UpdateAttempts(bookID, attempts)
+	})
+	return len(attempts)
+}
+
+// RequeueDownload puts a book back in the queue after the download attempt
+// cancelChan was handed out for failed. It is not picked up again before
+// delay has passed. Returns false if the attempt is no longer the current one.
+func (bq *BookQueue) RequeueDownload(bookID string, cancelChan chan struct{}, delay time.Duration) bool {
+	bq.mu.Lock()
+	defer bq.mu.Unlock()
+
+	if bq.activeDownloads[bookID] != cancelChan {
+		return false
+	}
+	bq.releaseDownload(bookID)
+	return bq.requeue(bookID, delay)
+}
+
+// requeue puts a book back in the queue. Books that are queued or still held
+// by a worker are left alone.
+func (bq *BookQueue) requeue(bookID string, delay time.Duration) bool {
+	book, exists := bq.bookData[bookID]
+	if !exists || bq.status[bookID] == StatusQueued {
+		return false
+	}
+	if _, active := bq.activeDownloads[bookID]; active {
+		return false
+	}
+
+	now := time.Now()
+	item := &QueueItem{
+		BookID:    bookID,
+		Priority:  book.Priority,
+		AddedTime: now,
+	}
+	book.Progress = nil
+	book.SubState = ""
+	book.WaitSeconds = 0
+	book.RetryAt = nil
+	if delay > 0 {
+		retryAt := now.Add(delay)
+		item.NotBefore = retryAt
+		book.RetryAt = &retryAt
+	}
+
+	heap.Push(bq.queue, item)
+	bq.updateStatus(bookID, StatusQueued)
+	bq.notify()
+	return true
+}
+
+// Retry queues a failed or cancelled book again right away and clears its
+// attempt history. Returns false if the book is not in one of those states,
+// or if a cancelled download has not stopped yet.
+func (bq *BookQueue) Retry(bookID string) bool {
+	bq.mu.Lock()
+	defer bq.mu.Unlock()
+
+	status, exists := bq.status[bookID]
+	if !exists || (status != StatusError && status != StatusCancelled) {
+		return false
+	}
+	if _, active := bq.activeDownloads[bookID]; active {
+		return false
+	}
+
+	if book, exists := bq.bookData[bookID]; exists && len(book.Attempts) > 0 {
+		book.Attempts = nil
+		bq.persist(bookID, func(store QueueStore) error {
+			return store.UpdateAttempts(bookID, nil)
+		})
+	}
+	return bq.requeue(bookID, 0)
+}
+
 // UpdateDownloadPath updates the download path of a book
 func (bq *BookQueue) UpdateDownloadPath(bookID string, downloadPath string) {
 	bq.mu.Lock()
@@ -202,16 +586,68 @@ func (bq *BookQueue) UpdateDownloadPath(bookID string, downloadPath string) {
 
 	if book, exists := bq.bookData[bookID]; exists {
 		book.DownloadPath = &downloadPath
+		bq.persist(bookID, func(store QueueStore) error {
+			return store.UpdateDownloadPath(bookID, downloadPath)
+		})
+	}
+}
+
+// UpdateMD5 records the MD5 of the downloaded file of a book
+func (bq *BookQueue) UpdateMD5(bookID string, md5 string) {
+	bq.mu.Lock()
+	defer bq.mu.Unlock()
+
+	if book, exists := bq.bookData[bookID]; exists {
+		book.MD5 = md5
+		bq.persist(bookID, func(store QueueStore) error {
+			return store.UpdateMD5(bookID, md5)
+		})
 	}
 }
 
-// UpdateProgress updates the download progress of a book
+// UpdateConversion records the conversion of a downloaded book
+func (bq *BookQueue) UpdateConversion(bookID string, conversion Conversion) {
+	bq.mu.Lock()
+	defer bq.mu.Unlock()
+
+	if book, exists := bq.bookData[bookID]; exists {
+		book.Conversion = &conversion
+		bq.persist(bookID, func(store QueueStore) error {
+			return store.UpdateConversion(bookID, &conversion)
+		})
+	}
+}
+
+// UpdateProgress updates the download progress of a book. An event is only
+// published when the progress crosses a whole percent.
 func (bq *BookQueue) UpdateProgress(bookID string, progress float64) {
 	bq.mu.Lock()
 	defer bq.mu.Unlock()
 
 	if book, exists := bq.bookData[bookID]; exists {
+		changed := book.Progress == nil || int(*book.Progress) != int(progress)
 		book.Progress = &progress
+		if changed {
+			bq.events.Publish(Event{Type: EventProgress, BookID: bookID, Progress: &progress})
+		}
+	}
+}
+
+// UpdateWaiting records the remaining seconds a book waits for a partner
+// server countdown. A value of 0 clears the waiting state.
+func (bq *BookQueue) UpdateWaiting(bookID string, remaining int) {
+	bq.mu.Lock()
+	defer bq.mu.Unlock()
+
+	if book, exists := bq.bookData[bookID]; exists {
+		if remaining > 0 {
+			book.SubState = SubStateWaiting
+			book.WaitSeconds = remaining
+		} else {
+			book.SubState = ""
+			book.WaitSeconds = 0
+		}
+		bq.events.Publish(Event{Type: EventWaiting, BookID: bookID, WaitSeconds: &remaining})
 	}
 }
 
@@ -236,14 +672,27 @@ func (bq *BookQueue) GetStatus() map[QueueStatus]map[string]*BookInfo {
 	return result
 }
 
+// GetBook returns a copy of a tracked book
+func (bq *BookQueue) GetBook(bookID string) (BookInfo, bool) {
+	bq.mu.RLock()
+	defer bq.mu.RUnlock()
+
+	book, exists := bq.bookData[bookID]
+	if !exists {
+		return BookInfo{}, false
+	}
+	return *book, true
+}
+
 // QueueOrderItem represents an item in the queue order
 type QueueOrderItem struct {
-	ID        string      `json:"id"`
-	Title     string      `json:"title"`
-	Author    *string     `json:"author,omitempty"`
-	Priority  int         `json:"priority"`
-	AddedTime time.Time   `json:"added_time"`
-	Status    QueueStatus `json:"status"`
+	ID          string      `json:"id"`
+	Title       string      `json:"title"`
+	Author      *string     `json:"author,omitempty"`
+	Priority    int         `json:"priority"`
+	AddedTime   time.Time   `json:"added_time"`
+	Status      QueueStatus `json:"status"`
+	RequestedBy string      `json:"requested_by,omitempty"`
 }
 
 // GetQueueOrder returns the current queue order
@@ -261,12 +710,13 @@ func (bq *BookQueue) GetQueueOrder() []QueueOrderItem {
 		if book, exists := bq.bookData[item.BookID]; exists {
 			status, _ := bq.status[item.BookID]
 			items = append(items, QueueOrderItem{
-				ID:        item.BookID,
-				Title:     book.Title,
-				Author:    book.Author,
-				Priority:  item.Priority,
-				AddedTime: item.AddedTime,
-				Status:    status,
+				ID:          item.BookID,
+				Title:       book.Title,
+				Author:      book.Author,
+				Priority:    item.Priority,
+				AddedTime:   item.AddedTime,
+				Status:      status,
+				RequestedBy: book.RequestedBy,
 			})
 		}
 	}
@@ -284,7 +734,8 @@ func (bq *BookQueue) CancelDownload(bookID string) bool {
 		return false
 	}
 
-	if currentStatus == StatusDownloading {
+	// A worker may hold the book before it is marked as downloading
+	if _, active := bq.activeDownloads[bookID]; active || currentStatus == StatusDownloading {
 		// Signal active download to stop
 		if cancelChan, exists := bq.cancelFlags[bookID]; exists {
 			close(cancelChan)
@@ -293,7 +744,8 @@ func (bq *BookQueue) CancelDownload(bookID string) bool {
 		bq.updateStatus(bookID, StatusCancelled)
 		return true
 	} else if currentStatus == StatusQueued {
-		// Mark as cancelled
+		// Take it out of the queue, so a retry does not queue it twice
+		bq.removeQueueItem(bookID)
 		bq.updateStatus(bookID, StatusCancelled)
 		return true
 	}
@@ -301,6 +753,20 @@ func (bq *BookQueue) CancelDownload(bookID string) bool {
 	return false
 }
 
+// removeQueueItem removes the queue items of a book. Callers must hold bq.mu.
+func (bq *BookQueue) removeQueueItem(bookID string) {
+	for removed := true; removed; {
+		removed = false
+		for _, item := range *bq.queue {
+			if item.BookID == bookID {
+				heap.Remove(bq.queue, item.Index)
+				removed = true
+				break
+			}
+		}
+	}
+}
+
 // SetPriority changes the priority of a queued book
 func (bq *BookQueue) SetPriority(bookID string, newPriority int) bool {
 	bq.mu.Lock()
@@ -321,6 +787,11 @@ func (bq *BookQueue) SetPriority(bookID string, newPriority int) bool {
 			if book, exists := bq.bookData[bookID]; exists {
 				book.Priority = newPriority
 			}
+			bq.persist(bookID, func(store QueueStore) error {
+				return store.UpdatePriority(bookID, newPriority)
+			})
+			bq.events.Publish(Event{Type: EventPriority, BookID: bookID, Priority: &newPriority})
+			bq.notify()
 			return true
 		}
 	}
@@ -342,15 +813,60 @@ func (bq *BookQueue) ReorderQueue(bookPriorities map[string]int) bool {
 			if book, exists := bq.bookData[item.BookID]; exists {
 				book.Priority = newPriority
 			}
+			bookID := item.BookID
+			bq.persist(bookID, func(store QueueStore) error {
+				return store.UpdatePriority(bookID, newPriority)
+			})
+			bq.events.Publish(Event{Type: EventPriority, BookID: bookID, Priority: &newPriority})
 		}
 	}
 
 	// Re-heapify the queue
 	heap.Init(bq.queue)
+	bq.notify()
 
 	return true
 }
 
+// ActiveRequests counts the books requested by user that are queued or
+// downloading
+func (bq *BookQueue) ActiveRequests(user string) int {
+	bq.mu.RLock()
+	defer bq.mu.RUnlock()
+
+	count := 0
+	for bookID, status := range bq.status {
+		if status != StatusQueued && status != StatusDownloading {
+			continue
+		}
+		if book, exists := bq.bookData[bookID]; exists && book.RequestedBy == user {
+			count++
+		}
+	}
+	return count
+}
+
+// RequestTimes returns when the tracked books of each user were added,
+// oldest first
+func (bq *BookQueue) RequestTimes() map[string][]time.Time {
+	bq.mu.RLock()
+	defer bq.mu.RUnlock()
+
+	times := make(map[string][]time.Time)
+	for bookID, book := range bq.bookData {
+		if book.RequestedBy == "" {
+			continue
+		}
+		if added, exists := bq.addedTimes[bookID]; exists {
+			times[book.RequestedBy] = append(times[book.RequestedBy], added)
+		}
+	}
+	for _, userTimes := range times {
+		sort.Slice(userTimes, func(i, j int) bool { return userTimes[i].Before(userTimes[j]) })
+	}
+	return times
+}
+
 // GetActiveDownloads returns a list of currently active download book IDs
 func (bq *BookQueue) GetActiveDownloads() []string {
 	bq.mu.RLock()
@@ -377,14 +893,8 @@ func (bq *BookQueue) ClearCompleted() int {
 	}
 
 	for _, bookID := range toRemove {
-		delete(bq.status, bookID)
-		delete(bq.statusTimestamps, bookID)
-		delete(bq.bookData, bookID)
-		if ch, exists := bq.cancelFlags[bookID]; exists {
-			close(ch)
-			delete(bq.cancelFlags, bookID)
-		}
-		delete(bq.activeDownloads, bookID)
+		bq.removeBook(bookID)
+		bq.releaseDownload(bookID)
 	}
 
 	return len(toRemove)
@@ -424,9 +934,7 @@ func (bq *BookQueue) Refresh() {
 
 	// Remove stale entries
 	for _, bookID := range toRemove {
-		delete(bq.status, bookID)
-		delete(bq.statusTimestamps, bookID)
-		delete(bq.bookData, bookID)
+		bq.removeBook(bookID)
 	}
 }
 
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"context"
 	"testing"
 	"time"
 )
@@ -42,6 +43,33 @@ func TestBookQueueAdd(t *testing.T) {
 	}
 }
 
+func TestBookQueueAddDuplicate(t *testing.T) {
+	queue := NewBookQueue(1 * time.Hour)
+
+	book := &BookInfo{ID: "test-1", Title: "Test Book"}
+	if !queue.Add("test-1", book, 0) {
+		t.Fatal("Expected first add to succeed")
+	}
+
+	if !queue.IsPending("test-1") {
+		t.Error("Expected book to be pending")
+	}
+
+	if queue.Add("test-1", book, 0) {
+		t.Error("Expected duplicate add to be rejected")
+	}
+
+	queue.UpdateStatus("test-1", StatusError)
+
+	if queue.IsPending("test-1") {
+		t.Error("Expected errored book not to be pending")
+	}
+
+	if !queue.Add("test-1", book, 0) {
+		t.Error("Expected errored book to be re-queued")
+	}
+}
+
 func TestBookQueueGetNext(t *testing.T) {
 	queue := NewBookQueue(1 * time.Hour)
 	
@@ -207,6 +235,27 @@ func TestBookQueueGetActiveDownloads(t *testing.T) {
 	}
 }
 
+func TestBookQueueActiveRequests(t *testing.T) {
+	queue := NewBookQueue(1 * time.Hour)
+
+	queue.Add("a", &BookInfo{ID: "a", RequestedBy: "alice"}, 0)
+	queue.Add("b", &BookInfo{ID: "b", RequestedBy: "alice"}, 0)
+	queue.Add("c", &BookInfo{ID: "c", RequestedBy: "alice"}, 0)
+	queue.Add("d", &BookInfo{ID: "d", RequestedBy: "bob"}, 0)
+	queue.UpdateStatus("b", StatusDownloading)
+	queue.UpdateStatus("c", StatusAvailable)
+
+	if count := queue.ActiveRequests("alice"); count != 2 {
+		t.Errorf("Expected 2 active requests for alice, got %d", count)
+	}
+	if count := queue.ActiveRequests("bob"); count != 1 {
+		t.Errorf("Expected 1 active request for bob, got %d", count)
+	}
+	if count := queue.ActiveRequests("carol"); count != 0 {
+		t.Errorf("Expected no active requests for carol, got %d", count)
+	}
+}
+
 func TestBookQueueClearCompleted(t *testing.T) {
 	queue := NewBookQueue(1 * time.Hour)
 	
@@ -296,3 +345,318 @@ func TestBookQueueGetNextSkipsCancelled(t *testing.T) {
 		t.Errorf("Expected book ID 'test-3', got '%s'", bookID)
 	}
 }
+
+func TestBookQueueUpdateWaiting(t *testing.T) {
+	queue := NewBookQueue(1 * time.Hour)
+
+	book := &BookInfo{ID: "test-1", Title: "Test Book"}
+	queue.Add("test-1", book, 0)
+
+	queue.UpdateWaiting("test-1", 30)
+
+	status := queue.GetStatus()
+	if got := status[StatusQueued]["test-1"]; got.SubState != SubStateWaiting || got.WaitSeconds != 30 {
+		t.Errorf("Expected waiting 30s, got %q %d", got.SubState, got.WaitSeconds)
+	}
+
+	queue.UpdateWaiting("test-1", 0)
+
+	status = queue.GetStatus()
+	if got := status[StatusQueued]["test-1"]; got.SubState != "" || got.WaitSeconds != 0 {
+		t.Errorf("Expected waiting state to be cleared, got %q %d", got.SubState, got.WaitSeconds)
+	}
+}
+
+func TestBookQueueEvents(t *testing.T) {
+	queue := NewBookQueue(1 * time.Hour)
+	events, unsubscribe := queue.Subscribe(16)
+	defer unsubscribe()
+
+	book := &BookInfo{ID: "test-1", Title: "Test Book"}
+	queue.Add("test-1", book, 0)
+	queue.SetPriority("test-1", 5)
+	queue.UpdateStatus("test-1", StatusDownloading)
+	queue.UpdateProgress("test-1", 10.2)
+	queue.UpdateProgress("test-1", 10.8) // same whole percent, no event
+	queue.UpdateProgress("test-1", 11.0)
+	queue.UpdateStatus("test-1", StatusError)
+	queue.ClearCompleted()
+
+	expected := []EventType{EventAdded, EventPriority, EventStatus, EventProgress, EventProgress, EventStatus, EventRemoved}
+	for i, eventType := range expected {
+		select {
+		case event := <-events:
+			if event.Type != eventType {
+				t.Errorf("Event %d: expected type %s, got %s", i, eventType, event.Type)
+			}
+			if event.BookID != "test-1" {
+				t.Errorf("Event %d: expected book test-1, got %s", i, event.BookID)
+			}
+			if event.ID != uint64(i+1) {
+				t.Errorf("Event %d: expected ID %d, got %d", i, i+1, event.ID)
+			}
+		default:
+			t.Fatalf("Expected event %d (%s), got none", i, eventType)
+		}
+	}
+
+	select {
+	case event := <-events:
+		t.Errorf("Unexpected event %+v", event)
+	default:
+	}
+}
+
+func TestEventBusDropsSlowSubscriber(t *testing.T) {
+	bus := NewEventBus()
+	slow, _ := bus.Subscribe(1)
+	fast, unsubscribe := bus.Subscribe(8)
+	defer unsubscribe()
+
+	bus.Publish(Event{Type: EventAdded, BookID: "a"})
+	bus.Publish(Event{Type: EventAdded, BookID: "b"})
+
+	if bus.SubscriberCount() != 1 {
+		t.Errorf("Expected slow subscriber to be dropped, %d subscribers left", bus.SubscriberCount())
+	}
+
+	if event := <-slow; event.BookID != "a" {
+		t.Errorf("Expected buffered event for a, got %s", event.BookID)
+	}
+	if _, ok := <-slow; ok {
+		t.Error("Expected slow subscriber channel to be closed")
+	}
+
+	if len(fast) != 2 {
+		t.Errorf("Expected 2 events for fast subscriber, got %d", len(fast))
+	}
+}
+
+func TestBookQueueRequeueWithDelay(t *testing.T) {
+	queue := NewBookQueue(1 * time.Hour)
+
+	queue.Add("test-1", &BookInfo{ID: "test-1", Title: "Test Book"}, 0)
+	bookID, cancelChan, _ := queue.GetNext()
+	queue.UpdateStatus(bookID, StatusDownloading)
+
+	if attempts := queue.RecordAttempt(bookID, "unexpected status code 503", true); attempts != 1 {
+		t.Errorf("Expected 1 attempt, got %d", attempts)
+	}
+	if !queue.RequeueDownload(bookID, cancelChan, time.Hour) {
+		t.Fatal("Expected book to be requeued")
+	}
+	if queue.RequeueDownload(bookID, cancelChan, time.Hour) {
+		t.Error("Expected a queued book not to be requeued twice")
+	}
+
+	status := queue.GetStatus()
+	book, exists := status[StatusQueued]["test-1"]
+	if !exists {
+		t.Fatal("Expected book to be queued again")
+	}
+	if book.RetryAt == nil || len(book.Attempts) != 1 {
+		t.Errorf("Expected retry time and attempt to be set, got %+v", book)
+	}
+	if len(queue.GetActiveDownloads()) != 0 {
+		t.Error("Expected no active downloads after requeue")
+	}
+
+	// Not due yet
+	if _, _, ok := queue.GetNext(); ok {
+		t.Error("Expected book not to be picked before its retry time")
+	}
+
+	queue.Add("test-2", &BookInfo{ID: "test-2", Title: "Other Book"}, 0)
+	if next, _, ok := queue.GetNext(); !ok || next != "test-2" {
+		t.Errorf("Expected test-2 to be picked while test-1 waits, got %s", next)
+	}
+	if len(queue.GetQueueOrder()) != 1 {
+		t.Error("Expected the waiting book to stay in the queue")
+	}
+}
+
+func TestBookQueueRetry(t *testing.T) {
+	queue := NewBookQueue(1 * time.Hour)
+
+	queue.Add("test-1", &BookInfo{ID: "test-1", Title: "Test Book"}, 0)
+	if queue.Retry("test-1") {
+		t.Error("Expected queued book not to be retried")
+	}
+
+	bookID, _, _ := queue.GetNext()
+	queue.RecordAttempt(bookID, "not found", false)
+	queue.UpdateStatus(bookID, StatusError)
+
+	if !queue.Retry("test-1") {
+		t.Fatal("Expected failed book to be retried")
+	}
+
+	status := queue.GetStatus()
+	book, exists := status[StatusQueued]["test-1"]
+	if !exists {
+		t.Fatal("Expected book to be queued again")
+	}
+	if len(book.Attempts) != 0 || book.RetryAt != nil {
+		t.Errorf("Expected attempts to be cleared, got %+v", book)
+	}
+	if next, _, ok := queue.GetNext(); !ok || next != "test-1" {
+		t.Error("Expected retried book to be picked right away")
+	}
+	if queue.Retry("missing") {
+		t.Error("Expected unknown book not to be retried")
+	}
+}
+
+func TestBookQueueRetryCancelledQueuesOnce(t *testing.T) {
+	queue := NewBookQueue(1 * time.Hour)
+	queue.Add("a", &BookInfo{ID: "a", Title: "Book A"}, 0)
+
+	if !queue.CancelDownload("a") {
+		t.Fatal("Expected the queued book to be cancelled")
+	}
+	if !queue.Retry("a") {
+		t.Fatal("Expected the cancelled book to be retried")
+	}
+
+	if bookID, _, ok := queue.GetNext(); !ok || bookID != "a" {
+		t.Fatalf("Expected book a, got %q, %v", bookID, ok)
+	}
+	if bookID, _, ok := queue.GetNext(); ok {
+		t.Errorf("Expected book a to be handed out once, got %q again", bookID)
+	}
+}
+
+func TestBookQueueRetryWaitsForCancelledWorker(t *testing.T) {
+	queue := NewBookQueue(1 * time.Hour)
+	queue.Add("a", &BookInfo{ID: "a", Title: "Book A"}, 0)
+
+	_, oldChan, _ := queue.GetNext()
+	queue.UpdateStatus("a", StatusDownloading)
+	if !queue.CancelDownload("a") {
+		t.Fatal("Expected the download to be cancelled")
+	}
+	if queue.Retry("a") {
+		t.Fatal("Expected no retry while the worker is still running")
+	}
+
+	// The old worker returns, after which a retry is allowed
+	if !queue.FinishDownload("a", oldChan, StatusCancelled) {
+		t.Fatal("Expected the old worker to finish its attempt")
+	}
+	if !queue.Retry("a") {
+		t.Fatal("Expected the cancelled book to be retried")
+	}
+	_, newChan, ok := queue.GetNext()
+	if !ok {
+		t.Fatal("Expected the retried book to be handed out")
+	}
+	queue.UpdateStatus("a", StatusDownloading)
+
+	// A late update from the old attempt leaves the new one alone
+	if queue.FinishDownload("a", oldChan, StatusCancelled) {
+		t.Error("Expected the old attempt not to finish the new one")
+	}
+	if queue.RequeueDownload("a", oldChan, 0) {
+		t.Error("Expected the old attempt not to requeue the new one")
+	}
+	select {
+	case <-newChan:
+		t.Error("Expected the new attempt not to be cancelled")
+	default:
+	}
+	if _, exists := queue.GetStatus()[StatusDownloading]["a"]; !exists {
+		t.Error("Expected the new attempt to keep downloading")
+	}
+}
+
+func TestBookQueueNextWakesOnAdd(t *testing.T) {
+	queue := NewBookQueue(1 * time.Hour)
+
+	type result struct {
+		bookID string
+		err    error
+	}
+	results := make(chan result, 1)
+	go func() {
+		bookID, _, err := queue.Next(context.Background())
+		results <- result{bookID, err}
+	}()
+
+	// Give the goroutine time to start waiting
+	time.Sleep(50 * time.Millisecond)
+	queue.Add("test-1", &BookInfo{ID: "test-1", Title: "Test Book"}, 0)
+
+	select {
+	case r := <-results:
+		if r.err != nil || r.bookID != "test-1" {
+			t.Errorf("Expected test-1, got %q (%v)", r.bookID, r.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Next did not wake up on Add")
+	}
+}
+
+func TestBookQueueNextStopsOnCancel(t *testing.T) {
+	queue := NewBookQueue(1 * time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := queue.Next(ctx)
+		errs <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-errs:
+		if err != context.Canceled {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Next did not return after the context was cancelled")
+	}
+}
+
+func TestBookQueueNextCancelledDoesNotDequeue(t *testing.T) {
+	queue := NewBookQueue(1 * time.Hour)
+	queue.Add("a", &BookInfo{ID: "a", Title: "Book A"}, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if bookID, _, err := queue.Next(ctx); err != context.Canceled || bookID != "" {
+		t.Fatalf("Next() = %q, %v, want no book and context.Canceled", bookID, err)
+	}
+	if active := queue.GetActiveDownloads(); len(active) != 0 {
+		t.Errorf("Expected no active downloads, got %v", active)
+	}
+	if bookID, _, ok := queue.GetNext(); !ok || bookID != "a" {
+		t.Errorf("Expected book a to still be queued, got %q, %v", bookID, ok)
+	}
+}
+
+func TestBookQueueNextWaitsForRetry(t *testing.T) {
+	queue := NewBookQueue(1 * time.Hour)
+
+	queue.Add("test-1", &BookInfo{ID: "test-1", Title: "Test Book"}, 0)
+	bookID, cancelChan, _ := queue.GetNext()
+	queue.UpdateStatus(bookID, StatusDownloading)
+	queue.RequeueDownload(bookID, cancelChan, 100*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	start := time.Now()
+	next, _, err := queue.Next(ctx)
+	if err != nil {
+		t.Fatalf("Expected the retry to become due, got %v", err)
+	}
+	if next != "test-1" {
+		t.Errorf("Expected test-1, got %s", next)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected Next to wait for the retry delay, returned after %v", elapsed)
+	}
+}
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// QueueEntry is a persisted queue entry used to restore a BookQueue
+type QueueEntry struct {
+	BookID     string
+	Book       *BookInfo
+	Status     QueueStatus
+	Priority   int
+	AddedTime  time.Time
+	StatusTime time.Time
+}
+
+// QueueStore persists queue changes so the queue survives restarts
+type QueueStore interface {
+	// Save inserts or replaces a full queue entry
+	Save(entry QueueEntry) error
+	// UpdateStatus records a status transition
+	UpdateStatus(bookID string, status QueueStatus, statusTime time.Time) error
+	// UpdatePriority records a priority change
+	UpdatePriority(bookID string, priority int) error
+	// UpdateDownloadPath records where a book was downloaded to
+	UpdateDownloadPath(bookID string, downloadPath string) error
+	// UpdateMD5 records the MD5 of a downloaded book
+	UpdateMD5(bookID string, md5 string) error
+	// UpdateAttempts records the failed download attempts of a book
+	UpdateAttempts(bookID string, attempts []DownloadAttempt) error
+	// UpdateConversion records the conversion of a downloaded book
+	UpdateConversion(bookID string, conversion *Conversion) error
+	// Delete removes an entry
+	Delete(bookID string) error
+	// Load returns all persisted entries
+	Load() ([]QueueEntry, error)
+	// Close releases the underlying resources
+	Close() error
+}
@@ -0,0 +1,245 @@
+package resolver
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/config"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Format is the DNS over HTTPS message format
+type Format string
+
+const (
+	// FormatJSON is the JSON API supported by Google and Cloudflare
+	FormatJSON Format = "json"
+	// FormatWire is the RFC 8484 DNS wire format
+	FormatWire Format = "wire"
+)
+
+// maxDoHResponseSize limits the size of a DNS over HTTPS response
+const maxDoHResponseSize = 64 * 1024
+
+// dohClient resolves host names with a DNS over HTTPS server
+type dohClient struct {
+	url        string
+	format     Format
+	httpClient *http.Client
+}
+
+// jsonAnswer is a single record of a JSON API response
+type jsonAnswer struct {
+	Name string `json:"name"`
+	Type int    `json:"type"`
+	Data string `json:"data"`
+}
+
+// jsonResponse is a JSON API response
+type jsonResponse struct {
+	Status int          `json:"Status"`
+	Answer []jsonAnswer `json:"Answer"`
+}
+
+// newDoHClient creates a DNS over HTTPS client. The server itself is
+// resolved with the system resolver to avoid a lookup loop.
+func newDoHClient(cfg *config.Config, serverURL string, format Format) *dohClient {
+	transport := &http.Transport{}
+	if cfg.HTTPProxy != "" || cfg.HTTPSProxy != "" {
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			if req.URL.Scheme == "https" && cfg.HTTPSProxy != "" {
+				return url.Parse(cfg.HTTPSProxy)
+			}
+			if req.URL.Scheme == "http" && cfg.HTTPProxy != "" {
+				return url.Parse(cfg.HTTPProxy)
+			}
+			return nil, nil
+		}
+	}
+
+	return &dohClient{
+		url:    serverURL,
+		format: format,
+		httpClient: &http.Client{
+			Timeout:   lookupTimeout,
+			Transport: transport,
+		},
+	}
+}
+
+// lookup resolves the IPv4 and IPv6 addresses of host, IPv4 first
+func (c *dohClient) lookup(ctx context.Context, host string) ([]net.IP, error) {
+	var ips []net.IP
+	var lastErr error
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		var found []net.IP
+		var err error
+		if c.format == FormatWire {
+			found, err = c.queryWire(ctx, host, qtype)
+		} else {
+			found, err = c.queryJSON(ctx, host, qtype)
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		ips = append(ips, found...)
+	}
+
+	if len(ips) == 0 {
+		if lastErr == nil {
+			lastErr = ErrNoAddresses
+		}
+		return nil, fmt.Errorf("failed to resolve %s over HTTPS: %w", host, lastErr)
+	}
+	return ips, nil
+}
+
+// queryJSON sends a query to the JSON API
+func (c *dohClient) queryJSON(ctx context.Context, host string, qtype dnsmessage.Type) ([]net.IP, error) {
+	recordType := "A"
+	if qtype == dnsmessage.TypeAAAA {
+		recordType = "AAAA"
+	}
+
+	query := url.Values{}
+	query.Set("name", host)
+	query.Set("type", recordType)
+
+	accept := "application/dns-json"
+	if strings.Contains(c.url, "google") {
+		accept = "application/json"
+	}
+
+	body, err := c.get(ctx, c.url+"?"+query.Encode(), accept)
+	if err != nil {
+		return nil, err
+	}
+
+	var result jsonResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse DoH response: %w", err)
+	}
+	if result.Status != 0 {
+		return nil, fmt.Errorf("DoH query failed with DNS status %d", result.Status)
+	}
+
+	var ips []net.IP
+	for _, answer := range result.Answer {
+		if answer.Type != int(qtype) {
+			continue
+		}
+		if ip := net.ParseIP(answer.Data); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips, nil
+}
+
+// queryWire sends an RFC 8484 GET query
+func (c *dohClient) queryWire(ctx context.Context, host string, qtype dnsmessage.Type) ([]net.IP, error) {
+	name, err := dnsmessage.NewName(strings.TrimSuffix(host, ".") + ".")
+	if err != nil {
+		return nil, fmt.Errorf("invalid host name %s: %w", host, err)
+	}
+
+	// The ID is 0 so responses can be cached (RFC 8484 section 4.1)
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{RecursionDesired: true})
+	builder.EnableCompression()
+	if err := builder.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := builder.Question(dnsmessage.Question{Name: name, Type: qtype, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	msg, err := builder.Finish()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build DNS query: %w", err)
+	}
+
+	query := url.Values{}
+	query.Set("dns", base64.RawURLEncoding.EncodeToString(msg))
+
+	body, err := c.get(ctx, c.url+"?"+query.Encode(), "application/dns-message")
+	if err != nil {
+		return nil, err
+	}
+
+	var parser dnsmessage.Parser
+	header, err := parser.Start(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse DoH response: %w", err)
+	}
+	if header.RCode != dnsmessage.RCodeSuccess {
+		return nil, fmt.Errorf("DoH query failed with DNS status %s", header.RCode)
+	}
+	if err := parser.SkipAllQuestions(); err != nil {
+		return nil, fmt.Errorf("failed to parse DoH response: %w", err)
+	}
+
+	var ips []net.IP
+	for {
+		answer, err := parser.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse DoH response: %w", err)
+		}
+
+		switch answer.Type {
+		case dnsmessage.TypeA:
+			resource, err := parser.AResource()
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse DoH response: %w", err)
+			}
+			ips = append(ips, net.IP(resource.A[:]))
+		case dnsmessage.TypeAAAA:
+			resource, err := parser.AAAAResource()
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse DoH response: %w", err)
+			}
+			ips = append(ips, net.IP(resource.AAAA[:]))
+		default:
+			if err := parser.SkipAnswer(); err != nil {
+				return nil, fmt.Errorf("failed to parse DoH response: %w", err)
+			}
+		}
+	}
+	return ips, nil
+}
+
+// get performs a DoH GET request and returns the response body
+func (c *dohClient) get(ctx context.Context, requestURL, accept string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", requestURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DoH request: %w", err)
+	}
+	req.Header.Set("Accept", accept)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("DoH request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected DoH status code %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxDoHResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DoH response: %w", err)
+	}
+	return body, nil
+}
@@ -0,0 +1,217 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/config"
+)
+
+// ErrNoAddresses is returned when a lookup succeeds without any addresses
+var ErrNoAddresses = errors.New("no addresses found")
+
+// Preset is a well-known public DNS provider
+type Preset struct {
+	Servers   []string
+	DoHURL    string
+	DoHFormat Format
+}
+
+// Presets are the DNS providers that can be selected by name with CUSTOM_DNS
+var Presets = map[string]Preset{
+	"google": {
+		Servers:   []string{"8.8.8.8", "8.8.4.4", "2001:4860:4860::8888", "2001:4860:4860::8844"},
+		DoHURL:    "https://dns.google/resolve",
+		DoHFormat: FormatJSON,
+	},
+	"quad9": {
+		Servers:   []string{"9.9.9.9", "149.112.112.112", "2620:fe::fe", "2620:fe::9"},
+		DoHURL:    "https://dns.quad9.net/dns-query",
+		DoHFormat: FormatWire,
+	},
+	"cloudflare": {
+		Servers:   []string{"1.1.1.1", "1.0.0.1", "2606:4700:4700::1111", "2606:4700:4700::1001"},
+		DoHURL:    "https://cloudflare-dns.com/dns-query",
+		DoHFormat: FormatJSON,
+	},
+	"opendns": {
+		Servers:   []string{"208.67.222.222", "208.67.220.220", "2620:119:35::35", "2620:119:53::53"},
+		DoHURL:    "https://doh.opendns.com/dns-query",
+		DoHFormat: FormatWire,
+	},
+}
+
+// lookupTimeout bounds a single lookup against a custom server
+const lookupTimeout = 5 * time.Second
+
+// Resolver resolves host names with custom DNS servers or DNS over HTTPS.
+// IP addresses, localhost, private addresses and single label names (such
+// as docker service names) always use the system resolver.
+type Resolver struct {
+	servers []string
+	doh     *dohClient
+	dialer  *net.Dialer
+}
+
+// New creates a resolver from the CUSTOM_DNS and USE_DOH settings. It returns
+// nil when no custom resolution is configured.
+func New(cfg *config.Config) *Resolver {
+	servers, preset := parseCustomDNS(cfg.CustomDNS)
+	if len(servers) == 0 {
+		return nil
+	}
+
+	r := &Resolver{
+		servers: servers,
+		dialer:  &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+	}
+	if cfg.UseDOH && preset.DoHURL != "" {
+		r.doh = newDoHClient(cfg, preset.DoHURL, preset.DoHFormat)
+	}
+	return r
+}
+
+// parseCustomDNS turns a preset name or a comma separated list of IPs into
+// the DNS servers to use
+func parseCustomDNS(customDNS string) ([]string, Preset) {
+	customDNS = strings.ToLower(strings.TrimSpace(customDNS))
+	if customDNS == "" {
+		return nil, Preset{}
+	}
+
+	if preset, exists := Presets[customDNS]; exists {
+		return preset.Servers, preset
+	}
+
+	var servers []string
+	for _, server := range strings.Split(customDNS, ",") {
+		server = strings.TrimSpace(server)
+		if net.ParseIP(server) != nil {
+			servers = append(servers, server)
+		}
+	}
+	return servers, Preset{}
+}
+
+// LookupIP resolves host with DNS over HTTPS when enabled, otherwise with the
+// custom DNS servers. Hosts that bypass custom resolution are resolved by the
+// system resolver.
+func (r *Resolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	if bypass(host) {
+		return lookupSystem(ctx, host)
+	}
+
+	if r.doh != nil {
+		return r.doh.lookup(ctx, host)
+	}
+	return r.lookupServers(ctx, host)
+}
+
+// DialContext connects to addr, resolving the host name with LookupIP. If the
+// custom lookup fails the system resolver is used instead. It can be used as
+// http.Transport.DialContext.
+func (r *Resolver) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil || bypass(host) {
+		return r.dialer.DialContext(ctx, network, addr)
+	}
+
+	ips, err := r.LookupIP(ctx, host)
+	if err != nil || len(ips) == 0 {
+		return r.dialer.DialContext(ctx, network, addr)
+	}
+
+	var lastErr error
+	for _, ip := range ips {
+		if (network == "tcp4" && ip.To4() == nil) || (network == "tcp6" && ip.To4() != nil) {
+			continue
+		}
+		conn, err := r.dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no usable address for %s: %w", host, ErrNoAddresses)
+	}
+	return nil, lastErr
+}
+
+// lookupServers queries the custom DNS servers in order until one answers
+func (r *Resolver) lookupServers(ctx context.Context, host string) ([]net.IP, error) {
+	var lastErr error
+	for _, server := range r.servers {
+		server := server
+		netResolver := &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return r.dialer.DialContext(ctx, network, net.JoinHostPort(server, "53"))
+			},
+		}
+
+		lookupCtx, cancel := context.WithTimeout(ctx, lookupTimeout)
+		addrs, err := netResolver.LookupIPAddr(lookupCtx, host)
+		cancel()
+		if err != nil {
+			lastErr = err
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		if len(addrs) == 0 {
+			lastErr = ErrNoAddresses
+			continue
+		}
+
+		ips := make([]net.IP, len(addrs))
+		for i, addr := range addrs {
+			ips[i] = addr.IP
+		}
+		return ips, nil
+	}
+	return nil, fmt.Errorf("failed to resolve %s with custom DNS: %w", host, lastErr)
+}
+
+// lookupSystem resolves host with the system resolver
+func lookupSystem(ctx context.Context, host string) ([]net.IP, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.IP
+	}
+	return ips, nil
+}
+
+// bypass reports whether host must be resolved by the system resolver
+func bypass(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") || !strings.Contains(host, ".") {
+		return true
+	}
+	return IsLocalAddress(host)
+}
+
+// IsLocalAddress reports whether host is a loopback, private, link-local or
+// unspecified IP address
+func IsLocalAddress(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified()
+}
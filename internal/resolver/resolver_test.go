@@ -0,0 +1,183 @@
+package resolver
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/config"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestNew(t *testing.T) {
+	if r := New(&config.Config{}); r != nil {
+		t.Error("Expected no resolver without CUSTOM_DNS")
+	}
+
+	r := New(&config.Config{CustomDNS: "Cloudflare", UseDOH: true})
+	if r == nil || r.doh == nil {
+		t.Fatal("Expected a DoH resolver for the cloudflare preset")
+	}
+	if r.doh.url != "https://cloudflare-dns.com/dns-query" || r.doh.format != FormatJSON {
+		t.Errorf("Unexpected DoH settings %s (%s)", r.doh.url, r.doh.format)
+	}
+
+	r = New(&config.Config{CustomDNS: "9.9.9.9, not-an-ip, 2620:fe::fe", UseDOH: true})
+	if r == nil {
+		t.Fatal("Expected a resolver for custom IPs")
+	}
+	if len(r.servers) != 2 || r.servers[0] != "9.9.9.9" || r.servers[1] != "2620:fe::fe" {
+		t.Errorf("Unexpected servers %v", r.servers)
+	}
+	if r.doh != nil {
+		t.Error("Custom IPs have no DoH server")
+	}
+}
+
+func TestBypass(t *testing.T) {
+	tests := []struct {
+		host     string
+		expected bool
+	}{
+		{"localhost", true},
+		{"flaresolverr", true},
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.20.0.5", true},
+		{"192.168.1.10", true},
+		{"fd00::1", true},
+		{"fe80::1", true},
+		{"annas-archive.org", false},
+		{"8.8.8.8", false},
+		{"172.32.0.1", false},
+	}
+
+	for _, tt := range tests {
+		if got := bypass(tt.host); got != tt.expected {
+			t.Errorf("bypass(%q) = %v, expected %v", tt.host, got, tt.expected)
+		}
+	}
+}
+
+func TestDoHJSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if accept := r.Header.Get("Accept"); accept != "application/dns-json" {
+			t.Errorf("Unexpected Accept header %s", accept)
+		}
+		if name := r.URL.Query().Get("name"); name != "books.example" {
+			t.Errorf("Unexpected name %s", name)
+		}
+
+		answers := []jsonAnswer{}
+		if r.URL.Query().Get("type") == "A" {
+			answers = append(answers,
+				jsonAnswer{Name: "books.example", Type: 5, Data: "cdn.books.example."},
+				jsonAnswer{Name: "cdn.books.example", Type: 1, Data: "127.0.0.1"})
+		}
+		json.NewEncoder(w).Encode(jsonResponse{Status: 0, Answer: answers})
+	}))
+	defer server.Close()
+
+	client := newDoHClient(&config.Config{}, server.URL, FormatJSON)
+
+	ips, err := client.lookup(context.Background(), "books.example")
+	if err != nil {
+		t.Fatalf("lookup failed: %v", err)
+	}
+	if len(ips) != 1 || !ips[0].Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("Expected [127.0.0.1], got %v", ips)
+	}
+}
+
+func TestDoHWireFormat(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if accept := r.Header.Get("Accept"); accept != "application/dns-message" {
+			t.Errorf("Unexpected Accept header %s", accept)
+		}
+
+		raw, err := base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		if err != nil {
+			t.Fatalf("Invalid dns parameter: %v", err)
+		}
+		var query dnsmessage.Message
+		if err := query.Unpack(raw); err != nil {
+			t.Fatalf("Invalid DNS query: %v", err)
+		}
+
+		question := query.Questions[0]
+		reply := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: query.ID, Response: true, RCode: dnsmessage.RCodeSuccess},
+			Questions: query.Questions,
+		}
+		header := dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: 60}
+		switch question.Type {
+		case dnsmessage.TypeA:
+			reply.Answers = append(reply.Answers, dnsmessage.Resource{
+				Header: header,
+				Body:   &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}},
+			})
+		case dnsmessage.TypeAAAA:
+			reply.Answers = append(reply.Answers, dnsmessage.Resource{
+				Header: header,
+				Body:   &dnsmessage.AAAAResource{AAAA: [16]byte{15: 1}},
+			})
+		}
+
+		packed, err := reply.Pack()
+		if err != nil {
+			t.Fatalf("Failed to pack reply: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(packed)
+	}))
+	defer server.Close()
+
+	client := newDoHClient(&config.Config{}, server.URL, FormatWire)
+
+	ips, err := client.lookup(context.Background(), "books.example")
+	if err != nil {
+		t.Fatalf("lookup failed: %v", err)
+	}
+	if len(ips) != 2 || !ips[0].Equal(net.ParseIP("127.0.0.1")) || !ips[1].Equal(net.ParseIP("::1")) {
+		t.Errorf("Expected [127.0.0.1 ::1], got %v", ips)
+	}
+}
+
+func TestDialContextUsesDoH(t *testing.T) {
+	dohServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		answers := []jsonAnswer{}
+		if r.URL.Query().Get("type") == "A" {
+			answers = append(answers, jsonAnswer{Name: "books.example", Type: 1, Data: "127.0.0.1"})
+		}
+		json.NewEncoder(w).Encode(jsonResponse{Status: 0, Answer: answers})
+	}))
+	defer dohServer.Close()
+
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello from " + r.Host))
+	}))
+	defer site.Close()
+
+	r := New(&config.Config{CustomDNS: "127.0.0.1"})
+	r.doh = newDoHClient(&config.Config{}, dohServer.URL, FormatJSON)
+
+	client := &http.Client{Transport: &http.Transport{DialContext: r.DialContext}}
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(site.URL, "http://"))
+
+	resp, err := client.Get("http://books.example:" + port + "/")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "hello from books.example:"+port {
+		t.Errorf("Unexpected body %q", body)
+	}
+}
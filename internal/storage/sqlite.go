@@ -0,0 +1,307 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/models"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS queue (
+	book_id       TEXT PRIMARY KEY,
+	book_data     TEXT NOT NULL,
+	status        TEXT NOT NULL,
+	priority      INTEGER NOT NULL DEFAULT 0,
+	added_time    INTEGER NOT NULL,
+	status_time   INTEGER NOT NULL,
+	download_path TEXT,
+	md5           TEXT,
+	attempts      TEXT,
+	conversion    TEXT
+)`
+
+// migrations add columns introduced after the first schema version
+var migrations = []struct {
+	column string
+	ddl    string
+}{
+	{"md5", "ALTER TABLE queue ADD COLUMN md5 TEXT"},
+	{"attempts", "ALTER TABLE queue ADD COLUMN attempts TEXT"},
+	{"conversion", "ALTER TABLE queue ADD COLUMN conversion TEXT"},
+}
+
+// SQLiteQueueStore persists the download queue in an embedded SQLite file
+type SQLiteQueueStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteQueueStore opens (or creates) the queue database at dbPath
+func NewSQLiteQueueStore(dbPath string) (*SQLiteQueueStore, error) {
+	if dir := filepath.Dir(dbPath); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create queue database directory: %w", err)
+		}
+	}
+
+	dbURI := fmt.Sprintf("file:%s?_busy_timeout=5000&_journal_mode=WAL", dbPath)
+	db, err := sql.Open("sqlite3", dbURI)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open queue database: %w", err)
+	}
+	// SQLite only supports a single writer
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create queue schema: %w", err)
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteQueueStore{db: db}, nil
+}
+
+// migrate adds the columns missing from databases created by older versions
+func migrate(db *sql.DB) error {
+	rows, err := db.Query("PRAGMA table_info(queue)")
+	if err != nil {
+		return fmt.Errorf("failed to read queue schema: %w", err)
+	}
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read queue schema: %w", err)
+		}
+		columns[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read queue schema: %w", err)
+	}
+
+	for _, m := range migrations {
+		if columns[m.column] {
+			continue
+		}
+		if _, err := db.Exec(m.ddl); err != nil {
+			return fmt.Errorf("failed to add column %s: %w", m.column, err)
+		}
+	}
+	return nil
+}
+
+// Save inserts or replaces a full queue entry
+func (s *SQLiteQueueStore) Save(entry models.QueueEntry) error {
+	data, err := json.Marshal(entry.Book)
+	if err != nil {
+		return fmt.Errorf("failed to encode book data: %w", err)
+	}
+
+	var downloadPath sql.NullString
+	if entry.Book != nil && entry.Book.DownloadPath != nil {
+		downloadPath = sql.NullString{String: *entry.Book.DownloadPath, Valid: true}
+	}
+
+	var checksum sql.NullString
+	if entry.Book != nil && entry.Book.MD5 != "" {
+		checksum = sql.NullString{String: entry.Book.MD5, Valid: true}
+	}
+
+	var attempts, conversion sql.NullString
+	if entry.Book != nil {
+		attempts, err = encodeAttempts(entry.Book.Attempts)
+		if err != nil {
+			return err
+		}
+		conversion, err = encodeConversion(entry.Book.Conversion)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = s.db.Exec(`INSERT OR REPLACE INTO queue
+		(book_id, book_data, status, priority, added_time, status_time, download_path, md5, attempts, conversion)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.BookID, string(data), string(entry.Status), entry.Priority,
+		entry.AddedTime.UnixNano(), entry.StatusTime.UnixNano(), downloadPath, checksum, attempts, conversion)
+	if err != nil {
+		return fmt.Errorf("failed to save queue entry %s: %w", entry.BookID, err)
+	}
+	return nil
+}
+
+// UpdateStatus records a status transition
+func (s *SQLiteQueueStore) UpdateStatus(bookID string, status models.QueueStatus, statusTime time.Time) error {
+	_, err := s.db.Exec("UPDATE queue SET status = ?, status_time = ? WHERE book_id = ?",
+		string(status), statusTime.UnixNano(), bookID)
+	if err != nil {
+		return fmt.Errorf("failed to update status of %s: %w", bookID, err)
+	}
+	return nil
+}
+
+// UpdatePriority records a priority change
+func (s *SQLiteQueueStore) UpdatePriority(bookID string, priority int) error {
+	_, err := s.db.Exec("UPDATE queue SET priority = ? WHERE book_id = ?", priority, bookID)
+	if err != nil {
+		return fmt.Errorf("failed to update priority of %s: %w", bookID, err)
+	}
+	return nil
+}
+
+// UpdateDownloadPath records where a book was downloaded to
+func (s *SQLiteQueueStore) UpdateDownloadPath(bookID string, downloadPath string) error {
+	_, err := s.db.Exec("UPDATE queue SET download_path = ? WHERE book_id = ?", downloadPath, bookID)
+	if err != nil {
+		return fmt.Errorf("failed to update download path of %s: %w", bookID, err)
+	}
+	return nil
+}
+
+// UpdateMD5 records the MD5 of a downloaded book
+func (s *SQLiteQueueStore) UpdateMD5(bookID string, md5 string) error {
+	_, err := s.db.Exec("UPDATE queue SET md5 = ? WHERE book_id = ?", md5, bookID)
+	if err != nil {
+		return fmt.Errorf("failed to update md5 of %s: %w", bookID, err)
+	}
+	return nil
+}
+
+// UpdateAttempts records the failed download attempts of a book
+func (s *SQLiteQueueStore) UpdateAttempts(bookID string, attempts []models.DownloadAttempt) error {
+	encoded, err := encodeAttempts(attempts)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec("UPDATE queue SET attempts = ? WHERE book_id = ?", encoded, bookID)
+	if err != nil {
+		return fmt.Errorf("failed to update attempts of %s: %w", bookID, err)
+	}
+	return nil
+}
+
+// encodeAttempts encodes download attempts as JSON, or NULL when there are none
+func encodeAttempts(attempts []models.DownloadAttempt) (sql.NullString, error) {
+	if len(attempts) == 0 {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(attempts)
+	if err != nil {
+		return sql.NullString{}, fmt.Errorf("failed to encode download attempts: %w", err)
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// UpdateConversion records the conversion of a downloaded book
+func (s *SQLiteQueueStore) UpdateConversion(bookID string, conversion *models.Conversion) error {
+	encoded, err := encodeConversion(conversion)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec("UPDATE queue SET conversion = ? WHERE book_id = ?", encoded, bookID)
+	if err != nil {
+		return fmt.Errorf("failed to update conversion of %s: %w", bookID, err)
+	}
+	return nil
+}
+
+// encodeConversion encodes a conversion as JSON, or NULL when there is none
+func encodeConversion(conversion *models.Conversion) (sql.NullString, error) {
+	if conversion == nil {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(conversion)
+	if err != nil {
+		return sql.NullString{}, fmt.Errorf("failed to encode conversion: %w", err)
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// Delete removes an entry
+func (s *SQLiteQueueStore) Delete(bookID string) error {
+	_, err := s.db.Exec("DELETE FROM queue WHERE book_id = ?", bookID)
+	if err != nil {
+		return fmt.Errorf("failed to delete queue entry %s: %w", bookID, err)
+	}
+	return nil
+}
+
+// Load returns all persisted entries in the order they were added
+func (s *SQLiteQueueStore) Load() ([]models.QueueEntry, error) {
+	rows, err := s.db.Query(`SELECT book_id, book_data, status, priority, added_time, status_time, download_path, md5, attempts, conversion
+		FROM queue ORDER BY added_time`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load queue: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []models.QueueEntry
+	for rows.Next() {
+		var (
+			entry        models.QueueEntry
+			data         string
+			status       string
+			addedTime    int64
+			statusTime   int64
+			downloadPath sql.NullString
+			checksum     sql.NullString
+			attempts     sql.NullString
+			conversion   sql.NullString
+		)
+		if err := rows.Scan(&entry.BookID, &data, &status, &entry.Priority, &addedTime, &statusTime, &downloadPath, &checksum, &attempts, &conversion); err != nil {
+			return nil, fmt.Errorf("failed to read queue entry: %w", err)
+		}
+
+		var book models.BookInfo
+		if err := json.Unmarshal([]byte(data), &book); err != nil {
+			return nil, fmt.Errorf("failed to decode book data for %s: %w", entry.BookID, err)
+		}
+		book.DownloadPath = nil
+		if downloadPath.Valid {
+			book.DownloadPath = &downloadPath.String
+		}
+		book.MD5 = checksum.String
+		book.Attempts = nil
+		if attempts.Valid {
+			if err := json.Unmarshal([]byte(attempts.String), &book.Attempts); err != nil {
+				return nil, fmt.Errorf("failed to decode download attempts for %s: %w", entry.BookID, err)
+			}
+		}
+		book.Conversion = nil
+		if conversion.Valid {
+			if err := json.Unmarshal([]byte(conversion.String), &book.Conversion); err != nil {
+				return nil, fmt.Errorf("failed to decode conversion for %s: %w", entry.BookID, err)
+			}
+		}
+
+		entry.Book = &book
+		entry.Status = models.QueueStatus(status)
+		entry.AddedTime = time.Unix(0, addedTime)
+		entry.StatusTime = time.Unix(0, statusTime)
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// Close closes the database
+func (s *SQLiteQueueStore) Close() error {
+	return s.db.Close()
+}
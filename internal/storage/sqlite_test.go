@@ -0,0 +1,261 @@
+package storage
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/models"
+)
+
+func TestSQLiteQueueStoreRoundTrip(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "queue.db")
+
+	store, err := NewSQLiteQueueStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	defer store.Close()
+
+	added := time.Now().Add(-time.Minute)
+	author := "Test Author"
+	err = store.Save(models.QueueEntry{
+		BookID:     "book-1",
+		Book:       &models.BookInfo{ID: "book-1", Title: "Book 1", Author: &author},
+		Status:     models.StatusQueued,
+		Priority:   3,
+		AddedTime:  added,
+		StatusTime: added,
+	})
+	if err != nil {
+		t.Fatalf("Failed to save entry: %v", err)
+	}
+
+	if err := store.UpdatePriority("book-1", 1); err != nil {
+		t.Fatalf("Failed to update priority: %v", err)
+	}
+	if err := store.UpdateStatus("book-1", models.StatusAvailable, time.Now()); err != nil {
+		t.Fatalf("Failed to update status: %v", err)
+	}
+	if err := store.UpdateDownloadPath("book-1", "/ingest/book-1.epub"); err != nil {
+		t.Fatalf("Failed to update download path: %v", err)
+	}
+	if err := store.UpdateMD5("book-1", "d41d8cd98f00b204e9800998ecf8427e"); err != nil {
+		t.Fatalf("Failed to update md5: %v", err)
+	}
+	attempts := []models.DownloadAttempt{{Attempt: 1, Error: "unexpected status code 503", Transient: true, Time: added}}
+	if err := store.UpdateAttempts("book-1", attempts); err != nil {
+		t.Fatalf("Failed to update attempts: %v", err)
+	}
+	conversion := &models.Conversion{Status: models.ConversionConverted, From: "mobi", To: "epub", Time: added}
+	if err := store.UpdateConversion("book-1", conversion); err != nil {
+		t.Fatalf("Failed to update conversion: %v", err)
+	}
+
+	entries, err := store.Load()
+	if err != nil {
+		t.Fatalf("Failed to load entries: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %d", len(entries))
+	}
+
+	entry := entries[0]
+	if entry.Status != models.StatusAvailable {
+		t.Errorf("Expected status %s, got %s", models.StatusAvailable, entry.Status)
+	}
+	if entry.Priority != 1 {
+		t.Errorf("Expected priority 1, got %d", entry.Priority)
+	}
+	if !entry.AddedTime.Equal(added) {
+		t.Errorf("Expected added time %v, got %v", added, entry.AddedTime)
+	}
+	if entry.Book.Author == nil || *entry.Book.Author != author {
+		t.Error("Author not restored correctly")
+	}
+	if entry.Book.DownloadPath == nil || *entry.Book.DownloadPath != "/ingest/book-1.epub" {
+		t.Error("Download path not restored correctly")
+	}
+	if entry.Book.MD5 != "d41d8cd98f00b204e9800998ecf8427e" {
+		t.Errorf("Expected md5 to be restored, got '%s'", entry.Book.MD5)
+	}
+	if len(entry.Book.Attempts) != 1 || entry.Book.Attempts[0].Error != "unexpected status code 503" || !entry.Book.Attempts[0].Transient {
+		t.Errorf("Expected attempts to be restored, got %+v", entry.Book.Attempts)
+	}
+	if entry.Book.Conversion == nil || entry.Book.Conversion.Status != models.ConversionConverted || entry.Book.Conversion.To != "epub" {
+		t.Errorf("Expected conversion to be restored, got %+v", entry.Book.Conversion)
+	}
+
+	if err := store.Delete("book-1"); err != nil {
+		t.Fatalf("Failed to delete entry: %v", err)
+	}
+	entries, err = store.Load()
+	if err != nil {
+		t.Fatalf("Failed to load entries: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected no entries after delete, got %d", len(entries))
+	}
+}
+
+func TestBookQueueRestoreFromSQLite(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "queue.db")
+
+	store, err := NewSQLiteQueueStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+
+	queue, err := models.NewBookQueueWithStore(time.Hour, store)
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+
+	queue.Add("low", &models.BookInfo{ID: "low", Title: "Low"}, 5)
+	queue.Add("high", &models.BookInfo{ID: "high", Title: "High"}, 5)
+	queue.Add("active", &models.BookInfo{ID: "active", Title: "Active"}, 0)
+	queue.Add("done", &models.BookInfo{ID: "done", Title: "Done"}, 0)
+	queue.SetPriority("high", 1)
+
+	// Simulate an in-progress and a finished download
+	for i := 0; i < 2; i++ {
+		bookID, _, ok := queue.GetNext()
+		if !ok {
+			t.Fatal("Expected a book from the queue")
+		}
+		if bookID == "active" {
+			queue.UpdateStatus(bookID, models.StatusDownloading)
+			queue.UpdateProgress(bookID, 42)
+		} else {
+			queue.UpdateDownloadPath(bookID, "/ingest/done.epub")
+			queue.UpdateStatus(bookID, models.StatusAvailable)
+		}
+	}
+
+	if err := queue.Close(); err != nil {
+		t.Fatalf("Failed to close queue: %v", err)
+	}
+
+	// Reopen as if the process restarted
+	store, err = NewSQLiteQueueStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	restored, err := models.NewBookQueueWithStore(time.Hour, store)
+	if err != nil {
+		t.Fatalf("Failed to restore queue: %v", err)
+	}
+	defer restored.Close()
+
+	status := restored.GetStatus()
+	if _, exists := status[models.StatusQueued]["active"]; !exists {
+		t.Error("Expected interrupted download to be queued again")
+	}
+	if book, exists := status[models.StatusAvailable]["done"]; !exists {
+		t.Error("Expected finished download to remain available")
+	} else if book.DownloadPath == nil || *book.DownloadPath != "/ingest/done.epub" {
+		t.Error("Expected download path to be restored")
+	}
+
+	// Interrupted download comes first, then priority order, then insertion order
+	expected := []string{"active", "high", "low"}
+	for _, want := range expected {
+		bookID, _, ok := restored.GetNext()
+		if !ok {
+			t.Fatalf("Expected %s from the restored queue", want)
+		}
+		if bookID != want {
+			t.Errorf("Expected %s, got %s", want, bookID)
+		}
+	}
+	if bookID, _, ok := restored.GetNext(); ok {
+		t.Errorf("Expected restored queue to be empty, got %s", bookID)
+	}
+}
+
+func TestBookQueueRestoresRequestTimes(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "queue.db")
+
+	store, err := NewSQLiteQueueStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	queue, err := models.NewBookQueueWithStore(time.Hour, store)
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+
+	before := time.Now()
+	queue.Add("a", &models.BookInfo{ID: "a", RequestedBy: "alice"}, 0)
+	queue.Add("b", &models.BookInfo{ID: "b", RequestedBy: "alice"}, 0)
+	queue.Add("c", &models.BookInfo{ID: "c"}, 0)
+	queue.UpdateStatus("b", models.StatusAvailable)
+	if err := queue.Close(); err != nil {
+		t.Fatalf("Failed to close queue: %v", err)
+	}
+
+	store, err = NewSQLiteQueueStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	restored, err := models.NewBookQueueWithStore(time.Hour, store)
+	if err != nil {
+		t.Fatalf("Failed to restore queue: %v", err)
+	}
+	defer restored.Close()
+
+	times := restored.RequestTimes()
+	if len(times) != 1 || len(times["alice"]) != 2 {
+		t.Fatalf("Expected two requests of alice, got %v", times)
+	}
+	for _, added := range times["alice"] {
+		if added.Before(before.Add(-time.Second)) || added.After(time.Now()) {
+			t.Errorf("Unexpected request time %v", added)
+		}
+	}
+}
+
+func TestSQLiteQueueStoreMigratesOldSchema(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "queue.db")
+
+	// Create a database without the md5 column
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	_, err = db.Exec(`CREATE TABLE queue (
+		book_id       TEXT PRIMARY KEY,
+		book_data     TEXT NOT NULL,
+		status        TEXT NOT NULL,
+		priority      INTEGER NOT NULL DEFAULT 0,
+		added_time    INTEGER NOT NULL,
+		status_time   INTEGER NOT NULL,
+		download_path TEXT
+	)`)
+	if err == nil {
+		_, err = db.Exec(`INSERT INTO queue VALUES ('book-1', '{"id":"book-1","title":"Book 1","priority":0}', 'available', 0, 1, 1, NULL)`)
+	}
+	db.Close()
+	if err != nil {
+		t.Fatalf("Failed to create old schema: %v", err)
+	}
+
+	store, err := NewSQLiteQueueStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.UpdateMD5("book-1", "0123456789abcdef0123456789abcdef"); err != nil {
+		t.Fatalf("Failed to update md5: %v", err)
+	}
+
+	entries, err := store.Load()
+	if err != nil {
+		t.Fatalf("Failed to load entries: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Book.MD5 != "0123456789abcdef0123456789abcdef" {
+		t.Errorf("Expected migrated entry with md5, got %+v", entries)
+	}
+}
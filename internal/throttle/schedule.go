@@ -0,0 +1,162 @@
+package throttle
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minutesPerDay is the number of minutes in a day
+const minutesPerDay = 24 * 60
+
+// Window is a daily time range with its own bandwidth limit. Windows whose
+// end is before their start wrap around midnight.
+type Window struct {
+	// Start and End are minutes after midnight, local time
+	Start int
+	End   int
+	// Rate is the limit in bytes per second, 0 for unlimited
+	Rate int64
+}
+
+// Contains reports whether the minute of the day falls inside the window
+func (w Window) Contains(minute int) bool {
+	if w.Start <= w.End {
+		return minute >= w.Start && minute < w.End
+	}
+	return minute >= w.Start || minute < w.End
+}
+
+// String formats the window as HH:MM-HH:MM=RATE
+func (w Window) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d=%s",
+		w.Start/60, w.Start%60, w.End/60, w.End%60, FormatRate(w.Rate))
+}
+
+// Schedule is a list of windows. The first window containing the current
+// time decides the limit.
+type Schedule []Window
+
+// ParseSchedule parses a comma separated list of HH:MM-HH:MM=RATE windows,
+// e.g. "01:00-07:00=0,18:00-23:00=200KB"
+func ParseSchedule(s string) (Schedule, error) {
+	var schedule Schedule
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		span, rate, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid schedule entry %q: expected HH:MM-HH:MM=RATE", entry)
+		}
+		from, to, found := strings.Cut(span, "-")
+		if !found {
+			return nil, fmt.Errorf("invalid schedule entry %q: expected HH:MM-HH:MM=RATE", entry)
+		}
+
+		var window Window
+		var err error
+		if window.Start, err = parseClock(from); err != nil {
+			return nil, fmt.Errorf("invalid schedule entry %q: %w", entry, err)
+		}
+		if window.End, err = parseClock(to); err != nil {
+			return nil, fmt.Errorf("invalid schedule entry %q: %w", entry, err)
+		}
+		if window.Rate, err = ParseRate(rate); err != nil {
+			return nil, fmt.Errorf("invalid schedule entry %q: %w", entry, err)
+		}
+		schedule = append(schedule, window)
+	}
+	return schedule, nil
+}
+
+// RateAt returns the limit of the window containing t, if any
+func (s Schedule) RateAt(t time.Time) (int64, bool) {
+	minute := t.Hour()*60 + t.Minute()
+	for _, window := range s {
+		if window.Contains(minute) {
+			return window.Rate, true
+		}
+	}
+	return 0, false
+}
+
+// String formats the schedule in the format read by ParseSchedule
+func (s Schedule) String() string {
+	entries := make([]string, len(s))
+	for i, window := range s {
+		entries[i] = window.String()
+	}
+	return strings.Join(entries, ",")
+}
+
+// parseClock parses HH:MM into minutes after midnight. 24:00 is accepted as
+// the end of the day.
+func parseClock(s string) (int, error) {
+	hours, minutes, found := strings.Cut(strings.TrimSpace(s), ":")
+	if !found {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	h, err := strconv.Atoi(hours)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	m, err := strconv.Atoi(minutes)
+	if err != nil || h < 0 || m < 0 || m > 59 || h*60+m > minutesPerDay {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return h*60 + m, nil
+}
+
+// ParseRate parses a rate such as "500KB", "1.5MB/s" or "2048" (bytes per
+// second). Empty and "0" mean unlimited.
+func ParseRate(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	value = strings.TrimSuffix(value, "/S")
+	if value == "" {
+		return 0, nil
+	}
+
+	multiplier := 1.0
+	for _, unit := range []struct {
+		suffix     string
+		multiplier float64
+	}{
+		{"GB", 1024 * 1024 * 1024},
+		{"MB", 1024 * 1024},
+		{"KB", 1024},
+		{"G", 1024 * 1024 * 1024},
+		{"M", 1024 * 1024},
+		{"K", 1024},
+		{"B", 1},
+	} {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return int64(number * multiplier), nil
+}
+
+// FormatRate formats a rate in the format read by ParseRate
+func FormatRate(rate int64) string {
+	switch {
+	case rate <= 0:
+		return "0"
+	case rate%(1024*1024) == 0:
+		return fmt.Sprintf("%dMB", rate/(1024*1024))
+	case rate%1024 == 0:
+		return fmt.Sprintf("%dKB", rate/1024)
+	default:
+		return strconv.FormatInt(rate, 10)
+	}
+}
@@ -0,0 +1,156 @@
+package throttle
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/config"
+)
+
+// Settings are the bandwidth limits for downloads
+type Settings struct {
+	// Limit is shared by all downloads, in bytes per second (0 = unlimited)
+	Limit int64
+	// PerDownload caps every single download, in bytes per second (0 = unlimited)
+	PerDownload int64
+	// Schedule overrides Limit during its windows
+	Schedule Schedule
+}
+
+// Limiter is a token bucket shared by all downloads, with an optional cap
+// per download and a daily schedule
+type Limiter struct {
+	mu       sync.Mutex
+	settings Settings
+	global   bucket
+	now      func() time.Time
+}
+
+// New creates a limiter with the given settings
+func New(settings Settings) *Limiter {
+	return &Limiter{settings: settings, now: time.Now}
+}
+
+// SettingsFromConfig reads the bandwidth settings from the configuration
+func SettingsFromConfig(cfg *config.Config) (Settings, error) {
+	var settings Settings
+	var err error
+	if settings.Limit, err = ParseRate(cfg.DownloadRateLimit); err != nil {
+		return Settings{}, fmt.Errorf("DOWNLOAD_RATE_LIMIT: %w", err)
+	}
+	if settings.PerDownload, err = ParseRate(cfg.DownloadRateLimitPerDownload); err != nil {
+		return Settings{}, fmt.Errorf("DOWNLOAD_RATE_LIMIT_PER_DOWNLOAD: %w", err)
+	}
+	if settings.Schedule, err = ParseSchedule(cfg.DownloadRateSchedule); err != nil {
+		return Settings{}, fmt.Errorf("DOWNLOAD_RATE_SCHEDULE: %w", err)
+	}
+	return settings, nil
+}
+
+// Settings returns the current settings
+func (l *Limiter) Settings() Settings {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.settings
+}
+
+// SetSettings changes the limits. Running downloads pick them up right away.
+func (l *Limiter) SetSettings(settings Settings) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.settings = settings
+}
+
+// EffectiveLimit returns the shared limit in force right now, taking the
+// schedule into account
+func (l *Limiter) EffectiveLimit() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.effectiveLimit(l.now())
+}
+
+// effectiveLimit is the lock-free implementation of EffectiveLimit
+func (l *Limiter) effectiveLimit(now time.Time) int64 {
+	if rate, scheduled := l.settings.Schedule.RateAt(now); scheduled {
+		return rate
+	}
+	return l.settings.Limit
+}
+
+// Stream tracks the per download cap of a single download
+type Stream struct {
+	limiter *Limiter
+	own     bucket
+}
+
+// NewStream starts tracking a new download
+func (l *Limiter) NewStream() *Stream {
+	return &Stream{limiter: l}
+}
+
+// Wait blocks until n more bytes may be read under both the shared and the
+// per download limit, or until ctx is done
+func (s *Stream) Wait(ctx context.Context, n int) error {
+	l := s.limiter
+
+	l.mu.Lock()
+	now := l.now()
+	delay := l.global.reserve(now, l.effectiveLimit(now), n)
+	if own := s.own.reserve(now, l.settings.PerDownload, n); own > delay {
+		delay = own
+	}
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// bucket is a token bucket holding up to one second worth of bytes. Tokens
+// may go negative, which makes concurrent readers queue up fairly.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// reserve takes n tokens at the given rate and returns how long to wait
+// before they may be used
+func (b *bucket) reserve(now time.Time, rate int64, n int) time.Duration {
+	if rate <= 0 {
+		// Unlimited, start from a full bucket once a limit is set
+		b.last = time.Time{}
+		return 0
+	}
+
+	burst := float64(rate)
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * float64(rate)
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	b.last = now
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / float64(rate) * float64(time.Second))
+}
@@ -0,0 +1,159 @@
+package throttle
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/veverkap/calibre-web-automated-book-downloader/internal/config"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int64
+	}{
+		{"", 0},
+		{"0", 0},
+		{"2048", 2048},
+		{"500KB", 500 * 1024},
+		{"500 kb/s", 500 * 1024},
+		{"1.5MB", 1536 * 1024},
+		{"2M", 2 * 1024 * 1024},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRate(tt.input)
+		if err != nil {
+			t.Errorf("ParseRate(%q) returned error: %v", tt.input, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRate(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+
+	for _, input := range []string{"fast", "-1KB", "10XB"} {
+		if _, err := ParseRate(input); err == nil {
+			t.Errorf("Expected ParseRate(%q) to fail", input)
+		}
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	schedule, err := ParseSchedule("01:00-07:00=0, 22:00-01:00=200KB")
+	if err != nil {
+		t.Fatalf("Failed to parse schedule: %v", err)
+	}
+	if len(schedule) != 2 {
+		t.Fatalf("Expected 2 windows, got %d", len(schedule))
+	}
+	if got := schedule.String(); got != "01:00-07:00=0,22:00-01:00=200KB" {
+		t.Errorf("Unexpected schedule string %q", got)
+	}
+
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	tests := []struct {
+		clock     time.Duration
+		rate      int64
+		scheduled bool
+	}{
+		{3 * time.Hour, 0, true},
+		{23 * time.Hour, 200 * 1024, true},
+		{30 * time.Minute, 200 * 1024, true},
+		{7 * time.Hour, 0, false},
+		{12 * time.Hour, 0, false},
+	}
+	for _, tt := range tests {
+		rate, scheduled := schedule.RateAt(day.Add(tt.clock))
+		if rate != tt.rate || scheduled != tt.scheduled {
+			t.Errorf("RateAt(%v) = %d, %v, want %d, %v", tt.clock, rate, scheduled, tt.rate, tt.scheduled)
+		}
+	}
+
+	for _, input := range []string{"01:00-07:00", "1-7=0", "25:00-26:00=0", "01:00-07:00=fast"} {
+		if _, err := ParseSchedule(input); err == nil {
+			t.Errorf("Expected ParseSchedule(%q) to fail", input)
+		}
+	}
+}
+
+func TestLimiterEffectiveLimit(t *testing.T) {
+	schedule, _ := ParseSchedule("01:00-07:00=0")
+	limiter := New(Settings{Limit: 500 * 1024, Schedule: schedule})
+
+	limiter.now = func() time.Time { return time.Date(2024, 1, 1, 3, 0, 0, 0, time.Local) }
+	if got := limiter.EffectiveLimit(); got != 0 {
+		t.Errorf("Expected full speed at night, got %d", got)
+	}
+
+	limiter.now = func() time.Time { return time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local) }
+	if got := limiter.EffectiveLimit(); got != 500*1024 {
+		t.Errorf("Expected 500KB/s during the day, got %d", got)
+	}
+}
+
+func TestBucketReserve(t *testing.T) {
+	var b bucket
+	now := time.Now()
+
+	// A full bucket holds one second worth of bytes
+	if delay := b.reserve(now, 1000, 1000); delay != 0 {
+		t.Errorf("Expected no wait for the burst, got %v", delay)
+	}
+	if delay := b.reserve(now, 1000, 500); delay != 500*time.Millisecond {
+		t.Errorf("Expected 500ms wait, got %v", delay)
+	}
+	// Half a second later the debt is paid
+	if delay := b.reserve(now.Add(500*time.Millisecond), 1000, 100); delay != 100*time.Millisecond {
+		t.Errorf("Expected 100ms wait, got %v", delay)
+	}
+	if delay := b.reserve(now, 0, 1<<20); delay != 0 {
+		t.Errorf("Expected no wait without a limit, got %v", delay)
+	}
+}
+
+func TestStreamWait(t *testing.T) {
+	limiter := New(Settings{PerDownload: 1000})
+	stream := limiter.NewStream()
+	ctx := context.Background()
+
+	if err := stream.Wait(ctx, 1000); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	start := time.Now()
+	if err := stream.Wait(ctx, 100); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected the per download cap to slow the stream down, waited %v", elapsed)
+	}
+
+	// Other downloads have their own cap
+	if err := limiter.NewStream().Wait(ctx, 1000); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := stream.Wait(cancelled, 1000); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestSettingsFromConfig(t *testing.T) {
+	cfg := &config.Config{DownloadRateLimit: "1MB", DownloadRateSchedule: "01:00-07:00=0"}
+
+	settings, err := SettingsFromConfig(cfg)
+	if err != nil {
+		t.Fatalf("SettingsFromConfig failed: %v", err)
+	}
+	if settings.Limit != 1024*1024 || len(settings.Schedule) != 1 {
+		t.Errorf("Unexpected settings %+v", settings)
+	}
+
+	if _, err := SettingsFromConfig(&config.Config{DownloadRateLimit: "fast"}); err == nil {
+		t.Error("Expected invalid rate to be rejected")
+	}
+}
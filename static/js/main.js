@@ -42,7 +42,8 @@
     cancelDownload: '/request/api/download',
     setPriority: '/request/api/queue',
     clearCompleted: '/request/api/queue/clear',
-    activeDownloads: '/request/api/downloads/active'
+    events: '/request/api/events',
+    login: '/request/login'
   };
   const FILTERS = ['isbn', 'author', 'title', 'lang', 'sort', 'content', 'format'];
 
@@ -52,9 +53,19 @@
     hide(node) { node && node.classList.add('hidden'); },
     async j(url, opts = {}) {
       const res = await fetch(url, opts);
+      utils.checkSession(res);
       if (!res.ok) throw new Error(`${res.status} ${res.statusText}`);
       return res.json();
     },
+    // Headers for requests that change state, with the CSRF token set at login
+    csrf() {
+      const m = document.cookie.match(/(?:^|;\s*)cwabd_csrf=([^;]*)/);
+      return m ? { 'X-CSRF-Token': decodeURIComponent(m[1]) } : {};
+    },
+    // Send the user to the login form when the session has ended
+    checkSession(res) {
+      if (res.status === 401) window.location.href = API.login;
+    },
     // Build query string from basic + advanced filters
     buildQuery() {
       const q = [];
@@ -103,6 +114,7 @@
         <div class="flex-1 space-y-1">
           <h3 class="font-semibold leading-tight">${utils.e(book.title) || 'Untitled'}</h3>
           <p class="text-sm opacity-80">${utils.e(book.author) || 'Unknown author'}</p>
+          ${book.in_library ? '<span class="inline-block text-xs px-2 py-0.5 rounded bg-green-600 text-white">Already in library</span>' : ''}
           <div class="text-xs opacity-70 flex flex-wrap gap-2">
             <span>${utils.e(book.year) || '-'}</span>
             <span>•</span>
@@ -198,13 +210,31 @@
           </div>
         </div>`;
     },
-    async download(book) {
+    async download(book, force = false) {
       if (!book) return;
       try {
-        await utils.j(`${API.download}?id=${encodeURIComponent(book.id)}`);
-        utils.toast('Queued for download');
+        const res = await fetch(`${API.download}?id=${encodeURIComponent(book.id)}${force ? '&force=true' : ''}`, { headers: utils.csrf() });
+        utils.checkSession(res);
+        const data = await res.json().catch(() => ({}));
+        if (res.status === 409 && data.status === 'in_library') {
+          // Let the user decide whether a second copy is wanted
+          if (confirm('This book is already in the Calibre library. Download it anyway?')) {
+            await this.download(book, true);
+          }
+          return;
+        }
+        if (res.status === 403) {
+          utils.toast('Your account is not allowed to download books');
+          return;
+        }
+        if (res.status === 429 && data.status === 'quota_exceeded') {
+          utils.toast(`Download limit reached: ${data.error}`);
+          return;
+        }
+        if (!res.ok) throw new Error(`${res.status} ${res.statusText}`);
+        utils.toast(data.warning ? `Queued for download (${data.warning.toLowerCase()})` : 'Queued for download');
         modal.close();
-        status.fetch();
+        live.refresh();
       } catch (_){}
     }
   };
@@ -215,14 +245,19 @@
       try {
         utils.show(el.statusLoading);
         const data = await utils.j(API.status);
-        this.render(data);
-        // Also reflect active downloads in the top section
-        this.renderTop(data);
-        this.updateActive();
+        live.books = data.queue_status || {};
+        this.show(live.books);
       } catch (e) {
         el.statusList.innerHTML = '<div class="text-sm opacity-80">Error loading status.</div>';
       } finally { utils.hide(el.statusLoading); }
     },
+    // Render the status list, the active downloads and their count
+    show(books) {
+      this.render(books);
+      this.renderTop(books);
+      const n = Object.keys(books.downloading || {}).length;
+      if (el.activeDownloadsCount) el.activeDownloadsCount.textContent = `Active: ${n}`;
+    },
     render(data) {
       // data shape: {queued: {...}, downloading: {...}, completed: {...}, error: {...}}
       const sections = [];
@@ -239,8 +274,12 @@
           const progress = (name === 'downloading' && typeof b.progress === 'number')
             ? `<div class="h-2 bg-black/10 rounded overflow-hidden"><div class="h-2 bg-blue-600" style="width:${Math.round(b.progress)}%"></div></div>`
             : '';
+          const waiting = (b.sub_state === 'waiting' && b.wait_seconds)
+            ? `<div class="text-xs opacity-70">Waiting for partner server (${Math.round(b.wait_seconds)}s)</div>`
+            : '';
           return `<li class="p-3 rounded border flex flex-col gap-2" style="border-color: var(--border-muted); background: var(--bg-soft)">
-            <div class="text-sm"><span class="opacity-70">${utils.e(name)}</span> • <strong>${maybeLinkedTitle}</strong></div>
+            <div class="text-sm"><span class="opacity-70">${utils.e(name)}</span> • <strong>${maybeLinkedTitle}</strong>${b.requested_by ? ` <span class="opacity-70">• ${utils.e(b.requested_by)}</span>` : ''}</div>
+            ${waiting}
             ${progress}
             <div class="flex items-center gap-2">${actions}</div>
           </li>`;
@@ -288,13 +327,6 @@
           btn.addEventListener('click', () => queue.cancel(btn.getAttribute('data-cancel')));
         });
       } catch (_) {}
-    },
-    async updateActive() {
-      try {
-        const d = await utils.j(API.activeDownloads);
-        const n = Array.isArray(d.active_downloads) ? d.active_downloads.length : 0;
-        if (el.activeDownloadsCount) el.activeDownloadsCount.textContent = `Active: ${n}`;
-      } catch (_) {}
     }
   };
 
@@ -302,8 +334,10 @@
   const queue = {
     async cancel(id) {
       try {
-        await fetch(`${API.cancelDownload}/${encodeURIComponent(id)}/cancel`, { method: 'DELETE' });
-        status.fetch();
+        const res = await fetch(`${API.cancelDownload}/${encodeURIComponent(id)}/cancel`, { method: 'DELETE', headers: utils.csrf() });
+        utils.checkSession(res);
+        if (res.status === 403) utils.toast('Only admins may cancel downloads of other users');
+        live.refresh();
       } catch (_){}
     }
   };
@@ -373,15 +407,93 @@
     el.refreshStatusBtn?.addEventListener('click', () => status.fetch());
     el.activeTopRefreshBtn?.addEventListener('click', () => status.fetch());
     el.clearCompletedBtn?.addEventListener('click', async () => {
-      try { await fetch(API.clearCompleted, { method: 'DELETE' }); status.fetch(); } catch (_) {}
+      try {
+        const res = await fetch(API.clearCompleted, { method: 'DELETE', headers: utils.csrf() });
+        utils.checkSession(res);
+        if (res.status === 403) utils.toast('Only admins may clear completed downloads');
+        live.refresh();
+      } catch (_) {}
     });
 
     // Close modal on overlay click
     el.modalOverlay?.addEventListener('click', (e) => { if (e.target === el.modalOverlay) modal.close(); });
   }
 
+  // ---- Live updates ----
+  // Keep the status list in sync with the server's event stream. The stream
+  // starts with a snapshot of the queue, every later event is applied to it
+  // here instead of fetching the status again. Reconnects get a new snapshot.
+  const live = {
+    books: {},
+    connected: false,
+    frame: null,
+    // Find the status section holding a book
+    find(id) {
+      return Object.keys(live.books).find((name) => live.books[name] && live.books[name][id]);
+    },
+    // Render at most once per frame, progress events come in bursts
+    schedule() {
+      if (live.frame) return;
+      live.frame = requestAnimationFrame(() => { live.frame = null; status.show(live.books); });
+    },
+    // Fetch the status only when no event stream reports the change
+    refresh() {
+      if (!live.connected) status.fetch();
+    },
+    apply(type, event) {
+      const name = live.find(event.book_id);
+      const book = name ? live.books[name][event.book_id] : null;
+      switch (type) {
+        case 'added':
+        case 'status': {
+          const next = event.book || book;
+          if (!next) return;
+          if (name) delete live.books[name][event.book_id];
+          const to = event.status || 'queued';
+          live.books[to] = live.books[to] || {};
+          live.books[to][event.book_id] = next;
+          break;
+        }
+        case 'progress':
+          if (book) book.progress = event.progress;
+          break;
+        case 'waiting':
+          if (!book) return;
+          book.wait_seconds = event.wait_seconds;
+          book.sub_state = event.wait_seconds > 0 ? 'waiting' : '';
+          break;
+        case 'priority':
+          if (book) book.priority = event.priority;
+          break;
+        case 'removed':
+          if (name) delete live.books[name][event.book_id];
+          break;
+        default:
+          return;
+      }
+      live.schedule();
+    },
+    init() {
+      if (!window.EventSource) {
+        status.fetch();
+        return;
+      }
+      const source = new EventSource(API.events);
+      source.addEventListener('snapshot', (msg) => {
+        live.connected = true;
+        live.books = JSON.parse(msg.data).queue_status || {};
+        live.schedule();
+      });
+      ['added', 'status', 'progress', 'waiting', 'priority', 'removed'].forEach((type) => {
+        source.addEventListener(type, (msg) => live.apply(type, JSON.parse(msg.data)));
+      });
+      // EventSource reconnects on its own and starts with a fresh snapshot
+      source.addEventListener('error', () => { live.connected = false; });
+    }
+  };
+
   // ---- Init ----
   theme.init();
   initEvents();
-  status.fetch();
+  live.init();
 })();